@@ -1,53 +1,468 @@
 package config
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"time"
+
+	"BrainyBuddyGo/pkg/defaults"
+	"BrainyBuddyGo/pkg/redis"
 
 	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
 )
 
+const (
+	DefaultConfigFile            = "config.yaml"
+	DefaultConversationStoreFile = "conversations.db"
+	DefaultLimiterStoreFile      = "limiter.db"
+	DefaultBudgetStoreFile       = "budget.db"
+	DefaultPromptFile            = defaults.PromptFile
+	ConfigFileEnv                = "BRAINYBUDDY_CONFIG"
+
+	// The handler reads its defaults from here, the other defaults are shared
+	// with the packages they tune by package defaults.
+	DefaultModerationMaxRetries = 3
+	// DefaultStreamEditInterval keeps progressive edits well below Discord's
+	// limit of five message edits per five seconds in a channel.
+	DefaultStreamEditInterval = 1500 * time.Millisecond
+	DefaultRequestTimeout     = 2 * time.Minute
+	DefaultShutdownTimeout    = 30 * time.Second
+	DefaultSharedHistory      = 20
+
+	ProviderOpenAI     = "openai"
+	ProviderCompatible = "openai-compatible"
+	ProviderAnthropic  = "anthropic"
+)
+
+// Configuration is built in layers: defaults, then the YAML file, then
+// environment variables (optionally from .env) and finally command line flags.
 type Configuration struct {
-	DiscordToken string
-	OpenAiToken  string
-	Production   bool
+	DiscordToken   string `yaml:"discord_token"`
+	OpenAiToken    string `yaml:"openai_token"`
+	AnthropicToken string `yaml:"anthropic_token"`
+	Production     bool   `yaml:"production"`
+
+	Provider   ProviderConfig     `yaml:"provider"`
+	Generation GenerationSettings `yaml:"generation"`
+	OpenAI     OpenAIConfig       `yaml:"openai"`
+	Limiter    LimiterConfig      `yaml:"limiter"`
+	Budget     BudgetConfig       `yaml:"budget"`
+	Handler    HandlerConfig      `yaml:"handler"`
+	Storage    StorageConfig      `yaml:"storage"`
+
+	Guilds *GuildSettings `yaml:"-"`
 }
 
-func Load(basepath string) (*Configuration, error) {
-	err := godotenv.Load(filepath.Join(basepath, ".env"))
+type ProviderConfig struct {
+	Kind    string `yaml:"kind"`
+	BaseURL string `yaml:"base_url"`
+	// APIKey defaults to the OpenAI or Anthropic token matching Kind.
+	APIKey string `yaml:"api_key"`
+}
 
-	if err != nil {
+type OpenAIConfig struct {
+	Workers               int           `yaml:"workers"`
+	MaxRetries            int           `yaml:"max_retries"`
+	CacheLifeTime         time.Duration `yaml:"cache_lifetime"`
+	ConversationCacheSize int           `yaml:"conversation_cache_size"`
+	PromptFile            string        `yaml:"prompt_file"`
+	PromptDir             string        `yaml:"prompt_dir"`
+	TokenizerFile         string        `yaml:"tokenizer_file"`
+	Summary               SummaryConfig `yaml:"summary"`
+	Breaker               BreakerConfig `yaml:"breaker"`
+	Queue                 QueueConfig   `yaml:"queue"`
+}
+
+type SummaryConfig struct {
+	Threshold    int    `yaml:"threshold"`
+	Model        string `yaml:"model"`
+	MaxTokens    int    `yaml:"max_tokens"`
+	KeepMessages int    `yaml:"keep_messages"`
+}
+
+type BreakerConfig struct {
+	FailureThreshold int           `yaml:"failure_threshold"`
+	OpenTimeout      time.Duration `yaml:"open_timeout"`
+}
+
+type QueueConfig struct {
+	MaxWaiting int `yaml:"max_waiting"`
+	MaxPerUser int `yaml:"max_per_user"`
+	// Priorities moves the questions of some roles ahead in the queue, by guild
+	// ID and role ID. The "boosters" role stands for the members boosting the guild.
+	Priorities map[string]map[string]int `yaml:"priorities"`
+}
+
+type LimiterConfig struct {
+	Strategy    defaults.Strategy `yaml:"strategy"`
+	MaxMessages int               `yaml:"max_messages"`
+	Window      time.Duration     `yaml:"window"`
+	// CountRejected counts refused questions against the limit too.
+	CountRejected bool `yaml:"count_rejected"`
+	// PruneInterval is how often the users back to a full quota are forgotten.
+	PruneInterval time.Duration `yaml:"prune_interval"`
+	// Guilds overrides the limits by guild ID, and within a guild by role ID.
+	Guilds map[string]GuildLimits `yaml:"guilds"`
+}
+
+// LimitOverride replaces the fields of a limit that are set.
+type LimitOverride struct {
+	Strategy    defaults.Strategy `yaml:"strategy"`
+	MaxMessages int               `yaml:"max_messages"`
+	Window      time.Duration     `yaml:"window"`
+	Unlimited   bool              `yaml:"unlimited"`
+}
+
+type GuildLimits struct {
+	LimitOverride `yaml:",inline"`
+	// Roles are keyed by role ID, or "boosters" for the members boosting the guild.
+	Roles map[string]LimitOverride `yaml:"roles"`
+}
+
+// BudgetConfig caps the spending on the API. Zero limits are disabled.
+type BudgetConfig struct {
+	UserDaily     BudgetLimit `yaml:"user_daily"`
+	GuildMonthly  BudgetLimit `yaml:"guild_monthly"`
+	GlobalMonthly BudgetLimit `yaml:"global_monthly"`
+	// WarnAt lists the fractions of a budget at which users are warned.
+	WarnAt []float64 `yaml:"warn_at"`
+	// Prices in dollars per 1000 tokens by model, added to the built-in prices
+	// of the OpenAI models.
+	Prices map[string]ModelPrice `yaml:"prices"`
+}
+
+type BudgetLimit struct {
+	Tokens  int     `yaml:"tokens"`
+	Dollars float64 `yaml:"dollars"`
+}
+
+type ModelPrice struct {
+	Prompt     float64 `yaml:"prompt"`
+	Completion float64 `yaml:"completion"`
+}
+
+type HandlerConfig struct {
+	// ModerationMaxRetries is the number of attempts of a moderation request,
+	// the first one included, unlike openai.max_retries.
+	ModerationMaxRetries int           `yaml:"moderation_max_retries"`
+	StreamEditInterval   time.Duration `yaml:"stream_edit_interval"`
+	RequestTimeout       time.Duration `yaml:"request_timeout"`
+	ShutdownTimeout      time.Duration `yaml:"shutdown_timeout"`
+	// SharedHistory is the number of messages kept per channel to show the
+	// model what was said between questions in a shared conversation.
+	SharedHistory int `yaml:"shared_history"`
+}
+
+type StorageConfig struct {
+	Conversations string `yaml:"conversations"`
+	GuildSettings string `yaml:"guild_settings"`
+	// Limiter is the file keeping the rate limits, unless LimiterRedis is set.
+	Limiter string `yaml:"limiter"`
+	// LimiterRedis keeps the rate limits in a Redis compatible server instead,
+	// as redis://[:password@]host[:port][/db].
+	LimiterRedis string `yaml:"limiter_redis"`
+	// Budget is the file keeping what was spent on the budgets.
+	Budget string `yaml:"budget"`
+}
+
+func DefaultConfiguration() *Configuration {
+	return &Configuration{
+		Provider: ProviderConfig{
+			Kind: ProviderOpenAI,
+		},
+		OpenAI: OpenAIConfig{
+			Workers:               defaults.Workers,
+			MaxRetries:            defaults.MaxRetries,
+			CacheLifeTime:         defaults.CacheLifeTime,
+			ConversationCacheSize: defaults.ConversationCacheSize,
+			PromptFile:            DefaultPromptFile,
+			Summary: SummaryConfig{
+				Threshold:    defaults.SummaryThreshold,
+				MaxTokens:    defaults.SummaryMaxTokens,
+				KeepMessages: defaults.SummaryKeepMessages,
+			},
+			Breaker: BreakerConfig{
+				FailureThreshold: defaults.BreakerFailureThreshold,
+				OpenTimeout:      defaults.BreakerOpenTimeout,
+			},
+			Queue: QueueConfig{
+				MaxWaiting: defaults.QueueMaxWaiting,
+				MaxPerUser: defaults.QueueMaxPerUser,
+			},
+		},
+		Limiter: LimiterConfig{
+			Strategy:      defaults.LimiterStrategy,
+			MaxMessages:   defaults.LimiterMaxMessages,
+			Window:        defaults.LimiterWindow,
+			PruneInterval: defaults.LimiterPruneInterval,
+		},
+		Budget: BudgetConfig{
+			WarnAt: []float64{defaults.BudgetWarnAt},
+		},
+		Handler: HandlerConfig{
+			ModerationMaxRetries: DefaultModerationMaxRetries,
+			StreamEditInterval:   DefaultStreamEditInterval,
+			RequestTimeout:       DefaultRequestTimeout,
+			ShutdownTimeout:      DefaultShutdownTimeout,
+			SharedHistory:        DefaultSharedHistory,
+		},
+		Storage: StorageConfig{
+			Conversations: DefaultConversationStoreFile,
+			GuildSettings: DefaultGuildSettingsFile,
+			Limiter:       DefaultLimiterStoreFile,
+			Budget:        DefaultBudgetStoreFile,
+		},
+	}
+}
+
+// Load builds the configuration from the layers and validates it. Relative
+// paths are resolved against basepath and args are the command line flags.
+func Load(basepath string, args []string) (*Configuration, error) {
+	cfg := DefaultConfiguration()
+
+	fs := flag.NewFlagSet("brainybuddy", flag.ContinueOnError)
+	configFile := fs.String("config", "", "path to a YAML configuration file")
+
+	// Flags are applied last, after the file and the environment.
+	var flagValues []func(cfg *Configuration) error
+	for _, s := range settings {
+		if s.flag == "" {
+			continue
+		}
+		s := s
+		fs.Func(s.flag, s.usage, func(value string) error {
+			flagValues = append(flagValues, func(cfg *Configuration) error {
+				return s.set(cfg, value)
+			})
+			return nil
+		})
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if err := loadFile(cfg, basepath, *configFile); err != nil {
+		return nil, err
+	}
+
+	// The .env file is optional, containers usually set the variables directly.
+	// It is read without touching the process environment so a reload sees edits.
+	dotEnv, err := godotenv.Read(filepath.Join(basepath, ".env"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
 		return nil, fmt.Errorf("Error loading .env file: %w", err)
 	}
 
-	discordToken, err := getEnvVariable("DISCORD_BOT_TOKEN")
-	if err != nil {
+	if err := applyEnv(cfg, dotEnv); err != nil {
+		return nil, err
+	}
+
+	for _, apply := range flagValues {
+		if err := apply(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	cfg.resolve(basepath)
+
+	if err := cfg.Validate(); err != nil {
 		return nil, err
 	}
 
-	openAiToken, err := getEnvVariable("OPENAI_API_KEY")
+	guilds, err := LoadGuildSettings(cfg.Storage.GuildSettings)
 	if err != nil {
 		return nil, err
 	}
+	cfg.Guilds = guilds
+
+	return cfg, nil
+}
 
-	production := false
-	productionEnv := os.Getenv("PRODUCTION")
-	if productionEnv == "true" {
-		production = true
+// loadFile reads the YAML file given by the flag or BRAINYBUDDY_CONFIG. Without
+// either, config.yaml is read from basepath when it exists.
+func loadFile(cfg *Configuration, basepath string, path string) error {
+	if path == "" {
+		path = os.Getenv(ConfigFileEnv)
 	}
 
-	return &Configuration{
-		DiscordToken: discordToken,
-		OpenAiToken:  openAiToken,
-		Production:   production,
-	}, nil
+	explicit := path != ""
+	if !explicit {
+		path = filepath.Join(basepath, DefaultConfigFile)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read configuration file: %w", err)
+	}
+
+	return parseYAML(cfg, data)
+}
+
+func parseYAML(cfg *Configuration, data []byte) error {
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return fmt.Errorf("failed to decode configuration file: %w", err)
+	}
+	return nil
+}
+
+// resolve fills values derived from others once every layer is applied.
+func (c *Configuration) resolve(basepath string) {
+	if c.Provider.APIKey == "" {
+		switch c.Provider.Kind {
+		case ProviderOpenAI:
+			c.Provider.APIKey = c.OpenAiToken
+		case ProviderAnthropic:
+			c.Provider.APIKey = c.AnthropicToken
+		}
+	}
+
+	c.OpenAI.PromptFile = resolvePath(basepath, c.OpenAI.PromptFile)
+	c.OpenAI.PromptDir = resolvePath(basepath, c.OpenAI.PromptDir)
+	c.OpenAI.TokenizerFile = resolvePath(basepath, c.OpenAI.TokenizerFile)
+	c.Storage.Conversations = resolvePath(basepath, c.Storage.Conversations)
+	c.Storage.GuildSettings = resolvePath(basepath, c.Storage.GuildSettings)
+	c.Storage.Limiter = resolvePath(basepath, c.Storage.Limiter)
+	c.Storage.Budget = resolvePath(basepath, c.Storage.Budget)
+}
+
+func resolvePath(basepath string, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(basepath, path)
+}
+
+func (c *Configuration) Validate() error {
+	if c.DiscordToken == "" {
+		return fmt.Errorf("DISCORD_BOT_TOKEN not set")
+	}
+
+	switch c.Provider.Kind {
+	case ProviderOpenAI:
+		if c.Provider.APIKey == "" {
+			return fmt.Errorf("OPENAI_API_KEY not set")
+		}
+	case ProviderAnthropic:
+		if c.Provider.APIKey == "" {
+			return fmt.Errorf("ANTHROPIC_API_KEY not set")
+		}
+		if t := c.Generation.Temperature; t != nil && *t > defaults.AnthropicMaxTemperature {
+			return fmt.Errorf("temperature must be between 0 and %d with the %s provider, got %v", defaults.AnthropicMaxTemperature, ProviderAnthropic, *t)
+		}
+	case ProviderCompatible:
+		if c.Provider.BaseURL == "" {
+			return fmt.Errorf("LLM_BASE_URL is required by the %s provider", ProviderCompatible)
+		}
+	default:
+		return fmt.Errorf("unknown provider %q", c.Provider.Kind)
+	}
+
+	if err := c.Generation.Validate(); err != nil {
+		return fmt.Errorf("invalid generation settings: %w", err)
+	}
+
+	checks := []struct {
+		ok  bool
+		msg string
+	}{
+		{c.OpenAI.Workers > 0, "openai.workers must be positive"},
+		{c.OpenAI.MaxRetries >= 0, "openai.max_retries cannot be negative"},
+		{c.OpenAI.CacheLifeTime > 0, "openai.cache_lifetime must be positive"},
+		{c.OpenAI.ConversationCacheSize > 0, "openai.conversation_cache_size must be positive"},
+		{c.OpenAI.PromptFile != "" || c.OpenAI.PromptDir != "", "openai.prompt_file or openai.prompt_dir must be set"},
+		{c.OpenAI.Summary.Threshold >= 0, "openai.summary.threshold cannot be negative"},
+		{c.OpenAI.Summary.MaxTokens > 0, "openai.summary.max_tokens must be positive"},
+		{c.OpenAI.Summary.KeepMessages >= 0, "openai.summary.keep_messages cannot be negative"},
+		{c.OpenAI.Breaker.FailureThreshold >= 0, "openai.breaker.failure_threshold cannot be negative"},
+		{c.OpenAI.Breaker.OpenTimeout > 0, "openai.breaker.open_timeout must be positive"},
+		{c.OpenAI.Queue.MaxWaiting >= 0, "openai.queue.max_waiting cannot be negative"},
+		{c.OpenAI.Queue.MaxPerUser >= 0, "openai.queue.max_per_user cannot be negative"},
+		{validLimiterStrategy(c.Limiter.Strategy, false), fmt.Sprintf("unknown limiter.strategy %q", c.Limiter.Strategy)},
+		{c.Limiter.MaxMessages > 0, "limiter.max_messages must be positive"},
+		{c.Limiter.Window > 0, "limiter.window must be positive"},
+		{c.Limiter.PruneInterval > 0, "limiter.prune_interval must be positive"},
+		{c.Budget.UserDaily.valid(), "budget.user_daily cannot be negative"},
+		{c.Budget.GuildMonthly.valid(), "budget.guild_monthly cannot be negative"},
+		{c.Budget.GlobalMonthly.valid(), "budget.global_monthly cannot be negative"},
+		{c.Handler.ModerationMaxRetries > 0, "handler.moderation_max_retries must be positive"},
+		{c.Handler.StreamEditInterval >= time.Second, "handler.stream_edit_interval must be at least 1s"},
+		{c.Handler.RequestTimeout >= 0, "handler.request_timeout cannot be negative"},
+		{c.Handler.ShutdownTimeout > 0, "handler.shutdown_timeout must be positive"},
+		{c.Handler.SharedHistory >= 0, "handler.shared_history cannot be negative"},
+		{c.Storage.Conversations != "", "storage.conversations cannot be empty"},
+		{c.Storage.GuildSettings != "", "storage.guild_settings cannot be empty"},
+		{c.Storage.Limiter != "" || c.Storage.LimiterRedis != "", "storage.limiter or storage.limiter_redis must be set"},
+		{c.Storage.Budget != "", "storage.budget cannot be empty"},
+	}
+	for _, check := range checks {
+		if !check.ok {
+			return errors.New(check.msg)
+		}
+	}
+
+	if c.Storage.LimiterRedis != "" {
+		if _, err := redis.ParseURL(c.Storage.LimiterRedis); err != nil {
+			return fmt.Errorf("storage.limiter_redis: %w", err)
+		}
+	}
+
+	for _, fraction := range c.Budget.WarnAt {
+		if fraction <= 0 || fraction >= 1 {
+			return fmt.Errorf("budget.warn_at must be between 0 and 1, got %v", fraction)
+		}
+	}
+	for model, price := range c.Budget.Prices {
+		if price.Prompt < 0 || price.Completion < 0 {
+			return fmt.Errorf("budget.prices.%s cannot be negative", model)
+		}
+	}
+
+	for guildID, guild := range c.Limiter.Guilds {
+		if err := guild.LimitOverride.validate(fmt.Sprintf("limiter.guilds.%s", guildID)); err != nil {
+			return err
+		}
+		for roleID, role := range guild.Roles {
+			if err := role.validate(fmt.Sprintf("limiter.guilds.%s.roles.%s", guildID, roleID)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (l BudgetLimit) valid() bool {
+	return l.Tokens >= 0 && l.Dollars >= 0
+}
+
+func (o LimitOverride) validate(path string) error {
+	if !validLimiterStrategy(o.Strategy, true) {
+		return fmt.Errorf("unknown %s.strategy %q", path, o.Strategy)
+	}
+	if o.MaxMessages < 0 {
+		return fmt.Errorf("%s.max_messages cannot be negative", path)
+	}
+	if o.Window < 0 {
+		return fmt.Errorf("%s.window cannot be negative", path)
+	}
+	return nil
 }
 
-func getEnvVariable(key string) (string, error) {
-	value := os.Getenv(key)
-	if value == "" {
-		return "", fmt.Errorf("%s not set", key)
+func validLimiterStrategy(strategy defaults.Strategy, allowEmpty bool) bool {
+	switch strategy {
+	case defaults.SlidingWindow, defaults.TokenBucket, defaults.DailyQuota:
+		return true
+	case "":
+		return allowEmpty
+	default:
+		return false
 	}
-	return value, nil
 }
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// restartFields are only read at startup, changing them at runtime is logged
+// but has no effect until the bot restarts.
+var restartFields = []string{
+	"discord_token",
+	"openai_token",
+	"anthropic_token",
+	"provider.",
+	"openai.workers",
+	"openai.cache_lifetime",
+	"openai.tokenizer_file",
+	"limiter.prune_interval",
+	"storage.",
+}
+
+// Change is a setting whose value differs between two configurations. Field is
+// the path of the setting in the YAML file.
+type Change struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// RequiresRestart reports whether the setting is only read at startup.
+func (c Change) RequiresRestart() bool {
+	for _, field := range restartFields {
+		if c.Field == field || (strings.HasSuffix(field, ".") && strings.HasPrefix(c.Field, field)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.Field, c.Old, c.New)
+}
+
+// Diff lists the settings that differ between two configurations. Secrets are
+// reported as changed without their values.
+func Diff(old *Configuration, new *Configuration) []Change {
+	var changes []Change
+	diffStruct("", reflect.ValueOf(*old), reflect.ValueOf(*new), &changes)
+	return changes
+}
+
+func diffStruct(prefix string, old reflect.Value, new reflect.Value, changes *[]Change) {
+	for i := 0; i < old.NumField(); i++ {
+		field := old.Type().Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		name = prefix + name
+
+		oldValue, newValue := old.Field(i), new.Field(i)
+		if field.Type.Kind() == reflect.Struct {
+			diffStruct(name+".", oldValue, newValue, changes)
+			continue
+		}
+
+		oldText, newText := formatValue(oldValue), formatValue(newValue)
+		if oldText == newText {
+			continue
+		}
+
+		if isSecret(name) {
+			oldText, newText = "***", "***"
+		}
+		*changes = append(*changes, Change{Field: name, Old: oldText, New: newText})
+	}
+}
+
+func formatValue(value reflect.Value) string {
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return "unset"
+		}
+		value = value.Elem()
+	}
+	if value.Kind() == reflect.String {
+		return fmt.Sprintf("%q", value.String())
+	}
+	return fmt.Sprint(value.Interface())
+}
+
+func isSecret(name string) bool {
+	return strings.HasSuffix(name, "token") || strings.HasSuffix(name, "api_key")
+}
@@ -0,0 +1,122 @@
+package config
+
+import "fmt"
+
+// Conversation scopes decide who shares a conversation with the bot.
+const (
+	// ConversationPerUser gives every user their own conversation in each
+	// channel and thread.
+	ConversationPerUser = "user"
+	// ConversationPerThread shares a conversation between everyone in a
+	// thread, and keeps one per user in channels.
+	ConversationPerThread = "thread"
+	// ConversationPerChannel shares a conversation between everyone in a
+	// channel or thread.
+	ConversationPerChannel = "channel"
+)
+
+// GenerationSettings overrides generation parameters. Empty fields inherit the
+// value of the enclosing level: global, then guild, then channel.
+type GenerationSettings struct {
+	Model       string   `json:"model,omitempty" yaml:"model"`
+	Temperature *float32 `json:"temperature,omitempty" yaml:"temperature"`
+	MaxTokens   int      `json:"max_tokens,omitempty" yaml:"max_tokens"`
+	// Profile names the prompt profile used by new conversations.
+	Profile string `json:"profile,omitempty" yaml:"profile"`
+	// Conversation is the conversation scope, ConversationPerUser when empty.
+	Conversation string `json:"conversation,omitempty" yaml:"conversation"`
+}
+
+// Merge returns the settings with the non-empty fields of override applied.
+func (g GenerationSettings) Merge(override GenerationSettings) GenerationSettings {
+	if override.Model != "" {
+		g.Model = override.Model
+	}
+	if override.Temperature != nil {
+		temperature := *override.Temperature
+		g.Temperature = &temperature
+	}
+	if override.MaxTokens != 0 {
+		g.MaxTokens = override.MaxTokens
+	}
+	if override.Profile != "" {
+		g.Profile = override.Profile
+	}
+	if override.Conversation != "" {
+		g.Conversation = override.Conversation
+	}
+	return g
+}
+
+func (g GenerationSettings) Validate() error {
+	if g.Temperature != nil && (*g.Temperature < 0 || *g.Temperature > 2) {
+		return fmt.Errorf("temperature must be between 0 and 2, got %v", *g.Temperature)
+	}
+	if g.MaxTokens < 0 {
+		return fmt.Errorf("max tokens cannot be negative, got %d", g.MaxTokens)
+	}
+	switch g.Conversation {
+	case "", ConversationPerUser, ConversationPerThread, ConversationPerChannel:
+	default:
+		return fmt.Errorf("unknown conversation scope %q", g.Conversation)
+	}
+	return nil
+}
+
+// Generation resolves the generation overrides of a channel: the guild settings,
+// then those of the category, the parent channel of a thread and the channel.
+func (g *GuildSettings) Generation(scope ChannelScope) GenerationSettings {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	guild, ok := g.guilds[scope.GuildID]
+	if !ok {
+		return GenerationSettings{}
+	}
+
+	settings := GenerationSettings{}.Merge(guild.Generation)
+	for _, id := range []string{scope.CategoryID, scope.ParentID, scope.ChannelID} {
+		if id == "" {
+			continue
+		}
+		if override, ok := guild.ChannelGeneration[id]; ok {
+			settings = settings.Merge(override)
+		}
+	}
+	return settings
+}
+
+// SharesConversations reports whether a guild, category or channel sets a
+// conversation scope shared by its users.
+func (g *GuildSettings) SharesConversations() bool {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	for _, guild := range g.guilds {
+		if guild.Generation.sharesConversation() {
+			return true
+		}
+		for _, settings := range guild.ChannelGeneration {
+			if settings.sharesConversation() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (g GenerationSettings) sharesConversation() bool {
+	return g.Conversation == ConversationPerThread || g.Conversation == ConversationPerChannel
+}
+
+func (g *GuildConfig) validate() error {
+	if err := g.Generation.Validate(); err != nil {
+		return err
+	}
+	for channelID, settings := range g.ChannelGeneration {
+		if err := settings.Validate(); err != nil {
+			return fmt.Errorf("channel %s: %w", channelID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const DefaultGuildSettingsFile = "guilds.json"
+
+// GuildConfig holds the settings admins can edit for a single guild.
+type GuildConfig struct {
+	AllowedChannels   []string `json:"allowed_channels,omitempty"`
+	AllowedCategories []string `json:"allowed_categories,omitempty"`
+	AllowThreads      bool     `json:"allow_threads"`
+
+	Generation        GenerationSettings            `json:"generation,omitempty"`
+	ChannelGeneration map[string]GenerationSettings `json:"channel_generation,omitempty"`
+}
+
+// ChannelScope describes where a message was posted. ParentID is only set for
+// threads and CategoryID is the category of the channel (or of the thread's parent).
+type ChannelScope struct {
+	GuildID     string
+	ChannelName string
+	ChannelID   string
+	ParentID    string
+	CategoryID  string
+	IsThread    bool
+}
+
+// GuildSettings is the per-guild configuration, persisted as JSON so edits made
+// through commands survive restarts.
+type GuildSettings struct {
+	path   string
+	guilds map[string]*GuildConfig
+	mutex  sync.RWMutex
+}
+
+func LoadGuildSettings(path string) (*GuildSettings, error) {
+	settings := &GuildSettings{
+		path:   path,
+		guilds: make(map[string]*GuildConfig),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return settings, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read guild settings: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &settings.guilds); err != nil {
+		return nil, fmt.Errorf("failed to decode guild settings: %w", err)
+	}
+
+	for guildID, guild := range settings.guilds {
+		if err := guild.validate(); err != nil {
+			return nil, fmt.Errorf("invalid settings for guild %s: %w", guildID, err)
+		}
+	}
+
+	return settings, nil
+}
+
+// Replace swaps in the guilds of other, typically settings loaded again after
+// the file was edited by hand.
+func (g *GuildSettings) Replace(other *GuildSettings) {
+	other.mutex.RLock()
+	path, guilds := other.path, other.guilds
+	other.mutex.RUnlock()
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.path = path
+	g.guilds = guilds
+}
+
+// Guild returns a copy of the configuration of a guild.
+func (g *GuildSettings) Guild(guildID string) GuildConfig {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	guild, ok := g.guilds[guildID]
+	if !ok {
+		return GuildConfig{}
+	}
+	return guild.clone()
+}
+
+// clone returns a deep copy of the configuration.
+func (c *GuildConfig) clone() GuildConfig {
+	channelGeneration := make(map[string]GenerationSettings, len(c.ChannelGeneration))
+	for channelID, settings := range c.ChannelGeneration {
+		channelGeneration[channelID] = GenerationSettings{}.Merge(settings)
+	}
+
+	return GuildConfig{
+		AllowedChannels:   append([]string(nil), c.AllowedChannels...),
+		AllowedCategories: append([]string(nil), c.AllowedCategories...),
+		AllowThreads:      c.AllowThreads,
+		Generation:        GenerationSettings{}.Merge(c.Generation),
+		ChannelGeneration: channelGeneration,
+	}
+}
+
+func (g *GuildSettings) IsAllowed(scope ChannelScope) bool {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	guild, ok := g.guilds[scope.GuildID]
+	if !ok {
+		return false
+	}
+
+	if scope.CategoryID != "" && contains(guild.AllowedCategories, scope.CategoryID) {
+		return !scope.IsThread || guild.AllowThreads
+	}
+
+	if scope.IsThread {
+		return guild.AllowThreads && contains(guild.AllowedChannels, scope.ParentID)
+	}
+
+	return contains(guild.AllowedChannels, scope.ChannelID)
+}
+
+// HasAllowedChannels reports whether any channel or category of the guild is
+// allowed. The bot stays silent in guilds where none is.
+func (g *GuildSettings) HasAllowedChannels(guildID string) bool {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	guild, ok := g.guilds[guildID]
+	return ok && (len(guild.AllowedChannels) > 0 || len(guild.AllowedCategories) > 0)
+}
+
+func (g *GuildSettings) AllowChannel(guildID string, channelID string) error {
+	return g.update(guildID, func(guild *GuildConfig) {
+		guild.AllowedChannels = addUnique(guild.AllowedChannels, channelID)
+	})
+}
+
+func (g *GuildSettings) DenyChannel(guildID string, channelID string) error {
+	return g.update(guildID, func(guild *GuildConfig) {
+		guild.AllowedChannels = remove(guild.AllowedChannels, channelID)
+	})
+}
+
+func (g *GuildSettings) AllowCategory(guildID string, categoryID string) error {
+	return g.update(guildID, func(guild *GuildConfig) {
+		guild.AllowedCategories = addUnique(guild.AllowedCategories, categoryID)
+	})
+}
+
+func (g *GuildSettings) DenyCategory(guildID string, categoryID string) error {
+	return g.update(guildID, func(guild *GuildConfig) {
+		guild.AllowedCategories = remove(guild.AllowedCategories, categoryID)
+	})
+}
+
+func (g *GuildSettings) SetAllowThreads(guildID string, allow bool) error {
+	return g.update(guildID, func(guild *GuildConfig) {
+		guild.AllowThreads = allow
+	})
+}
+
+func (g *GuildSettings) update(guildID string, apply func(guild *GuildConfig)) error {
+	if guildID == "" {
+		return errors.New("guild ID cannot be empty")
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	guild, ok := g.guilds[guildID]
+	if !ok {
+		guild = &GuildConfig{}
+		g.guilds[guildID] = guild
+	}
+	previous := guild.clone()
+	apply(guild)
+
+	// A change that is not saved would be lost on restart, so it is undone
+	if err := g.save(); err != nil {
+		if ok {
+			*guild = previous
+		} else {
+			delete(g.guilds, guildID)
+		}
+		return err
+	}
+	return nil
+}
+
+// save writes the settings to a temporary file and renames it, so a crash
+// never leaves a truncated file behind. The caller must hold the lock.
+func (g *GuildSettings) save() error {
+	if g.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(g.guilds, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode guild settings: %w", err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(g.path), filepath.Base(g.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to save guild settings: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save guild settings: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save guild settings: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), g.path); err != nil {
+		return fmt.Errorf("failed to save guild settings: %w", err)
+	}
+
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func addUnique(values []string, value string) []string {
+	if contains(values, value) {
+		return values
+	}
+	return append(values, value)
+}
+
+func remove(values []string, value string) []string {
+	result := values[:0]
+	for _, v := range values {
+		if v != value {
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -0,0 +1,175 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"BrainyBuddyGo/pkg/defaults"
+)
+
+// setting maps an environment variable and a command line flag to a field of
+// the configuration. Either name may be empty.
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	set   func(cfg *Configuration, value string) error
+}
+
+var settings = []setting{
+	{"DISCORD_BOT_TOKEN", "", "Discord bot token", setString(func(c *Configuration) *string { return &c.DiscordToken })},
+	{"OPENAI_API_KEY", "", "OpenAI API key", setString(func(c *Configuration) *string { return &c.OpenAiToken })},
+	{"ANTHROPIC_API_KEY", "", "Anthropic API key", setString(func(c *Configuration) *string { return &c.AnthropicToken })},
+	{"PRODUCTION", "production", "use the production prompt", setBool(func(c *Configuration) *bool { return &c.Production })},
+
+	{"LLM_PROVIDER", "provider", "LLM provider: openai, openai-compatible or anthropic", setString(func(c *Configuration) *string { return &c.Provider.Kind })},
+	{"LLM_BASE_URL", "base-url", "base URL of the LLM API", setString(func(c *Configuration) *string { return &c.Provider.BaseURL })},
+	{"LLM_API_KEY", "", "API key of the LLM provider", setString(func(c *Configuration) *string { return &c.Provider.APIKey })},
+
+	{"LLM_MODEL", "model", "model used for answers", setString(func(c *Configuration) *string { return &c.Generation.Model })},
+	{"LLM_TEMPERATURE", "temperature", "sampling temperature", setTemperature},
+	{"LLM_MAX_TOKENS", "max-tokens", "maximum tokens per answer", setInt(func(c *Configuration) *int { return &c.Generation.MaxTokens })},
+	{"PROMPT_PROFILE", "profile", "default prompt profile", setString(func(c *Configuration) *string { return &c.Generation.Profile })},
+	{"CONVERSATION_SCOPE", "conversation", "who shares a conversation: user, thread or channel", setString(func(c *Configuration) *string { return &c.Generation.Conversation })},
+
+	{"OPENAI_WORKERS", "workers", "concurrent LLM requests", setInt(func(c *Configuration) *int { return &c.OpenAI.Workers })},
+	{"OPENAI_MAX_RETRIES", "max-retries", "retries of failed LLM requests", setInt(func(c *Configuration) *int { return &c.OpenAI.MaxRetries })},
+	{"CACHE_LIFETIME", "cache-lifetime", "how long conversations are remembered", setDuration(func(c *Configuration) *time.Duration { return &c.OpenAI.CacheLifeTime })},
+	{"CONVERSATION_CACHE_SIZE", "", "conversations remembered per user", setInt(func(c *Configuration) *int { return &c.OpenAI.ConversationCacheSize })},
+	{"PROMPT_FILE", "prompt-file", "legacy prompt file", setString(func(c *Configuration) *string { return &c.OpenAI.PromptFile })},
+	{"PROMPT_DIR", "prompt-dir", "directory of prompt profiles", setString(func(c *Configuration) *string { return &c.OpenAI.PromptDir })},
+	{"TOKENIZER_FILE", "tokenizer-file", "tiktoken rank file used to count tokens", setString(func(c *Configuration) *string { return &c.OpenAI.TokenizerFile })},
+	{"SUMMARY_THRESHOLD", "", "history tokens above which old turns are summarized, 0 disables", setInt(func(c *Configuration) *int { return &c.OpenAI.Summary.Threshold })},
+	{"SUMMARY_MODEL", "", "model used for summaries", setString(func(c *Configuration) *string { return &c.OpenAI.Summary.Model })},
+	{"SUMMARY_MAX_TOKENS", "", "maximum tokens of a summary", setInt(func(c *Configuration) *int { return &c.OpenAI.Summary.MaxTokens })},
+	{"BREAKER_FAILURE_THRESHOLD", "", "consecutive LLM failures pausing requests, 0 disables", setInt(func(c *Configuration) *int { return &c.OpenAI.Breaker.FailureThreshold })},
+	{"BREAKER_OPEN_TIMEOUT", "", "pause before probing a failing LLM again", setDuration(func(c *Configuration) *time.Duration { return &c.OpenAI.Breaker.OpenTimeout })},
+
+	{"QUEUE_MAX_WAITING", "", "questions waiting for a worker above which new ones are refused, 0 disables", setInt(func(c *Configuration) *int { return &c.OpenAI.Queue.MaxWaiting })},
+	{"QUEUE_MAX_PER_USER", "", "workers a single user can hold, 0 disables", setInt(func(c *Configuration) *int { return &c.OpenAI.Queue.MaxPerUser })},
+
+	{"LIMITER_STRATEGY", "limiter-strategy", "rate limit strategy: sliding_window, token_bucket or daily_quota", setLimiterStrategy},
+	{"LIMITER_MAX_MESSAGES", "limiter-max-messages", "questions allowed per user in a window", setInt(func(c *Configuration) *int { return &c.Limiter.MaxMessages })},
+	{"LIMITER_WINDOW", "limiter-window", "rate limit window", setDuration(func(c *Configuration) *time.Duration { return &c.Limiter.Window })},
+	{"LIMITER_COUNT_REJECTED", "", "count refused questions against the limit too", setBool(func(c *Configuration) *bool { return &c.Limiter.CountRejected })},
+	{"LIMITER_PRUNE_INTERVAL", "", "how often users back to a full quota are forgotten", setDuration(func(c *Configuration) *time.Duration { return &c.Limiter.PruneInterval })},
+
+	{"BUDGET_USER_DAILY_TOKENS", "", "tokens a user can spend per day, 0 disables", setInt(func(c *Configuration) *int { return &c.Budget.UserDaily.Tokens })},
+	{"BUDGET_USER_DAILY_DOLLARS", "", "dollars a user can spend per day, 0 disables", setFloat(func(c *Configuration) *float64 { return &c.Budget.UserDaily.Dollars })},
+	{"BUDGET_GUILD_MONTHLY_TOKENS", "", "tokens a server can spend per month, 0 disables", setInt(func(c *Configuration) *int { return &c.Budget.GuildMonthly.Tokens })},
+	{"BUDGET_GUILD_MONTHLY_DOLLARS", "", "dollars a server can spend per month, 0 disables", setFloat(func(c *Configuration) *float64 { return &c.Budget.GuildMonthly.Dollars })},
+	{"BUDGET_GLOBAL_MONTHLY_TOKENS", "", "tokens the bot can spend per month, 0 disables", setInt(func(c *Configuration) *int { return &c.Budget.GlobalMonthly.Tokens })},
+	{"BUDGET_GLOBAL_MONTHLY_DOLLARS", "", "dollars the bot can spend per month, 0 disables", setFloat(func(c *Configuration) *float64 { return &c.Budget.GlobalMonthly.Dollars })},
+
+	{"MODERATION_MAX_RETRIES", "", "attempts of a moderation request, the first one included", setInt(func(c *Configuration) *int { return &c.Handler.ModerationMaxRetries })},
+	{"STREAM_EDIT_INTERVAL", "", "minimum time between edits of a streamed answer", setDuration(func(c *Configuration) *time.Duration { return &c.Handler.StreamEditInterval })},
+	{"REQUEST_TIMEOUT", "request-timeout", "deadline for answering a single question, 0 disables", setDuration(func(c *Configuration) *time.Duration { return &c.Handler.RequestTimeout })},
+	{"SHARED_HISTORY", "", "messages of others kept for shared conversations, per channel", setInt(func(c *Configuration) *int { return &c.Handler.SharedHistory })},
+	{"SHUTDOWN_TIMEOUT", "shutdown-timeout", "time given to questions in flight when stopping", setDuration(func(c *Configuration) *time.Duration { return &c.Handler.ShutdownTimeout })},
+
+	{"CONVERSATION_STORE_PATH", "conversation-store", "conversation database file", setString(func(c *Configuration) *string { return &c.Storage.Conversations })},
+	{"GUILD_SETTINGS_FILE", "guild-settings", "per guild settings file", setString(func(c *Configuration) *string { return &c.Storage.GuildSettings })},
+	{"LIMITER_STORE_PATH", "limiter-store", "rate limit database file", setString(func(c *Configuration) *string { return &c.Storage.Limiter })},
+	{"BUDGET_STORE_PATH", "budget-store", "budget database file", setString(func(c *Configuration) *string { return &c.Storage.Budget })},
+	{"LIMITER_REDIS_URL", "limiter-redis", "Redis URL keeping the rate limits instead of the file", setString(func(c *Configuration) *string { return &c.Storage.LimiterRedis })},
+}
+
+// applyEnv sets the values of the environment. Variables of the process take
+// precedence over the ones read from .env.
+func applyEnv(cfg *Configuration, dotEnv map[string]string) error {
+	for _, s := range settings {
+		if s.env == "" {
+			continue
+		}
+		value, ok := os.LookupEnv(s.env)
+		if !ok {
+			value = dotEnv[s.env]
+		}
+		if value == "" {
+			continue
+		}
+		if err := s.set(cfg, value); err != nil {
+			return fmt.Errorf("invalid %s: %w", s.env, err)
+		}
+	}
+	return nil
+}
+
+func setString(field func(*Configuration) *string) func(*Configuration, string) error {
+	return func(cfg *Configuration, value string) error {
+		*field(cfg) = value
+		return nil
+	}
+}
+
+// setBool accepts what strconv.ParseBool does as well as yes, no, on and off,
+// which .env files written for earlier versions may use.
+func setBool(field func(*Configuration) *bool) func(*Configuration, string) error {
+	return func(cfg *Configuration, value string) error {
+		switch strings.ToLower(value) {
+		case "yes", "on":
+			*field(cfg) = true
+			return nil
+		case "no", "off":
+			*field(cfg) = false
+			return nil
+		}
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*field(cfg) = parsed
+		return nil
+	}
+}
+
+func setInt(field func(*Configuration) *int) func(*Configuration, string) error {
+	return func(cfg *Configuration, value string) error {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*field(cfg) = parsed
+		return nil
+	}
+}
+
+func setFloat(field func(*Configuration) *float64) func(*Configuration, string) error {
+	return func(cfg *Configuration, value string) error {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		*field(cfg) = parsed
+		return nil
+	}
+}
+
+func setDuration(field func(*Configuration) *time.Duration) func(*Configuration, string) error {
+	return func(cfg *Configuration, value string) error {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*field(cfg) = parsed
+		return nil
+	}
+}
+
+func setLimiterStrategy(cfg *Configuration, value string) error {
+	cfg.Limiter.Strategy = defaults.Strategy(value)
+	return nil
+}
+
+func setTemperature(cfg *Configuration, value string) error {
+	parsed, err := strconv.ParseFloat(value, 32)
+	if err != nil {
+		return err
+	}
+	temperature := float32(parsed)
+	cfg.Generation.Temperature = &temperature
+	return nil
+}
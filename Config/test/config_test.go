@@ -0,0 +1,287 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	config "BrainyBuddyGo/Config"
+	"BrainyBuddyGo/pkg/discordclient/limiter"
+)
+
+// clearEnv unsets the variables read by Load so the host environment does not
+// leak into the tests.
+func clearEnv(t *testing.T) {
+	for _, key := range []string{
+		"BRAINYBUDDY_CONFIG", "DISCORD_BOT_TOKEN", "OPENAI_API_KEY", "ANTHROPIC_API_KEY",
+		"LLM_PROVIDER", "LLM_BASE_URL", "LLM_API_KEY", "LLM_MODEL", "LLM_TEMPERATURE",
+		"LLM_MAX_TOKENS", "OPENAI_WORKERS", "CACHE_LIFETIME", "LIMITER_MAX_MESSAGES",
+		"LIMITER_WINDOW", "LIMITER_STRATEGY", "BUDGET_USER_DAILY_TOKENS", "GUILD_SETTINGS_FILE", "CONVERSATION_STORE_PATH",
+		"CONVERSATION_SCOPE", "LIMITER_REDIS_URL", "PRODUCTION",
+	} {
+		t.Setenv(key, "")
+	}
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadWithoutDotEnv(t *testing.T) {
+	clearEnv(t)
+	dir := t.TempDir()
+	t.Setenv("DISCORD_BOT_TOKEN", "discord")
+	t.Setenv("OPENAI_API_KEY", "openai")
+
+	cfg, err := config.Load(dir, nil)
+	if err != nil {
+		t.Fatalf("Load failed without .env: %v", err)
+	}
+
+	if cfg.Provider.APIKey != "openai" {
+		t.Errorf("expected provider key from OPENAI_API_KEY, got %q", cfg.Provider.APIKey)
+	}
+	if cfg.Limiter.MaxMessages != 5 || cfg.Limiter.Window != 3*time.Hour {
+		t.Errorf("unexpected limiter defaults: %+v", cfg.Limiter)
+	}
+	if cfg.Storage.Conversations != filepath.Join(dir, config.DefaultConversationStoreFile) {
+		t.Errorf("relative paths should resolve against basepath, got %q", cfg.Storage.Conversations)
+	}
+}
+
+func TestLoadLayerPrecedence(t *testing.T) {
+	clearEnv(t)
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "config.yaml"), `
+discord_token: from-file
+openai_token: openai
+generation:
+  model: file-model
+  max_tokens: 100
+openai:
+  workers: 2
+  cache_lifetime: 1h
+limiter:
+  max_messages: 10
+  window: 30m
+`)
+	writeFile(t, filepath.Join(dir, ".env"), "LLM_MAX_TOKENS=150\nLLM_MODEL=dotenv-model\n")
+	// Variables that are set, even empty ones, win over .env. The cleanup
+	// registered by clearEnv restores the variable afterwards.
+	os.Unsetenv("LLM_MAX_TOKENS")
+	t.Setenv("LLM_MODEL", "env-model")
+	t.Setenv("OPENAI_WORKERS", "3")
+
+	cfg, err := config.Load(dir, []string{"-workers", "4", "-limiter-window", "45m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.DiscordToken != "from-file" {
+		t.Errorf("expected the file value, got %q", cfg.DiscordToken)
+	}
+	if cfg.Generation.Model != "env-model" {
+		t.Errorf("environment should override the file, got %q", cfg.Generation.Model)
+	}
+	if cfg.Generation.MaxTokens != 150 {
+		t.Errorf(".env should override the file, got %d", cfg.Generation.MaxTokens)
+	}
+	if cfg.OpenAI.Workers != 4 {
+		t.Errorf("flags should override the environment, got %d", cfg.OpenAI.Workers)
+	}
+	if cfg.OpenAI.CacheLifeTime != time.Hour {
+		t.Errorf("expected the file cache lifetime, got %v", cfg.OpenAI.CacheLifeTime)
+	}
+	if cfg.Limiter.MaxMessages != 10 || cfg.Limiter.Window != 45*time.Minute {
+		t.Errorf("unexpected limiter settings: %+v", cfg.Limiter)
+	}
+}
+
+func TestLoadExplicitConfigFile(t *testing.T) {
+	clearEnv(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "custom.yaml")
+	writeFile(t, path, "discord_token: discord\nprovider:\n  kind: anthropic\nanthropic_token: claude\n")
+
+	cfg, err := config.Load(dir, []string{"-config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Provider.Kind != config.ProviderAnthropic || cfg.Provider.APIKey != "claude" {
+		t.Errorf("unexpected provider: %+v", cfg.Provider)
+	}
+
+	t.Setenv("BRAINYBUDDY_CONFIG", filepath.Join(dir, "missing.yaml"))
+	if _, err := config.Load(dir, nil); err == nil {
+		t.Error("expected an error for a missing explicit configuration file")
+	}
+}
+
+func TestLoadLegacyProductionValues(t *testing.T) {
+	tests := []struct {
+		value      string
+		production bool
+	}{
+		{"true", true},
+		{"yes", true},
+		{"ON", true},
+		{"1", true},
+		{"no", false},
+		{"off", false},
+		{"false", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			clearEnv(t)
+			t.Setenv("DISCORD_BOT_TOKEN", "discord")
+			t.Setenv("OPENAI_API_KEY", "openai")
+			t.Setenv("PRODUCTION", test.value)
+
+			cfg, err := config.Load(t.TempDir(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Production != test.production {
+				t.Errorf("expected production to be %v, got %v", test.production, cfg.Production)
+			}
+		})
+	}
+
+	clearEnv(t)
+	t.Setenv("DISCORD_BOT_TOKEN", "discord")
+	t.Setenv("OPENAI_API_KEY", "openai")
+	t.Setenv("PRODUCTION", "maybe")
+	if _, err := config.Load(t.TempDir(), nil); err == nil || !strings.Contains(err.Error(), "PRODUCTION") {
+		t.Errorf("expected an error about PRODUCTION, got %v", err)
+	}
+}
+
+func TestLoadQueuePriorities(t *testing.T) {
+	clearEnv(t)
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "config.yaml"), `discord_token: discord
+openai_token: key
+openai:
+  queue:
+    priorities:
+      "100": {"200": 10}
+`)
+
+	cfg, err := config.Load(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.OpenAI.Queue.Priorities["100"]["200"] != 10 || cfg.OpenAI.Queue.MaxPerUser != 1 {
+		t.Errorf("unexpected queue settings: %+v", cfg.OpenAI.Queue)
+	}
+}
+
+func TestLoadGuildLimits(t *testing.T) {
+	clearEnv(t)
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "config.yaml"), `discord_token: discord
+openai_token: key
+limiter:
+  strategy: token_bucket
+  guilds:
+    "100":
+      max_messages: 10
+      roles:
+        "200": {unlimited: true}
+        boosters: {max_messages: 20, strategy: daily_quota}
+`)
+
+	cfg, err := config.Load(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	guild := cfg.Limiter.Guilds["100"]
+	if cfg.Limiter.Strategy != limiter.TokenBucket || guild.MaxMessages != 10 {
+		t.Errorf("unexpected limits: %+v", cfg.Limiter)
+	}
+	if !guild.Roles["200"].Unlimited || guild.Roles["boosters"].Strategy != limiter.DailyQuota {
+		t.Errorf("unexpected role limits: %+v", guild.Roles)
+	}
+
+	writeFile(t, filepath.Join(dir, "config.yaml"), "discord_token: discord\nopenai_token: key\nlimiter:\n  guilds:\n    \"100\":\n      roles:\n        \"200\": {strategy: fixed}\n")
+	if _, err := config.Load(dir, nil); err == nil || !strings.Contains(err.Error(), "limiter.guilds.100.roles.200.strategy") {
+		t.Errorf("expected an error about the role strategy, got %v", err)
+	}
+}
+
+func TestLoadValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		want string
+	}{
+		{"missing discord token", map[string]string{"OPENAI_API_KEY": "key"}, nil, "DISCORD_BOT_TOKEN"},
+		{"missing openai key", map[string]string{"DISCORD_BOT_TOKEN": "discord"}, nil, "OPENAI_API_KEY"},
+		{"unknown provider", map[string]string{"DISCORD_BOT_TOKEN": "discord", "LLM_PROVIDER": "other"}, nil, "unknown provider"},
+		{"compatible without url", map[string]string{"DISCORD_BOT_TOKEN": "discord", "LLM_PROVIDER": "openai-compatible"}, nil, "LLM_BASE_URL"},
+		{"invalid temperature", map[string]string{"DISCORD_BOT_TOKEN": "discord", "OPENAI_API_KEY": "key"}, []string{"-temperature", "3"}, "temperature"},
+		{"temperature out of anthropic range", map[string]string{"DISCORD_BOT_TOKEN": "discord", "LLM_PROVIDER": "anthropic", "ANTHROPIC_API_KEY": "key"}, []string{"-temperature", "1.5"}, "anthropic"},
+		{"invalid window", map[string]string{"DISCORD_BOT_TOKEN": "discord", "OPENAI_API_KEY": "key", "LIMITER_WINDOW": "soon"}, nil, "LIMITER_WINDOW"},
+		{"unknown limiter strategy", map[string]string{"DISCORD_BOT_TOKEN": "discord", "OPENAI_API_KEY": "key", "LIMITER_STRATEGY": "leaky_bucket"}, nil, "limiter.strategy"},
+		{"negative budget", map[string]string{"DISCORD_BOT_TOKEN": "discord", "OPENAI_API_KEY": "key", "BUDGET_USER_DAILY_TOKENS": "-1"}, nil, "budget.user_daily"},
+		{"unknown conversation scope", map[string]string{"DISCORD_BOT_TOKEN": "discord", "OPENAI_API_KEY": "key", "CONVERSATION_SCOPE": "server"}, nil, "conversation scope"},
+		{"limiter redis without scheme", map[string]string{"DISCORD_BOT_TOKEN": "discord", "OPENAI_API_KEY": "key", "LIMITER_REDIS_URL": "localhost:6379"}, nil, "storage.limiter_redis"},
+		{"limiter redis with named database", map[string]string{"DISCORD_BOT_TOKEN": "discord", "OPENAI_API_KEY": "key", "LIMITER_REDIS_URL": "redis://localhost/limits"}, nil, "storage.limiter_redis"},
+		{"zero workers", map[string]string{"DISCORD_BOT_TOKEN": "discord", "OPENAI_API_KEY": "key"}, []string{"-workers", "0"}, "workers"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clearEnv(t)
+			for key, value := range test.env {
+				t.Setenv(key, value)
+			}
+
+			_, err := config.Load(t.TempDir(), test.args)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("expected an error mentioning %q, got %v", test.want, err)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := config.DefaultConfiguration()
+	old.DiscordToken = "secret"
+
+	next := config.DefaultConfiguration()
+	next.DiscordToken = "other secret"
+	next.Limiter.MaxMessages = 8
+	temperature := float32(0.5)
+	next.Generation.Temperature = &temperature
+
+	changes := config.Diff(old, next)
+	if len(changes) != 3 {
+		t.Fatalf("expected 3 changes, got %v", changes)
+	}
+
+	byField := make(map[string]config.Change)
+	for _, change := range changes {
+		byField[change.Field] = change
+	}
+
+	token := byField["discord_token"]
+	if strings.Contains(token.String(), "secret") || !token.RequiresRestart() {
+		t.Errorf("tokens must be masked and require a restart, got %v", token)
+	}
+	if limit := byField["limiter.max_messages"]; limit.Old != "5" || limit.New != "8" || limit.RequiresRestart() {
+		t.Errorf("unexpected limiter change %v", limit)
+	}
+	if temp := byField["generation.temperature"]; temp.Old != "unset" || temp.New != "0.5" {
+		t.Errorf("unexpected temperature change %v", temp)
+	}
+}
@@ -0,0 +1,165 @@
+package config_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	config "BrainyBuddyGo/Config"
+)
+
+func temperature(value float32) *float32 {
+	return &value
+}
+
+func sameTemperature(a *float32, b *float32) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func TestMerge(t *testing.T) {
+	base := config.GenerationSettings{Model: "gpt-4", Temperature: temperature(0.7), MaxTokens: 200, Profile: "normal", Conversation: config.ConversationPerUser}
+
+	tests := []struct {
+		name     string
+		base     config.GenerationSettings
+		override config.GenerationSettings
+		want     config.GenerationSettings
+	}{
+		{"empty override inherits everything", base, config.GenerationSettings{}, base},
+		{"empty base takes the override", config.GenerationSettings{}, base, base},
+		{
+			"set fields replace, empty fields inherit",
+			base,
+			config.GenerationSettings{Model: "gpt-3.5-turbo", MaxTokens: 50},
+			config.GenerationSettings{Model: "gpt-3.5-turbo", Temperature: temperature(0.7), MaxTokens: 50, Profile: "normal", Conversation: config.ConversationPerUser},
+		},
+		{
+			"a zero temperature is an override",
+			base,
+			config.GenerationSettings{Temperature: temperature(0)},
+			config.GenerationSettings{Model: "gpt-4", Temperature: temperature(0), MaxTokens: 200, Profile: "normal", Conversation: config.ConversationPerUser},
+		},
+		{
+			"profile and conversation override",
+			base,
+			config.GenerationSettings{Profile: "pirate", Conversation: config.ConversationPerChannel},
+			config.GenerationSettings{Model: "gpt-4", Temperature: temperature(0.7), MaxTokens: 200, Profile: "pirate", Conversation: config.ConversationPerChannel},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.base.Merge(test.override)
+			if got.Model != test.want.Model || got.MaxTokens != test.want.MaxTokens || got.Profile != test.want.Profile ||
+				got.Conversation != test.want.Conversation || !sameTemperature(got.Temperature, test.want.Temperature) {
+				t.Errorf("expected %+v, got %+v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestMergeCopiesTemperature(t *testing.T) {
+	override := config.GenerationSettings{Temperature: temperature(0.5)}
+	merged := config.GenerationSettings{}.Merge(override)
+
+	*merged.Temperature = 1
+	if *override.Temperature != 0.5 {
+		t.Errorf("expected the override to be left alone, got %v", *override.Temperature)
+	}
+}
+
+func TestGuildGenerationPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "guilds.json")
+	writeFile(t, path, `{
+  "100": {
+    "generation": {"model": "gpt-4", "temperature": 0.7, "max_tokens": 300},
+    "channel_generation": {
+      "300": {"max_tokens": 100, "profile": "support"},
+      "200": {"temperature": 0, "model": "gpt-3.5-turbo"},
+      "400": {"max_tokens": 50}
+    }
+  }
+}`)
+
+	guilds, err := config.LoadGuildSettings(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		scope config.ChannelScope
+		want  config.GenerationSettings
+	}{
+		{
+			"unknown guild has no overrides",
+			config.ChannelScope{GuildID: "999", ChannelID: channelID},
+			config.GenerationSettings{},
+		},
+		{
+			"channel without overrides inherits the guild",
+			config.ChannelScope{GuildID: guildID, ChannelID: otherID},
+			config.GenerationSettings{Model: "gpt-4", Temperature: temperature(0.7), MaxTokens: 300},
+		},
+		{
+			"channel overrides the guild",
+			config.ChannelScope{GuildID: guildID, ChannelID: channelID},
+			config.GenerationSettings{Model: "gpt-3.5-turbo", Temperature: temperature(0), MaxTokens: 300},
+		},
+		{
+			"category overrides the guild",
+			config.ChannelScope{GuildID: guildID, ChannelID: otherID, CategoryID: categoryID},
+			config.GenerationSettings{Model: "gpt-4", Temperature: temperature(0.7), MaxTokens: 100, Profile: "support"},
+		},
+		{
+			"channel overrides its category",
+			config.ChannelScope{GuildID: guildID, ChannelID: channelID, CategoryID: categoryID},
+			config.GenerationSettings{Model: "gpt-3.5-turbo", Temperature: temperature(0), MaxTokens: 100, Profile: "support"},
+		},
+		{
+			"thread inherits its parent and overrides it",
+			config.ChannelScope{GuildID: guildID, ChannelID: threadID, ParentID: channelID, CategoryID: categoryID, IsThread: true},
+			config.GenerationSettings{Model: "gpt-3.5-turbo", Temperature: temperature(0), MaxTokens: 50, Profile: "support"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := guilds.Generation(test.scope)
+			if got.Model != test.want.Model || got.MaxTokens != test.want.MaxTokens || got.Profile != test.want.Profile ||
+				!sameTemperature(got.Temperature, test.want.Temperature) {
+				t.Errorf("expected %+v, got %+v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestSharesConversations(t *testing.T) {
+	tests := []struct {
+		name   string
+		guilds string
+		want   bool
+	}{
+		{"no guild", `{}`, false},
+		{"per user everywhere", `{"100": {"generation": {"conversation": "user"}, "channel_generation": {"200": {"max_tokens": 50}}}}`, false},
+		{"shared guild", `{"100": {"generation": {"conversation": "thread"}}}`, true},
+		{"shared channel", `{"100": {"channel_generation": {"200": {"conversation": "channel"}}}}`, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "guilds.json")
+			writeFile(t, path, test.guilds)
+
+			guilds, err := config.LoadGuildSettings(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := guilds.SharesConversations(); got != test.want {
+				t.Errorf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
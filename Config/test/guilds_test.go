@@ -0,0 +1,172 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	config "BrainyBuddyGo/Config"
+)
+
+const (
+	guildID    = "100"
+	channelID  = "200"
+	otherID    = "201"
+	categoryID = "300"
+	threadID   = "400"
+)
+
+func loadGuilds(t *testing.T) *config.GuildSettings {
+	guilds, err := config.LoadGuildSettings(filepath.Join(t.TempDir(), "guilds.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return guilds
+}
+
+func TestIsAllowed(t *testing.T) {
+	guilds := loadGuilds(t)
+	if err := guilds.AllowChannel(guildID, channelID); err != nil {
+		t.Fatal(err)
+	}
+	if err := guilds.AllowCategory(guildID, categoryID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		scope   config.ChannelScope
+		threads bool
+		allowed bool
+	}{
+		{"allowed channel", config.ChannelScope{GuildID: guildID, ChannelID: channelID}, false, true},
+		{"other channel", config.ChannelScope{GuildID: guildID, ChannelID: otherID}, false, false},
+		{"unknown guild", config.ChannelScope{GuildID: "999", ChannelID: channelID}, false, false},
+		{"channel in allowed category", config.ChannelScope{GuildID: guildID, ChannelID: otherID, CategoryID: categoryID}, false, true},
+		{"thread of allowed channel", config.ChannelScope{GuildID: guildID, ChannelID: threadID, ParentID: channelID, IsThread: true}, true, true},
+		{"thread with threads disallowed", config.ChannelScope{GuildID: guildID, ChannelID: threadID, ParentID: channelID, IsThread: true}, false, false},
+		{"thread of other channel", config.ChannelScope{GuildID: guildID, ChannelID: threadID, ParentID: otherID, IsThread: true}, true, false},
+		{"thread in allowed category", config.ChannelScope{GuildID: guildID, ChannelID: threadID, ParentID: otherID, CategoryID: categoryID, IsThread: true}, true, true},
+		{"thread in allowed category with threads disallowed", config.ChannelScope{GuildID: guildID, ChannelID: threadID, ParentID: otherID, CategoryID: categoryID, IsThread: true}, false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := guilds.SetAllowThreads(guildID, test.threads); err != nil {
+				t.Fatal(err)
+			}
+			if got := guilds.IsAllowed(test.scope); got != test.allowed {
+				t.Errorf("expected allowed to be %v, got %v", test.allowed, got)
+			}
+		})
+	}
+}
+
+func TestDenyChannel(t *testing.T) {
+	guilds := loadGuilds(t)
+	scope := config.ChannelScope{GuildID: guildID, ChannelID: channelID}
+
+	if guilds.HasAllowedChannels(guildID) {
+		t.Error("expected no allowed channel in a new guild")
+	}
+	if err := guilds.AllowChannel(guildID, channelID); err != nil {
+		t.Fatal(err)
+	}
+	if !guilds.HasAllowedChannels(guildID) {
+		t.Error("expected the guild to have an allowed channel")
+	}
+	if err := guilds.DenyChannel(guildID, channelID); err != nil {
+		t.Fatal(err)
+	}
+	if guilds.IsAllowed(scope) || guilds.HasAllowedChannels(guildID) {
+		t.Error("expected the denied channel not to be allowed")
+	}
+}
+
+func TestGuildSettingsRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "guilds.json")
+	writeFile(t, path, `{"100": {"generation": {"max_tokens": 300}, "channel_generation": {"200": {"model": "gpt-4"}}}}`)
+
+	guilds, err := config.LoadGuildSettings(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := guilds.AllowChannel(guildID, channelID); err != nil {
+		t.Fatal(err)
+	}
+	if err := guilds.AllowCategory(guildID, categoryID); err != nil {
+		t.Fatal(err)
+	}
+	if err := guilds.SetAllowThreads(guildID, true); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := config.LoadGuildSettings(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	guild := reloaded.Guild(guildID)
+	if len(guild.AllowedChannels) != 1 || guild.AllowedChannels[0] != channelID {
+		t.Errorf("unexpected allowed channels: %v", guild.AllowedChannels)
+	}
+	if len(guild.AllowedCategories) != 1 || guild.AllowedCategories[0] != categoryID || !guild.AllowThreads {
+		t.Errorf("unexpected guild settings: %+v", guild)
+	}
+	if guild.Generation.MaxTokens != 300 || guild.ChannelGeneration[channelID].Model != "gpt-4" {
+		t.Errorf("expected the generation settings to be kept, got %+v", guild)
+	}
+
+	if err := reloaded.DenyCategory(guildID, categoryID); err != nil {
+		t.Fatal(err)
+	}
+	again, err := config.LoadGuildSettings(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if guild := again.Guild(guildID); len(guild.AllowedCategories) != 0 || len(guild.AllowedChannels) != 1 {
+		t.Errorf("unexpected guild settings after denying the category: %+v", guild)
+	}
+}
+
+func TestLoadInvalidGuildSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "guilds.json")
+	writeFile(t, path, `{"100": {"generation": {"temperature": 5}}}`)
+
+	if _, err := config.LoadGuildSettings(path); err == nil || !strings.Contains(err.Error(), "guild 100") {
+		t.Errorf("expected an error about guild 100, got %v", err)
+	}
+}
+
+func TestFailedSaveUndoesTheChange(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "missing")
+	guilds, err := config.LoadGuildSettings(filepath.Join(dir, "guilds.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := guilds.AllowChannel(guildID, channelID); err == nil {
+		t.Fatal("expected the settings not to be saved in a missing directory")
+	}
+	if guilds.HasAllowedChannels(guildID) {
+		t.Error("expected the new guild to be forgotten")
+	}
+
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := guilds.AllowChannel(guildID, channelID); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := guilds.DenyChannel(guildID, channelID); err == nil {
+		t.Fatal("expected the settings not to be saved in a missing directory")
+	}
+	if guild := guilds.Guild(guildID); len(guild.AllowedChannels) != 1 || guild.AllowedChannels[0] != channelID {
+		t.Errorf("expected the channel to stay allowed, got %v", guild.AllowedChannels)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -8,42 +9,108 @@ import (
 	"path/filepath"
 	"runtime"
 	"syscall"
+	"time"
 
 	config "BrainyBuddyGo/Config"
 	discordContext "BrainyBuddyGo/pkg/discordclient/context"
+	"BrainyBuddyGo/pkg/discordclient/handler"
 	"BrainyBuddyGo/pkg/discordclient/limiter"
+	"BrainyBuddyGo/pkg/openaiclient/breaker"
+	"BrainyBuddyGo/pkg/openaiclient/budget"
 	openAiContext "BrainyBuddyGo/pkg/openaiclient/context"
+	"BrainyBuddyGo/pkg/openaiclient/provider"
+	"BrainyBuddyGo/pkg/openaiclient/queue"
+	"BrainyBuddyGo/pkg/openaiclient/tokenizer"
 )
 
-const (
-	OpenAiThreadsNumber = 5
-)
+// abortGracePeriod is given to aborted questions to send their apology.
+const abortGracePeriod = 5 * time.Second
 
 type Bot struct {
+	cfg        *config.Configuration
 	discordCtx *discordContext.DiscordContext
 	openAiCtx  *openAiContext.OpenAiContext
 	Limiter    *limiter.MessageLimiter
+	// abort cancels the questions in flight.
+	abort context.CancelFunc
 }
 
-func NewBot(cfg *config.Configuration, basepath string) (*Bot, error) {
-	oa, err := openAiContext.NewOpenAiContext(cfg.OpenAiToken, OpenAiThreadsNumber, basepath, cfg.Production)
+// NewBot connects the bot to Discord. Questions are answered within ctx, so
+// cancelling it aborts those in flight.
+func NewBot(ctx context.Context, cfg *config.Configuration) (*Bot, error) {
+	store, err := openAiContext.NewBoltStore(cfg.Storage.Conversations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open conversation store: %w", err)
+	}
+
+	chatProvider, err := newChatProvider(cfg)
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to initialize LLM provider: %w", err)
+	}
+
+	budgetStore, err := budget.NewBoltStore(cfg.Storage.Budget)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	opts := []openAiContext.Option{
+		openAiContext.WithStore(store),
+		openAiContext.WithProvider(chatProvider),
+		openAiContext.WithBudgetStore(budgetStore),
+	}
+
+	if cfg.OpenAI.TokenizerFile != "" {
+		encoding, err := tokenizer.LoadEncoding(cfg.OpenAI.TokenizerFile)
+		if err != nil {
+			store.Close()
+			budgetStore.Close()
+			return nil, fmt.Errorf("failed to load tokenizer: %w", err)
+		}
+		opts = append(opts, openAiContext.WithTokenizer(encoding))
+	}
+
+	oa, err := openAiContext.NewOpenAiContextWithConfig(newOpenAiContextConfig(cfg), cfg.Production, opts...)
 	if err != nil {
+		store.Close()
+		budgetStore.Close()
 		return nil, fmt.Errorf("failed to initialize OpenAi context: %w", err)
 	}
 
-	lim := limiter.NewMessageLimiter()
+	limiterStore, err := newLimiterStore(cfg)
+	if err != nil {
+		oa.Close()
+		return nil, err
+	}
+	lim, err := limiter.NewPersistentMessageLimiter(newLimiterRules(cfg), limiterStore)
+	if err != nil {
+		limiterStore.Close()
+		oa.Close()
+		return nil, err
+	}
+	lim.StartPruning(cfg.Limiter.PruneInterval)
 
+	ctx, abort := context.WithCancel(ctx)
 	b := &Bot{
+		cfg:       cfg,
 		openAiCtx: oa,
 		Limiter:   lim,
+		abort:     abort,
 	}
 
-	dc, err := discordContext.Initialize(cfg.DiscordToken, oa, lim)
+	dc, err := discordContext.Initialize(ctx, cfg.DiscordToken, oa, oa, lim, cfg.Guilds, newHandlerSettings(cfg))
 	if err != nil {
+		abort()
+		oa.Close()
+		lim.Close()
 		return nil, fmt.Errorf("failed to initialize Discord context: %w", err)
 	}
 
 	if err := dc.OpenConnection(); err != nil {
+		abort()
+		oa.Close()
+		lim.Close()
 		return nil, fmt.Errorf("failed to open connection: %w", err)
 	}
 
@@ -51,15 +118,174 @@ func NewBot(cfg *config.Configuration, basepath string) (*Bot, error) {
 	return b, nil
 }
 
-func (b *Bot) Close() error {
-	err := b.discordCtx.CloseConnection()
+func newOpenAiContextConfig(cfg *config.Configuration) *openAiContext.OpenAiContextConfig {
+	oaConfig := openAiContext.DefaultConfig(cfg.Provider.APIKey, cfg.OpenAI.Workers)
+	oaConfig.MaxRetries = cfg.OpenAI.MaxRetries
+	oaConfig.CacheLifeTime = cfg.OpenAI.CacheLifeTime
+	oaConfig.ConversationCacheSize = cfg.OpenAI.ConversationCacheSize
+	oaConfig.PromptFile = cfg.OpenAI.PromptFile
+	oaConfig.PromptDir = cfg.OpenAI.PromptDir
+	oaConfig.Profile = cfg.Generation.Profile
+	if cfg.Generation.Model != "" {
+		oaConfig.Model = cfg.Generation.Model
+	}
+	if cfg.Generation.Temperature != nil {
+		oaConfig.DefaultTemperature = float64(*cfg.Generation.Temperature)
+	}
+	if cfg.Generation.MaxTokens != 0 {
+		oaConfig.DefaultMaxTokens = cfg.Generation.MaxTokens
+	}
+	oaConfig.Summary = openAiContext.SummaryConfig{
+		Threshold:    cfg.OpenAI.Summary.Threshold,
+		Model:        cfg.OpenAI.Summary.Model,
+		MaxTokens:    cfg.OpenAI.Summary.MaxTokens,
+		KeepMessages: cfg.OpenAI.Summary.KeepMessages,
+	}
+	oaConfig.Breaker = breaker.Config{
+		FailureThreshold: cfg.OpenAI.Breaker.FailureThreshold,
+		OpenTimeout:      cfg.OpenAI.Breaker.OpenTimeout,
+	}
+	oaConfig.MaxWaiting = cfg.OpenAI.Queue.MaxWaiting
+	oaConfig.MaxPerUser = cfg.OpenAI.Queue.MaxPerUser
+	oaConfig.Budget = newBudgetConfig(cfg)
+	return oaConfig
+}
+
+func newBudgetConfig(cfg *config.Configuration) budget.Config {
+	prices := budget.DefaultPrices()
+	for model, price := range cfg.Budget.Prices {
+		prices[model] = budget.Price{Prompt: price.Prompt, Completion: price.Completion}
+	}
+
+	model := cfg.Generation.Model
+	if model == "" {
+		model = openAiContext.DefaultModel
+	}
+	dollars := cfg.Budget.UserDaily.Dollars > 0 || cfg.Budget.GuildMonthly.Dollars > 0 || cfg.Budget.GlobalMonthly.Dollars > 0
+	if dollars && !prices.Has(model) {
+		log.Printf("Warning: %s has no price under budget.prices, it is charged the highest known price", model)
+	}
+
+	return budget.Config{
+		UserDaily:     budget.Limit(cfg.Budget.UserDaily),
+		GuildMonthly:  budget.Limit(cfg.Budget.GuildMonthly),
+		GlobalMonthly: budget.Limit(cfg.Budget.GlobalMonthly),
+		WarnAt:        cfg.Budget.WarnAt,
+		Prices:        prices,
+	}
+}
+
+// newLimiterStore keeps the rate limits in Redis when configured, in a file
+// otherwise.
+func newLimiterStore(cfg *config.Configuration) (limiter.Store, error) {
+	if cfg.Storage.LimiterRedis != "" {
+		return limiter.NewRedisStore(cfg.Storage.LimiterRedis)
+	}
+	return limiter.NewBoltStore(cfg.Storage.Limiter)
+}
+
+func newLimiterRules(cfg *config.Configuration) limiter.Rules {
+	rules := limiter.Rules{
+		Default: limiter.Limit{
+			Strategy:      cfg.Limiter.Strategy,
+			MaxMessages:   cfg.Limiter.MaxMessages,
+			Window:        cfg.Limiter.Window,
+			CountRejected: cfg.Limiter.CountRejected,
+		},
+		Guilds: make(map[string]limiter.GuildRules, len(cfg.Limiter.Guilds)),
+	}
+
+	for guildID, guild := range cfg.Limiter.Guilds {
+		guildRules := limiter.GuildRules{
+			Limit: newLimit(guild.LimitOverride),
+			Roles: make(map[string]limiter.Limit, len(guild.Roles)),
+		}
+		for roleID, role := range guild.Roles {
+			guildRules.Roles[roleID] = newLimit(role)
+		}
+		rules.Guilds[guildID] = guildRules
+	}
+
+	return rules
+}
+
+func newLimit(override config.LimitOverride) limiter.Limit {
+	return limiter.Limit{
+		Strategy:    override.Strategy,
+		MaxMessages: override.MaxMessages,
+		Window:      override.Window,
+		Unlimited:   override.Unlimited,
+	}
+}
+
+func newHandlerSettings(cfg *config.Configuration) handler.Settings {
+	return handler.Settings{
+		ModerationMaxRetries: cfg.Handler.ModerationMaxRetries,
+		StreamEditInterval:   cfg.Handler.StreamEditInterval,
+		RequestTimeout:       cfg.Handler.RequestTimeout,
+		ShutdownTimeout:      cfg.Handler.ShutdownTimeout,
+		Priorities:           queue.Priorities(cfg.OpenAI.Queue.Priorities),
+		Conversation:         cfg.Generation.Conversation,
+		SharedHistory:        cfg.Handler.SharedHistory,
+	}
+}
+
+func newChatProvider(cfg *config.Configuration) (provider.ChatProvider, error) {
+	chatProvider, err := provider.New(provider.Config{
+		Kind:    cfg.Provider.Kind,
+		BaseURL: cfg.Provider.BaseURL,
+		APIKey:  cfg.Provider.APIKey,
+	})
 	if err != nil {
-		return fmt.Errorf("failed to close Discord context connection: %w", err)
+		return nil, err
 	}
 
+	if cfg.Provider.Kind != provider.KindOpenAI {
+		if cfg.OpenAiToken != "" {
+			chatProvider = provider.WithModerator(chatProvider, provider.NewOpenAI(cfg.OpenAiToken))
+		} else {
+			log.Printf("Warning: the %s provider cannot moderate questions and OPENAI_API_KEY is not set, questions will be answered without moderation", cfg.Provider.Kind)
+		}
+	}
+
+	return chatProvider, nil
+}
+
+// Close shuts the bot down in order: new questions are refused and those in
+// flight get the shutdown timeout to be answered before they are aborted. Then
+// the cache eviction stops, the conversation and rate limit stores are closed,
+// and finally the Discord session is closed.
+func (b *Bot) Close() error {
+	h := b.discordCtx.Handler
+	timeout := h.Settings().ShutdownTimeout
+
+	log.Printf("Waiting up to %v for the questions in flight", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := h.Drain(ctx); err != nil {
+		log.Println("Questions still in flight after the shutdown timeout, aborting them")
+		b.abort()
+
+		grace, cancelGrace := context.WithTimeout(context.Background(), abortGracePeriod)
+		defer cancelGrace()
+		if err := h.Drain(grace); err != nil {
+			log.Printf("Giving up on the questions in flight: %v", err)
+		}
+	}
+	b.abort()
+
 	b.openAiCtx.Close()
 	log.Println("OpenAI context closed successfully")
 
+	if err := b.Limiter.Close(); err != nil {
+		log.Printf("Failed to close the rate limit store: %v", err)
+	}
+
+	if err := b.discordCtx.CloseConnection(); err != nil {
+		return fmt.Errorf("failed to close Discord context connection: %w", err)
+	}
+
 	return nil
 }
 
@@ -67,12 +293,12 @@ func main() {
 	_, filename, _, _ := runtime.Caller(0)
 	basepath := filepath.Dir(filepath.Dir(filepath.Dir(filename)))
 
-	cfg, err := config.Load(basepath)
+	cfg, err := config.Load(basepath, os.Args[1:])
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	b, err := NewBot(cfg, basepath)
+	b, err := NewBot(context.Background(), cfg)
 	if err != nil {
 		log.Fatalf("Failed to initialize bot: %v", err)
 	}
@@ -83,8 +309,11 @@ func main() {
 		}
 	}()
 
+	go b.watchReload(basepath, os.Args[1:])
+
 	// Wait for a termination signal while the bot is running
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 	<-stop
+	log.Println("Shutting down")
 }
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	config "BrainyBuddyGo/Config"
+)
+
+// watchReload loads the configuration and the prompt again every time the
+// process receives SIGHUP.
+func (b *Bot) watchReload(basepath string, args []string) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	for range hangup {
+		log.Println("Reloading configuration")
+
+		cfg, err := config.Load(basepath, args)
+		if err != nil {
+			log.Printf("Rejected new configuration, keeping the current one: %v", err)
+			continue
+		}
+
+		if err := b.Reload(cfg); err != nil {
+			log.Printf("Rejected new configuration, keeping the current one: %v", err)
+		}
+	}
+}
+
+// Reload swaps in a validated configuration. Nothing is changed when the new
+// prompt cannot be loaded.
+func (b *Bot) Reload(cfg *config.Configuration) error {
+	if err := b.openAiCtx.Reload(newOpenAiContextConfig(cfg), cfg.Production); err != nil {
+		return fmt.Errorf("failed to reload OpenAi context: %w", err)
+	}
+
+	b.Limiter.SetRules(newLimiterRules(cfg))
+	b.discordCtx.Handler.UpdateSettings(newHandlerSettings(cfg))
+	b.cfg.Guilds.Replace(cfg.Guilds)
+
+	changes := config.Diff(b.cfg, cfg)
+	for _, change := range changes {
+		if change.RequiresRestart() {
+			log.Printf("Configuration changed: %s (applied on restart)", change)
+		} else {
+			log.Printf("Configuration changed: %s", change)
+		}
+	}
+	log.Printf("Configuration reloaded with %d changes", len(changes))
+
+	cfg.Guilds = b.cfg.Guilds
+	b.cfg = cfg
+	return nil
+}
@@ -0,0 +1,57 @@
+// Package defaults holds the default settings of the packages the
+// configuration tunes, and the values a setting can take. Both the
+// configuration and those packages import it, so that loading the
+// configuration does not depend on them. It must not import any package of
+// the bot.
+package defaults
+
+import "time"
+
+// OpenAI requests and conversations.
+const (
+	Workers               = 5
+	MaxRetries            = 3
+	CacheLifeTime         = 24 * time.Hour
+	ConversationCacheSize = 2
+	PromptFile            = "pkg/openaiclient/context/config/prompt.json"
+	SummaryThreshold      = 1000
+	SummaryMaxTokens      = 150
+	SummaryKeepMessages   = 4
+
+	BreakerFailureThreshold = 5
+	BreakerOpenTimeout      = 30 * time.Second
+
+	QueueMaxWaiting = 50
+	QueueMaxPerUser = 1
+
+	// BudgetWarnAt is the fraction of a budget past which users are warned.
+	BudgetWarnAt = 0.8
+
+	// AnthropicMaxTemperature is the highest temperature the Messages API
+	// accepts, OpenAI goes up to 2.
+	AnthropicMaxTemperature = 1
+)
+
+// Strategy is the algorithm counting the questions of a user.
+type Strategy string
+
+const (
+	// SlidingWindow allows MaxMessages questions in any period of Window.
+	SlidingWindow Strategy = "sliding_window"
+	// TokenBucket allows bursts of MaxMessages questions, refilled evenly over
+	// Window.
+	TokenBucket Strategy = "token_bucket"
+	// DailyQuota allows MaxMessages questions per day, the quota resets at
+	// midnight UTC and Window is ignored.
+	DailyQuota Strategy = "daily_quota"
+)
+
+// Rate limits.
+const (
+	LimiterStrategy    = SlidingWindow
+	LimiterMaxMessages = 5
+	LimiterWindow      = 3 * time.Hour
+	// LimiterPruneInterval is how often the users back to a full quota are
+	// forgotten.
+	LimiterPruneInterval = 10 * time.Minute
+)
@@ -1,27 +1,30 @@
 package context
 
 import (
+	"context"
 	"errors"
 	"log"
 
+	config "BrainyBuddyGo/Config"
 	"BrainyBuddyGo/pkg/discordclient/handler"
-	aiContext "BrainyBuddyGo/pkg/openaiclient/context"
 
 	"github.com/bwmarrin/discordgo"
 )
 
 type DiscordContext struct {
-	Session   *discordgo.Session
-	Handler   *handler.Handler
-	AIContext *aiContext.OpenAiContext
+	Session *discordgo.Session
+	Handler *handler.Handler
 }
 
 func (dc *DiscordContext) RegisterHandlers() {
-	dc.Session.AddHandler(handler.Ready)
+	dc.Session.AddHandler(dc.Handler.Ready)
 	dc.Session.AddHandler(dc.Handler.MessageCreateHandler)
+	dc.Session.AddHandler(dc.Handler.InteractionCreateHandler)
 }
 
-func Initialize(discordToken string, aiContext *aiContext.OpenAiContext, limiter handler.MessageLimiter) (*DiscordContext, error) {
+// Initialize creates a session answering questions within ctx, see
+// handler.NewHandler.
+func Initialize(ctx context.Context, discordToken string, responder handler.Responder, moderator handler.Moderator, limiter handler.MessageLimiter, guilds *config.GuildSettings, settings handler.Settings) (*DiscordContext, error) {
 	if discordToken == "" {
 		return nil, errors.New("discord token is empty")
 	}
@@ -32,13 +35,15 @@ func Initialize(discordToken string, aiContext *aiContext.OpenAiContext, limiter
 		log.Println(err)
 		return nil, err
 	}
+	// Message Content is privileged: without it the messages of a shared
+	// conversation arrive empty, see the README.
+	dg.Identify.Intents = discordgo.IntentsAllWithoutPrivileged | discordgo.IntentMessageContent
 
-	handler := handler.NewHandler(aiContext, limiter)
+	handler := handler.NewHandler(ctx, responder, moderator, limiter, guilds, settings)
 
 	dc := &DiscordContext{
-		Session:   dg,
-		Handler:   handler,
-		AIContext: aiContext,
+		Session: dg,
+		Handler: handler,
 	}
 
 	dc.RegisterHandlers()
@@ -71,6 +76,10 @@ func (dc *DiscordContext) CloseConnection() error {
 		return err
 	}
 
+	if err := dc.Handler.UnregisterCommands(dc.Session); err != nil {
+		log.Printf("Unable to clean up application commands: %v", err)
+	}
+
 	if err := dc.Session.Close(); err != nil {
 		log.Printf("Unable to close connection: %v", err)
 		return err
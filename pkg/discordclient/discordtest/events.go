@@ -0,0 +1,88 @@
+package discordtest
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// User creates a user that is not a bot.
+func User(id string, username string) *discordgo.User {
+	return &discordgo.User{ID: id, Username: username}
+}
+
+// NewMessage creates a message posted by author in a guild channel. Messages
+// starting with the Mention of the bot mention it.
+func (h *Harness) NewMessage(guildID string, channelID string, author *discordgo.User, content string) *discordgo.Message {
+	h.mutex.Lock()
+	id := h.newID()
+	h.mutex.Unlock()
+
+	message := &discordgo.Message{
+		ID:        id,
+		GuildID:   guildID,
+		ChannelID: channelID,
+		Author:    author,
+		Content:   content,
+	}
+	if strings.Contains(content, h.Mention()) {
+		message.Mentions = []*discordgo.User{h.Bot}
+	}
+	return message
+}
+
+// MessageCreate dispatches a MESSAGE_CREATE event to the connected session.
+func (h *Harness) MessageCreate(message *discordgo.Message) error {
+	return h.gateway.dispatch("MESSAGE_CREATE", message)
+}
+
+// NewCommand creates the interaction of a slash command with string options.
+func (h *Harness) NewCommand(guildID string, channelID string, user *discordgo.User, name string, options map[string]string) *discordgo.Interaction {
+	h.mutex.Lock()
+	id := h.newID()
+	token := "interaction-token-" + id
+	h.tokens[token] = id
+	h.mutex.Unlock()
+
+	data := discordgo.ApplicationCommandInteractionData{
+		ID:   id,
+		Name: name,
+	}
+
+	names := make([]string, 0, len(options))
+	for optionName := range options {
+		names = append(names, optionName)
+	}
+	sort.Strings(names)
+
+	for _, optionName := range names {
+		data.Options = append(data.Options, &discordgo.ApplicationCommandInteractionDataOption{
+			Name:  optionName,
+			Type:  discordgo.ApplicationCommandOptionString,
+			Value: options[optionName],
+		})
+	}
+
+	interaction := &discordgo.Interaction{
+		ID:        id,
+		AppID:     ApplicationID,
+		Type:      discordgo.InteractionApplicationCommand,
+		Data:      data,
+		GuildID:   guildID,
+		ChannelID: channelID,
+		Token:     token,
+		Version:   1,
+	}
+	if guildID != "" {
+		interaction.Member = &discordgo.Member{User: user, GuildID: guildID}
+	} else {
+		interaction.User = user
+	}
+	return interaction
+}
+
+// InteractionCreate dispatches an INTERACTION_CREATE event to the connected session.
+func (h *Harness) InteractionCreate(interaction *discordgo.Interaction) error {
+	return h.gateway.dispatch("INTERACTION_CREATE", interaction)
+}
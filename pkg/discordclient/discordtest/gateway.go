@@ -0,0 +1,154 @@
+package discordtest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	opDispatch     = 0
+	opHeartbeat    = 1
+	opIdentify     = 2
+	opHello        = 10
+	opHeartbeatAck = 11
+
+	// heartbeatInterval is long enough for discordgo not to expect acks
+	// during a test.
+	heartbeatInterval = 45000
+)
+
+var ErrNotConnected = errors.New("no session is connected to the gateway")
+
+type payload struct {
+	Op       int             `json:"op"`
+	Data     json.RawMessage `json:"d,omitempty"`
+	Sequence int64           `json:"s,omitempty"`
+	Type     string          `json:"t,omitempty"`
+}
+
+// gateway speaks enough of the Discord gateway protocol for a session to
+// identify, receive READY and then the events dispatched by the test.
+type gateway struct {
+	harness  *Harness
+	upgrader websocket.Upgrader
+
+	conn     *websocket.Conn
+	sequence int64
+	intents  discordgo.Intent
+	mutex    sync.Mutex
+}
+
+func newGateway(h *Harness) *gateway {
+	return &gateway{harness: h}
+}
+
+func (g *gateway) url() string {
+	return "ws" + strings.TrimPrefix(g.harness.Server.URL, "http") + "/gateway/"
+}
+
+func (g *gateway) serve(w http.ResponseWriter, r *http.Request) {
+	conn, err := g.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	if err := g.write(conn, payload{Op: opHello, Data: mustMarshal(map[string]int{"heartbeat_interval": heartbeatInterval})}); err != nil {
+		return
+	}
+
+	var identify payload
+	if err := conn.ReadJSON(&identify); err != nil || identify.Op != opIdentify {
+		return
+	}
+	var properties struct {
+		Intents discordgo.Intent `json:"intents"`
+	}
+	if err := json.Unmarshal(identify.Data, &properties); err != nil {
+		return
+	}
+
+	g.mutex.Lock()
+	g.conn = conn
+	g.sequence = 0
+	g.intents = properties.Intents
+	g.mutex.Unlock()
+
+	defer func() {
+		g.mutex.Lock()
+		if g.conn == conn {
+			g.conn = nil
+		}
+		g.mutex.Unlock()
+	}()
+
+	err = g.dispatch("READY", &discordgo.Ready{
+		Version:     9,
+		SessionID:   "test-session",
+		User:        g.harness.Bot,
+		Application: &discordgo.Application{ID: ApplicationID},
+		Guilds:      []*discordgo.Guild{},
+	})
+	if err != nil {
+		return
+	}
+
+	for {
+		var message payload
+		if err := conn.ReadJSON(&message); err != nil {
+			return
+		}
+		if message.Op == opHeartbeat {
+			g.write(conn, payload{Op: opHeartbeatAck})
+		}
+	}
+}
+
+// dispatch sends an event to the connected session.
+func (g *gateway) dispatch(eventType string, data interface{}) error {
+	g.mutex.Lock()
+	conn := g.conn
+	g.sequence++
+	sequence := g.sequence
+	g.mutex.Unlock()
+
+	if conn == nil {
+		return ErrNotConnected
+	}
+
+	return g.write(conn, payload{
+		Op:       opDispatch,
+		Type:     eventType,
+		Sequence: sequence,
+		Data:     mustMarshal(data),
+	})
+}
+
+func (g *gateway) write(conn *websocket.Conn, message payload) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return conn.WriteJSON(message)
+}
+
+func (g *gateway) close() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.conn != nil {
+		g.conn.Close()
+		g.conn = nil
+	}
+}
+
+func mustMarshal(value interface{}) json.RawMessage {
+	data, err := json.Marshal(value)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
@@ -0,0 +1,322 @@
+// Package discordtest fakes the Discord REST API and gateway so handlers can
+// be tested end to end with a real discordgo.Session and without a bot token.
+//
+// The harness points the discordgo.Endpoint* variables to a local server, so
+// tests using it must not run in parallel.
+package discordtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	Token         = "test-token"
+	BotID         = "100000000000000001"
+	ApplicationID = BotID
+	BotUsername   = "BrainyBuddy"
+
+	// WaitTimeout bounds the helpers waiting for the bot to act.
+	WaitTimeout = 5 * time.Second
+)
+
+// Message is a message sent by the bot. Contents lists the initial content
+// followed by every edit.
+type Message struct {
+	ID        string
+	ChannelID string
+	Contents  []string
+	Reference *discordgo.MessageReference
+	Reactions []string
+}
+
+// Content is the current content of the message.
+func (m Message) Content() string {
+	if len(m.Contents) == 0 {
+		return ""
+	}
+	return m.Contents[len(m.Contents)-1]
+}
+
+// InteractionResponse is the answer of the bot to an interaction. Contents
+// lists the content of the initial response followed by every edit.
+type InteractionResponse struct {
+	InteractionID string
+	Type          discordgo.InteractionResponseType
+	Flags         discordgo.MessageFlags
+	Contents      []string
+}
+
+// Content is the current content of the response.
+func (r InteractionResponse) Content() string {
+	if len(r.Contents) == 0 {
+		return ""
+	}
+	return r.Contents[len(r.Contents)-1]
+}
+
+// Request is a REST call received by the fake API, with its path relative to
+// the API root.
+type Request struct {
+	Method string
+	Path   string
+	Body   []byte
+}
+
+// Harness is a fake Discord: create one with New, open a session with the
+// package Token and dispatch events with MessageCreate or InteractionCreate.
+type Harness struct {
+	Server *httptest.Server
+	Bot    *discordgo.User
+
+	gateway *gateway
+
+	channels     map[string]*discordgo.Channel
+	messages     []*Message
+	interactions map[string]*InteractionResponse
+	tokens       map[string]string
+	commands     []*discordgo.ApplicationCommand
+	requests     []Request
+	editDelay    time.Duration
+	nextID       int64
+	mutex        sync.Mutex
+}
+
+// New starts a fake Discord and points discordgo to it until the test ends.
+func New(t testing.TB) *Harness {
+	h := &Harness{
+		Bot: &discordgo.User{
+			ID:       BotID,
+			Username: BotUsername,
+			Bot:      true,
+		},
+		channels:     make(map[string]*discordgo.Channel),
+		interactions: make(map[string]*InteractionResponse),
+		tokens:       make(map[string]string),
+		nextID:       200000000000000000,
+	}
+	h.gateway = newGateway(h)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v"+discordgo.APIVersion+"/", h.handleREST)
+	mux.HandleFunc("/gateway/", h.gateway.serve)
+	h.Server = httptest.NewServer(mux)
+
+	restore := overrideEndpoints(h.Server.URL + "/api/v" + discordgo.APIVersion + "/")
+	t.Cleanup(func() {
+		h.gateway.close()
+		h.Server.Close()
+		restore()
+	})
+
+	return h
+}
+
+// overrideEndpoints points the discordgo endpoints to api and returns a
+// function restoring the original values. The endpoint functions derive their
+// URLs from these variables when called.
+func overrideEndpoints(api string) func() {
+	endpoints := []*string{
+		&discordgo.EndpointAPI,
+		&discordgo.EndpointGuilds,
+		&discordgo.EndpointChannels,
+		&discordgo.EndpointUsers,
+		&discordgo.EndpointGateway,
+		&discordgo.EndpointGatewayBot,
+		&discordgo.EndpointWebhooks,
+		&discordgo.EndpointApplications,
+		&discordgo.EndpointGuildCreate,
+	}
+	values := []string{
+		api,
+		api + "guilds/",
+		api + "channels/",
+		api + "users/",
+		api + "gateway",
+		api + "gateway/bot",
+		api + "webhooks/",
+		api + "applications",
+		api + "guilds",
+	}
+
+	original := make([]string, len(endpoints))
+	for i, endpoint := range endpoints {
+		original[i] = *endpoint
+		*endpoint = values[i]
+	}
+
+	return func() {
+		for i, endpoint := range endpoints {
+			*endpoint = original[i]
+		}
+	}
+}
+
+// AddChannel makes a channel known to the REST API.
+func (h *Harness) AddChannel(channel *discordgo.Channel) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.channels[channel.ID] = channel
+}
+
+// Mention is the text mentioning the bot in a message.
+func (h *Harness) Mention() string {
+	return "<@" + h.Bot.ID + ">"
+}
+
+// SetEditDelay makes every message edit take delay, like a slow or rate
+// limited Discord.
+func (h *Harness) SetEditDelay(delay time.Duration) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.editDelay = delay
+}
+
+// Messages returns the messages sent by the bot in a channel.
+func (h *Harness) Messages(channelID string) []Message {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	var messages []Message
+	for _, message := range h.messages {
+		if message.ChannelID == channelID {
+			messages = append(messages, copyMessage(message))
+		}
+	}
+	return messages
+}
+
+// InteractionResponse returns the response to an interaction, if any.
+func (h *Harness) InteractionResponse(interactionID string) (InteractionResponse, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	response, ok := h.interactions[interactionID]
+	if !ok {
+		return InteractionResponse{}, false
+	}
+	copied := *response
+	copied.Contents = append([]string(nil), response.Contents...)
+	return copied, true
+}
+
+// Commands returns the application commands currently registered.
+func (h *Harness) Commands() []*discordgo.ApplicationCommand {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]*discordgo.ApplicationCommand(nil), h.commands...)
+}
+
+// Intents returns the gateway intents the last session identified with.
+func (h *Harness) Intents() discordgo.Intent {
+	h.gateway.mutex.Lock()
+	defer h.gateway.mutex.Unlock()
+	return h.gateway.intents
+}
+
+// Requests returns every REST call received so far.
+func (h *Harness) Requests() []Request {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]Request(nil), h.requests...)
+}
+
+// WaitFor polls condition until it holds and fails the test after WaitTimeout.
+func (h *Harness) WaitFor(t testing.TB, description string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(WaitTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", description)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// WaitForMessage waits until the bot sent a message in the channel matching
+// the predicate and returns it.
+func (h *Harness) WaitForMessage(t testing.TB, channelID string, predicate func(Message) bool) Message {
+	t.Helper()
+
+	var found Message
+	h.WaitFor(t, "a message in channel "+channelID, func() bool {
+		for _, message := range h.Messages(channelID) {
+			if predicate(message) {
+				found = message
+				return true
+			}
+		}
+		return false
+	})
+	return found
+}
+
+// WaitForInteractionResponse waits until the response to an interaction
+// matches the predicate and returns it.
+func (h *Harness) WaitForInteractionResponse(t testing.TB, interactionID string, predicate func(InteractionResponse) bool) InteractionResponse {
+	t.Helper()
+
+	var found InteractionResponse
+	h.WaitFor(t, "a response to interaction "+interactionID, func() bool {
+		response, ok := h.InteractionResponse(interactionID)
+		if ok && predicate(response) {
+			found = response
+			return true
+		}
+		return false
+	})
+	return found
+}
+
+func (h *Harness) newID() string {
+	h.nextID++
+	return strconv.FormatInt(h.nextID, 10)
+}
+
+func copyMessage(message *Message) Message {
+	copied := *message
+	copied.Contents = append([]string(nil), message.Contents...)
+	copied.Reactions = append([]string(nil), message.Reactions...)
+	return copied
+}
+
+func (h *Harness) findMessage(channelID string, messageID string) *Message {
+	for _, message := range h.messages {
+		if message.ID == messageID && message.ChannelID == channelID {
+			return message
+		}
+	}
+	return nil
+}
+
+func (h *Harness) apiMessage(message *Message) *discordgo.Message {
+	return &discordgo.Message{
+		ID:               message.ID,
+		ChannelID:        message.ChannelID,
+		Content:          message.Content(),
+		Author:           h.Bot,
+		MessageReference: message.Reference,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeNotFound(w http.ResponseWriter, what string) {
+	writeJSON(w, http.StatusNotFound, map[string]interface{}{
+		"message": fmt.Sprintf("Unknown %s", what),
+		"code":    10000,
+	})
+}
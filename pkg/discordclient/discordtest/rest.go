@@ -0,0 +1,208 @@
+package discordtest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// handleREST serves the routes of the Discord API used by the bot. Unknown
+// routes answer 404 but are still recorded.
+func (h *Harness) handleREST(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	path := strings.TrimPrefix(r.URL.Path, "/api/v"+discordgo.APIVersion+"/")
+
+	h.mutex.Lock()
+	h.requests = append(h.requests, Request{Method: r.Method, Path: path, Body: body})
+	editDelay := h.editDelay
+	h.mutex.Unlock()
+
+	if r.Method == http.MethodPatch {
+		time.Sleep(editDelay)
+	}
+
+	parts := strings.Split(path, "/")
+	switch {
+	case r.Method == http.MethodGet && path == "gateway":
+		writeJSON(w, http.StatusOK, map[string]string{"url": h.gateway.url()})
+	case parts[0] == "channels":
+		h.handleChannels(w, r, parts[1:], body)
+	case parts[0] == "applications" && len(parts) >= 3 && parts[2] == "commands":
+		h.handleCommands(w, r, parts[3:], body)
+	case parts[0] == "interactions" && len(parts) == 4 && parts[3] == "callback":
+		h.handleInteractionCallback(w, parts[1], parts[2], body)
+	case parts[0] == "webhooks" && len(parts) == 5 && parts[3] == "messages":
+		h.handleWebhookMessage(w, r, parts[2], parts[4], body)
+	default:
+		writeNotFound(w, "route")
+	}
+}
+
+func (h *Harness) handleChannels(w http.ResponseWriter, r *http.Request, parts []string, body []byte) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	channelID := parts[0]
+
+	switch {
+	// GET channels/{channel}
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		channel, ok := h.channels[channelID]
+		if !ok {
+			writeNotFound(w, "Channel")
+			return
+		}
+		writeJSON(w, http.StatusOK, channel)
+
+	// POST channels/{channel}/messages
+	case len(parts) == 2 && parts[1] == "messages" && r.Method == http.MethodPost:
+		var send discordgo.MessageSend
+		if err := json.Unmarshal(body, &send); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+			return
+		}
+		message := &Message{
+			ID:        h.newID(),
+			ChannelID: channelID,
+			Contents:  []string{send.Content},
+			Reference: send.Reference,
+		}
+		h.messages = append(h.messages, message)
+		writeJSON(w, http.StatusOK, h.apiMessage(message))
+
+	// PATCH channels/{channel}/messages/{message}
+	case len(parts) == 3 && parts[1] == "messages" && r.Method == http.MethodPatch:
+		message := h.findMessage(channelID, parts[2])
+		if message == nil {
+			writeNotFound(w, "Message")
+			return
+		}
+		var edit struct {
+			Content *string `json:"content"`
+		}
+		if err := json.Unmarshal(body, &edit); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+			return
+		}
+		if edit.Content != nil {
+			message.Contents = append(message.Contents, *edit.Content)
+		}
+		writeJSON(w, http.StatusOK, h.apiMessage(message))
+
+	// PUT channels/{channel}/messages/{message}/reactions/{emoji}/@me
+	case len(parts) == 6 && parts[3] == "reactions" && r.Method == http.MethodPut:
+		message := h.findMessage(channelID, parts[2])
+		if message == nil {
+			writeNotFound(w, "Message")
+			return
+		}
+		message.Reactions = append(message.Reactions, parts[4])
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeNotFound(w, "route")
+	}
+}
+
+func (h *Harness) handleCommands(w http.ResponseWriter, r *http.Request, parts []string, body []byte) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	switch {
+	case len(parts) == 0 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, h.commands)
+
+	case len(parts) == 0 && r.Method == http.MethodPut:
+		var commands []*discordgo.ApplicationCommand
+		if err := json.Unmarshal(body, &commands); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+			return
+		}
+		for _, command := range commands {
+			command.ID = h.newID()
+			command.ApplicationID = ApplicationID
+		}
+		h.commands = commands
+		writeJSON(w, http.StatusOK, commands)
+
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		for i, command := range h.commands {
+			if command.ID == parts[0] {
+				h.commands = append(h.commands[:i], h.commands[i+1:]...)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		writeNotFound(w, "application command")
+
+	default:
+		writeNotFound(w, "route")
+	}
+}
+
+func (h *Harness) handleInteractionCallback(w http.ResponseWriter, interactionID string, token string, body []byte) {
+	var response discordgo.InteractionResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.tokens[token] != interactionID {
+		writeNotFound(w, "interaction")
+		return
+	}
+	if _, ok := h.interactions[interactionID]; ok {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"message": "Interaction has already been acknowledged.",
+			"code":    40060,
+		})
+		return
+	}
+
+	recorded := &InteractionResponse{
+		InteractionID: interactionID,
+		Type:          response.Type,
+	}
+	if response.Data != nil {
+		recorded.Flags = response.Data.Flags
+		recorded.Contents = []string{response.Data.Content}
+	}
+	h.interactions[interactionID] = recorded
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleWebhookMessage edits the original response of an interaction, the
+// only webhook message the bot uses.
+func (h *Harness) handleWebhookMessage(w http.ResponseWriter, r *http.Request, token string, messageID string, body []byte) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	response, ok := h.interactions[h.tokens[token]]
+	if !ok || messageID != "@original" || r.Method != http.MethodPatch {
+		writeNotFound(w, "Webhook")
+		return
+	}
+
+	var edit discordgo.WebhookEdit
+	if err := json.Unmarshal(body, &edit); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+	if edit.Content != nil {
+		response.Contents = append(response.Contents, *edit.Content)
+	}
+
+	writeJSON(w, http.StatusOK, &discordgo.Message{
+		ID:      response.InteractionID,
+		Content: response.Content(),
+		Author:  h.Bot,
+	})
+}
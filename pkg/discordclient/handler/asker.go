@@ -0,0 +1,79 @@
+package handler
+
+import (
+	aiContext "BrainyBuddyGo/pkg/openaiclient/context"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// BoosterRole stands for the members boosting a guild in the role limits and
+// queue priorities, next to the IDs of the roles of the guild.
+const BoosterRole = "boosters"
+
+// asker is the author of a question. Limits, budgets and conversations are
+// keyed by the user ID, the display name is only shown to the model.
+type asker struct {
+	userID      string
+	username    string
+	displayName string
+	guildID     string
+	// channelID is the channel or thread the question is asked in.
+	channelID string
+	// roles holds the IDs of the roles of the author in the guild, and
+	// BoosterRole when they boost it.
+	roles []string
+}
+
+func messageAsker(m *discordgo.MessageCreate) asker {
+	return asker{
+		userID:      m.Author.ID,
+		username:    m.Author.Username,
+		displayName: displayName(m.Author, m.Member),
+		guildID:     m.GuildID,
+		channelID:   m.ChannelID,
+		roles:       memberRoles(m.Member),
+	}
+}
+
+func interactionAsker(i *discordgo.InteractionCreate) asker {
+	user := interactionUser(i)
+	return asker{
+		userID:      user.ID,
+		username:    user.Username,
+		displayName: displayName(user, i.Member),
+		guildID:     i.GuildID,
+		channelID:   i.ChannelID,
+		roles:       memberRoles(i.Member),
+	}
+}
+
+// aiAuthor is the author continuing the conversation of key.
+func (a asker) aiAuthor(key aiContext.ConversationKey) aiContext.Author {
+	return aiContext.Author{
+		ID:           a.userID,
+		DisplayName:  a.displayName,
+		Username:     a.username,
+		Conversation: key,
+	}
+}
+
+// displayName is the nickname of a guild member, or their username.
+func displayName(user *discordgo.User, member *discordgo.Member) string {
+	if member != nil && member.Nick != "" {
+		return member.Nick
+	}
+	return user.Username
+}
+
+// memberRoles returns the roles of a guild member, nil outside of guilds.
+func memberRoles(member *discordgo.Member) []string {
+	if member == nil {
+		return nil
+	}
+
+	roles := append([]string(nil), member.Roles...)
+	if member.PremiumSince != nil {
+		roles = append(roles, BoosterRole)
+	}
+	return roles
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"BrainyBuddyGo/pkg/openaiclient/budget"
+)
+
+// BudgetReporter is implemented by responders keeping track of what is spent
+// on the API, which adds the budgets to /usage.
+type BudgetReporter interface {
+	BudgetUsage(account budget.Account) []budget.Status
+}
+
+// BudgetChecker is implemented by responders keeping budgets. Questions are
+// refused once a budget of their author is used up, before they count against
+// the rate limit.
+type BudgetChecker interface {
+	CheckBudget(account budget.Account) error
+}
+
+// budgetNotes collects the budget warnings given while a question is answered.
+type budgetNotes struct {
+	warnings []budget.Warning
+	mutex    sync.Mutex
+}
+
+// withBudgetNotes collects the budget warnings of the requests of ctx.
+func withBudgetNotes(ctx context.Context) (context.Context, *budgetNotes) {
+	notes := &budgetNotes{}
+	account := budget.AccountFrom(ctx)
+	account.OnWarning = notes.add
+	return budget.WithAccount(ctx, account), notes
+}
+
+func (n *budgetNotes) add(warning budget.Warning) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.warnings = append(n.warnings, warning)
+}
+
+// appendTo adds the warnings to an answer.
+func (n *budgetNotes) appendTo(response string) string {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	var sb strings.Builder
+	sb.WriteString(response)
+	for _, warning := range n.warnings {
+		fmt.Fprintf(&sb, "\n\n_%s_", formatBudgetWarning(warning, time.Now()))
+	}
+	return sb.String()
+}
+
+func formatBudgetWarning(warning budget.Warning, now time.Time) string {
+	if warning.Threshold >= 1 {
+		return formatBudgetExhausted(warning.Status, now)
+	}
+
+	percent := warning.Threshold * 100
+	switch warning.Scope {
+	case budget.User:
+		return fmt.Sprintf("Heads up: you've used %.0f%% of your daily budget.", percent)
+	case budget.Guild:
+		return fmt.Sprintf("Heads up: this server has used %.0f%% of its monthly budget.", percent)
+	default:
+		return fmt.Sprintf("Heads up: I've used %.0f%% of my monthly budget.", percent)
+	}
+}
+
+func formatBudgetExhausted(status budget.Status, now time.Time) string {
+	resetIn := formatResetIn(status.ResetAt.Sub(now))
+	switch status.Scope {
+	case budget.User:
+		return fmt.Sprintf("You've used up your daily budget, it resets in %s.", resetIn)
+	case budget.Guild:
+		return fmt.Sprintf("This server has used up its monthly budget, it resets in %s.", resetIn)
+	default:
+		return fmt.Sprintf("I've used up my monthly budget, it resets in %s.", resetIn)
+	}
+}
+
+func formatBudgetStatus(status budget.Status) string {
+	var spent []string
+	if status.Limit.Tokens > 0 {
+		spent = append(spent, fmt.Sprintf("%d of %d tokens", status.Spent.Tokens, status.Limit.Tokens))
+	}
+	if status.Limit.Dollars > 0 {
+		spent = append(spent, fmt.Sprintf("$%.2f of $%.2f", status.Spent.Dollars, status.Limit.Dollars))
+	}
+
+	switch status.Scope {
+	case budget.User:
+		return "Your daily budget: " + strings.Join(spent, ", ")
+	case budget.Guild:
+		return "This server's monthly budget: " + strings.Join(spent, ", ")
+	default:
+		return "My monthly budget: " + strings.Join(spent, ", ")
+	}
+}
+
+func formatResetIn(d time.Duration) string {
+	switch {
+	case d >= 48*time.Hour:
+		return fmt.Sprintf("%.0f days", d.Hours()/24)
+	case d >= 2*time.Hour:
+		return fmt.Sprintf("%.0f hours", d.Hours())
+	default:
+		return fmt.Sprintf("%.0f minutes", d.Minutes())
+	}
+}
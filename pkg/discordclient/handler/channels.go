@@ -0,0 +1,210 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	config "BrainyBuddyGo/Config"
+	aiContext "BrainyBuddyGo/pkg/openaiclient/context"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	ChannelsCommand = "channels"
+
+	ChannelNotAllowedMsg = "I'm not allowed to answer in this channel."
+)
+
+var manageGuildPermission int64 = discordgo.PermissionManageServer
+
+var channelsCommandDefinition = &discordgo.ApplicationCommand{
+	Name:                     ChannelsCommand,
+	Description:              "Configure where BrainyBuddy answers",
+	DefaultMemberPermissions: &manageGuildPermission,
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "allow",
+			Description: "Allow BrainyBuddy in a channel or a whole category",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:         discordgo.ApplicationCommandOptionChannel,
+					Name:         "channel",
+					Description:  "Channel or category",
+					Required:     true,
+					ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText, discordgo.ChannelTypeGuildCategory},
+				},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "deny",
+			Description: "Stop BrainyBuddy from answering in a channel or category",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:         discordgo.ApplicationCommandOptionChannel,
+					Name:         "channel",
+					Description:  "Channel or category",
+					Required:     true,
+					ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText, discordgo.ChannelTypeGuildCategory},
+				},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "threads",
+			Description: "Allow or disallow threads of allowed channels",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Name:        "enabled",
+					Description: "Whether threads are allowed",
+					Required:    true,
+				},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "list",
+			Description: "Show where BrainyBuddy answers",
+		},
+	},
+}
+
+func (h *Handler) isAllowedScope(scope config.ChannelScope) bool {
+	if h.Guilds == nil {
+		return false
+	}
+	return h.Guilds.IsAllowed(scope)
+}
+
+// generationOptions resolves the generation settings configured for the guild
+// and channel; the global defaults apply to everything left unset.
+func (h *Handler) generationOptions(scope config.ChannelScope) aiContext.GenerationOptions {
+	if h.Guilds == nil {
+		return aiContext.GenerationOptions{Channel: scope.ChannelName}
+	}
+
+	settings := h.Guilds.Generation(scope)
+	return aiContext.GenerationOptions{
+		Model:       settings.Model,
+		Temperature: settings.Temperature,
+		MaxTokens:   settings.MaxTokens,
+		Profile:     settings.Profile,
+		Channel:     scope.ChannelName,
+	}
+}
+
+// resolveChannelScope looks up the thread parent and category of a channel.
+func resolveChannelScope(s *discordgo.Session, guildID string, channelID string) config.ChannelScope {
+	scope := config.ChannelScope{
+		GuildID:   guildID,
+		ChannelID: channelID,
+	}
+
+	channel, err := lookupChannel(s, channelID)
+	if err != nil {
+		log.Printf("Failed to look up channel %s: %v", channelID, err)
+		return scope
+	}
+
+	scope.ChannelName = channel.Name
+
+	if !channel.IsThread() {
+		scope.CategoryID = channel.ParentID
+		return scope
+	}
+
+	scope.IsThread = true
+	scope.ParentID = channel.ParentID
+
+	parent, err := lookupChannel(s, channel.ParentID)
+	if err != nil {
+		log.Printf("Failed to look up channel %s: %v", channel.ParentID, err)
+		return scope
+	}
+	scope.CategoryID = parent.ParentID
+
+	return scope
+}
+
+func lookupChannel(s *discordgo.Session, channelID string) (*discordgo.Channel, error) {
+	if channel, err := s.State.Channel(channelID); err == nil {
+		return channel, nil
+	}
+	return s.Channel(channelID)
+}
+
+func (h *Handler) channelsCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if h.Guilds == nil || i.GuildID == "" {
+		respondEphemeral(s, i, "Channel settings are only available in servers.")
+		return
+	}
+
+	subcommand := i.ApplicationCommandData().Options[0]
+
+	var err error
+	var msg string
+
+	switch subcommand.Name {
+	case "allow", "deny":
+		channel := subcommand.Options[0].ChannelValue(s)
+		allow := subcommand.Name == "allow"
+		err = h.updateChannelAccess(i.GuildID, channel, allow)
+		if allow {
+			msg = fmt.Sprintf("I will now answer in <#%s>.", channel.ID)
+		} else {
+			msg = fmt.Sprintf("I will no longer answer in <#%s>.", channel.ID)
+		}
+	case "threads":
+		enabled := subcommand.Options[0].BoolValue()
+		err = h.Guilds.SetAllowThreads(i.GuildID, enabled)
+		if enabled {
+			msg = "I will now answer in threads of allowed channels."
+		} else {
+			msg = "I will no longer answer in threads."
+		}
+	case "list":
+		msg = formatGuildConfig(h.Guilds.Guild(i.GuildID))
+	default:
+		msg = UnknownCommandMsg
+	}
+
+	if err != nil {
+		log.Printf("Failed to update channel settings of guild %s: %v", i.GuildID, err)
+		msg = "Sorry, I couldn't save the channel settings."
+	}
+
+	respondEphemeral(s, i, msg)
+}
+
+func (h *Handler) updateChannelAccess(guildID string, channel *discordgo.Channel, allow bool) error {
+	switch {
+	case channel.Type == discordgo.ChannelTypeGuildCategory && allow:
+		return h.Guilds.AllowCategory(guildID, channel.ID)
+	case channel.Type == discordgo.ChannelTypeGuildCategory:
+		return h.Guilds.DenyCategory(guildID, channel.ID)
+	case allow:
+		return h.Guilds.AllowChannel(guildID, channel.ID)
+	default:
+		return h.Guilds.DenyChannel(guildID, channel.ID)
+	}
+}
+
+func formatGuildConfig(guild config.GuildConfig) string {
+	if len(guild.AllowedChannels) == 0 && len(guild.AllowedCategories) == 0 {
+		return "I'm not allowed to answer anywhere in this server yet."
+	}
+
+	var sb strings.Builder
+	for _, id := range guild.AllowedChannels {
+		fmt.Fprintf(&sb, "Channel: <#%s>\n", id)
+	}
+	for _, id := range guild.AllowedCategories {
+		fmt.Fprintf(&sb, "Category: <#%s>\n", id)
+	}
+	fmt.Fprintf(&sb, "Threads allowed: %t", guild.AllowThreads)
+	return sb.String()
+}
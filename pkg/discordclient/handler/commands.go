@@ -0,0 +1,359 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"BrainyBuddyGo/pkg/openaiclient/breaker"
+	"BrainyBuddyGo/pkg/openaiclient/budget"
+	aiContext "BrainyBuddyGo/pkg/openaiclient/context"
+	"BrainyBuddyGo/pkg/openaiclient/queue"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	AskCommand     = "ask"
+	ResetCommand   = "reset"
+	HistoryCommand = "history"
+	UsageCommand   = "usage"
+	StatusCommand  = "status"
+
+	ResetDoneMsg       = "Your conversation has been reset."
+	SharedResetDoneMsg = "The conversation of this channel has been reset."
+	SharedResetDenyMsg = "Only members who can manage messages can reset the conversation of this channel."
+	NoHistoryMsg       = "You don't have a conversation with me yet."
+	NoSharedHistoryMsg = "There is no conversation with me in this channel yet."
+	UnknownCommandMsg  = "Unknown command."
+	UnlimitedUsageMsg  = "You can ask as many questions as you like."
+)
+
+// UsageReporter is implemented by limiters able to report the current quota of
+// a user with roleIDs in the guild. A limit of zero means the user is not
+// limited.
+type UsageReporter interface {
+	Usage(userID string, guildID string, roleIDs []string) (used int, limit int, resetIn time.Duration)
+}
+
+// StatusReporter is implemented by responders able to tell whether the API
+// they depend on is available.
+type StatusReporter interface {
+	APIStatus() breaker.Status
+}
+
+// QueueReporter is implemented by responders queueing their requests to the API.
+type QueueReporter interface {
+	QueueStats() queue.Stats
+}
+
+// ConversationKeeper is implemented by responders remembering conversations,
+// which enables the /reset and /history commands.
+type ConversationKeeper interface {
+	ConversationHistory(key aiContext.ConversationKey) []openai.ChatCompletionMessage
+	ResetConversation(key aiContext.ConversationKey)
+}
+
+type CommandHandler func(s *discordgo.Session, i *discordgo.InteractionCreate)
+
+var commandDefinitions = []*discordgo.ApplicationCommand{
+	{
+		Name:        AskCommand,
+		Description: "Ask BrainyBuddy a question",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "question",
+				Description: "What do you want to know?",
+				Required:    true,
+			},
+		},
+	},
+	{
+		Name:        ResetCommand,
+		Description: "Forget your conversation with BrainyBuddy",
+	},
+	{
+		Name:        HistoryCommand,
+		Description: "Show your current conversation with BrainyBuddy",
+	},
+	{
+		Name:        UsageCommand,
+		Description: "Show how many questions you can still ask",
+	},
+	{
+		Name:        StatusCommand,
+		Description: "Show whether BrainyBuddy can answer questions right now",
+	},
+	channelsCommandDefinition,
+}
+
+func (h *Handler) commandHandlers() map[string]CommandHandler {
+	return map[string]CommandHandler{
+		AskCommand:      h.askCommand,
+		ResetCommand:    h.resetCommand,
+		HistoryCommand:  h.historyCommand,
+		UsageCommand:    h.usageCommand,
+		StatusCommand:   h.statusCommand,
+		ChannelsCommand: h.channelsCommand,
+	}
+}
+
+// RegisterCommands overwrites the application commands of the bot, which also
+// removes commands left over from previous versions.
+func (h *Handler) RegisterCommands(s *discordgo.Session) error {
+	registered, err := s.ApplicationCommandBulkOverwrite(s.State.User.ID, "", commandDefinitions)
+	if err != nil {
+		return fmt.Errorf("failed to register application commands: %w", err)
+	}
+
+	h.commandsMutex.Lock()
+	h.registeredCommands = registered
+	h.commandsMutex.Unlock()
+
+	log.Printf("Registered %d application commands", len(registered))
+	return nil
+}
+
+// UnregisterCommands deletes the application commands registered by RegisterCommands.
+func (h *Handler) UnregisterCommands(s *discordgo.Session) error {
+	h.commandsMutex.Lock()
+	defer h.commandsMutex.Unlock()
+
+	for _, cmd := range h.registeredCommands {
+		if err := s.ApplicationCommandDelete(s.State.User.ID, "", cmd.ID); err != nil {
+			return fmt.Errorf("failed to delete application command %s: %w", cmd.Name, err)
+		}
+	}
+	h.registeredCommands = nil
+
+	return nil
+}
+
+func (h *Handler) InteractionCreateHandler(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionApplicationCommand {
+		return
+	}
+
+	if !h.begin() {
+		respondEphemeral(s, i, ShuttingDownMsg)
+		return
+	}
+	defer h.end()
+
+	name := i.ApplicationCommandData().Name
+	handle, ok := h.commandHandlers()[name]
+	if !ok {
+		respondEphemeral(s, i, UnknownCommandMsg)
+		return
+	}
+
+	log.Printf("Command /%s from %s in channel %s", name, interactionUser(i).Username, i.ChannelID)
+	handle(s, i)
+}
+
+func (h *Handler) askCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if h.Responder == nil {
+		respondEphemeral(s, i, UnableToAssistMsg)
+		return
+	}
+
+	scope := resolveChannelScope(s, i.GuildID, i.ChannelID)
+	if !h.isAllowedScope(scope) {
+		respondEphemeral(s, i, ChannelNotAllowedMsg)
+		return
+	}
+
+	question := stringOption(i, "question")
+	author := interactionAsker(i)
+
+	// Moderation and generation can easily exceed the three seconds Discord
+	// gives us to answer, so acknowledge the interaction first.
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+	if err != nil {
+		log.Printf("Failed to defer interaction response: %v", err)
+		return
+	}
+
+	streamer := newMessageStreamer(func(content string) error {
+		_, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &content})
+		return err
+	}, h.Settings().StreamEditInterval)
+
+	ctx, cancel := h.requestContext(author)
+	defer cancel()
+
+	response, answered := h.answer(ctx, streamer, question, scope, author)
+	if !answered {
+		streamer.Finish(response)
+		return
+	}
+	streamer.Finish(fmt.Sprintf("> %s\n\n%s", question, response))
+}
+
+func (h *Handler) resetCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	keeper, ok := h.Responder.(ConversationKeeper)
+	if !ok {
+		respondEphemeral(s, i, UnableToAssistMsg)
+		return
+	}
+
+	key := h.conversationKey(resolveChannelScope(s, i.GuildID, i.ChannelID), interactionAsker(i))
+	if key.UserID == "" {
+		// A shared conversation belongs to everyone in the channel
+		if !canManageMessages(i) {
+			respondEphemeral(s, i, SharedResetDenyMsg)
+			return
+		}
+		keeper.ResetConversation(key)
+		respondEphemeral(s, i, SharedResetDoneMsg)
+		return
+	}
+	keeper.ResetConversation(key)
+	respondEphemeral(s, i, ResetDoneMsg)
+}
+
+// canManageMessages tells whether the member invoking the interaction may
+// manage the messages of its channel.
+func canManageMessages(i *discordgo.InteractionCreate) bool {
+	if i.Member == nil {
+		return false
+	}
+	return i.Member.Permissions&(discordgo.PermissionManageMessages|discordgo.PermissionAdministrator) != 0
+}
+
+func (h *Handler) historyCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	keeper, ok := h.Responder.(ConversationKeeper)
+	if !ok {
+		respondEphemeral(s, i, UnableToAssistMsg)
+		return
+	}
+
+	key := h.conversationKey(resolveChannelScope(s, i.GuildID, i.ChannelID), interactionAsker(i))
+	shared := key.UserID == ""
+	history := keeper.ConversationHistory(key)
+	if len(history) == 0 && shared {
+		respondEphemeral(s, i, NoSharedHistoryMsg)
+		return
+	}
+	if len(history) == 0 {
+		respondEphemeral(s, i, NoHistoryMsg)
+		return
+	}
+
+	respondEphemeral(s, i, formatHistory(history, shared))
+}
+
+func (h *Handler) usageCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	var lines []string
+	author := interactionAsker(i)
+
+	if reporter, ok := h.Limiter.(UsageReporter); ok {
+		used, limit, resetIn := reporter.Usage(author.userID, author.guildID, author.roles)
+		if limit <= 0 {
+			lines = append(lines, UnlimitedUsageMsg)
+		} else {
+			msg := fmt.Sprintf("You have asked %d of %d questions.", used, limit)
+			if used > 0 {
+				msg += fmt.Sprintf(" You get another question in %.0f minutes.", resetIn.Minutes())
+			}
+			lines = append(lines, msg)
+		}
+	}
+
+	if reporter, ok := h.Responder.(BudgetReporter); ok {
+		for _, status := range reporter.BudgetUsage(budget.Account{User: author.userID, Guild: author.guildID}) {
+			lines = append(lines, formatBudgetStatus(status))
+		}
+	}
+
+	if len(lines) == 0 {
+		respondEphemeral(s, i, "Usage information is not available.")
+		return
+	}
+	respondEphemeral(s, i, strings.Join(lines, "\n"))
+}
+
+func (h *Handler) statusCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	reporter, ok := h.Responder.(StatusReporter)
+	if !ok {
+		respondEphemeral(s, i, "Status information is not available.")
+		return
+	}
+
+	msg := formatStatus(reporter.APIStatus(), time.Now())
+	if queueReporter, ok := h.Responder.(QueueReporter); ok {
+		if stats := queueReporter.QueueStats(); stats.Waiting > 0 {
+			msg += fmt.Sprintf(" %d questions are waiting in line.", stats.Waiting)
+		}
+	}
+	respondEphemeral(s, i, msg)
+}
+
+func formatStatus(status breaker.Status, now time.Time) string {
+	switch status.State {
+	case breaker.Open:
+		retryIn := status.RetryAt.Sub(now).Round(time.Second)
+		if retryIn < time.Second {
+			retryIn = time.Second
+		}
+		return fmt.Sprintf("The AI service is unavailable after %d failed requests, I'll try again in %v.", status.Failures, retryIn)
+	case breaker.HalfOpen:
+		return "The AI service was unavailable, I'm checking whether it's back."
+	default:
+		if status.Failures > 0 {
+			return fmt.Sprintf("I'm answering questions, but the last %d requests to the AI service failed.", status.Failures)
+		}
+		return "I'm up and answering questions."
+	}
+}
+
+// formatHistory lists the messages of a conversation. The user messages of a
+// shared conversation are shown with the name of their author.
+func formatHistory(history []openai.ChatCompletionMessage, shared bool) string {
+	var sb strings.Builder
+	for _, message := range history {
+		speaker := "BrainyBuddy"
+		if message.Role == openai.ChatMessageRoleUser {
+			speaker = "You"
+			if shared && message.Name != "" {
+				speaker = message.Name
+			}
+		}
+		fmt.Fprintf(&sb, "**%s:** %s\n", speaker, message.Content)
+	}
+	return truncateMessage(sb.String(), 0)
+}
+
+func respondEphemeral(s *discordgo.Session, i *discordgo.InteractionCreate, content string) {
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		log.Printf("Failed to respond to interaction: %v", err)
+	}
+}
+
+func interactionUser(i *discordgo.InteractionCreate) *discordgo.User {
+	if i.Member != nil && i.Member.User != nil {
+		return i.Member.User
+	}
+	return i.User
+}
+
+func stringOption(i *discordgo.InteractionCreate, name string) string {
+	for _, option := range i.ApplicationCommandData().Options {
+		if option.Name == name {
+			return option.StringValue()
+		}
+	}
+	return ""
+}
@@ -1,108 +1,383 @@
 package handler
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
+	"sync"
 	"time"
 
+	config "BrainyBuddyGo/Config"
+	"BrainyBuddyGo/pkg/openaiclient/breaker"
+	"BrainyBuddyGo/pkg/openaiclient/budget"
 	aiContext "BrainyBuddyGo/pkg/openaiclient/context"
+	"BrainyBuddyGo/pkg/openaiclient/queue"
 
 	"github.com/bwmarrin/discordgo"
 )
 
 const (
-	ModerateQuestionMaxRetries = 3
+	ModerateQuestionMaxRetries = config.DefaultModerationMaxRetries
 	UnableToAssistMsg          = "I'm sorry, but I'm not able to assist at this time."
 	CantAnswerNowMsg           = "Sorry, I can't answer that question right now."
+	TimedOutMsg                = "Sorry, that took too long to answer. Please try again."
+	ShuttingDownMsg            = "I'm restarting, please ask again in a minute."
+	UnavailableMsg             = "I'm temporarily unavailable, please try again in a few minutes."
+	BusyMsg                    = "I'm getting too many questions right now, please try again in a few minutes."
+	QueuedMsg                  = "You're #%d in line, I'll answer as soon as I can..."
 )
 
-var allowedChannels = []string{
-	"1114708430859550771",
+// MessageLimiter limits the questions of a user. The limit may depend on the
+// guild the question is asked in, empty outside of guilds, and the roles of the
+// user there, see BoosterRole.
+type MessageLimiter interface {
+	RegisterMessage(userID string, guildID string, roleIDs []string) (bool, time.Duration)
 }
 
-type MessageLimiter interface {
-	RegisterMessage(userID string) (bool, time.Duration)
+// Moderator decides whether a question may be answered.
+type Moderator interface {
+	ModerationCheck(ctx context.Context, input string, maxRetries int) (bool, error)
+}
+
+// Responder answers questions, either at once or by reporting the partial
+// answer to onUpdate while it is generated.
+type Responder interface {
+	GenerateResponse(ctx context.Context, input string, author aiContext.Author, opts aiContext.GenerationOptions) (string, error)
+	GenerateResponseStream(ctx context.Context, input string, author aiContext.Author, opts aiContext.GenerationOptions, onUpdate func(partial string)) (string, error)
+}
+
+// Settings tunes how the handler talks to the AI context and Discord.
+type Settings struct {
+	ModerationMaxRetries int
+	StreamEditInterval   time.Duration
+	// RequestTimeout bounds moderation and generation of a single question,
+	// zero means no deadline.
+	RequestTimeout time.Duration
+	// ShutdownTimeout is the time given to the questions in flight to be
+	// answered when the bot stops.
+	ShutdownTimeout time.Duration
+	// Priorities moves the questions of members of some roles ahead in the
+	// queue of requests to the API.
+	Priorities queue.Priorities
+	// Conversation is the conversation scope of the channels without one in
+	// the guild settings, see config.ConversationPerUser.
+	Conversation string
+	// SharedHistory is the number of messages kept per channel for shared
+	// conversations, zero disables it.
+	SharedHistory int
 }
 
+func DefaultSettings() Settings {
+	return Settings{
+		ModerationMaxRetries: ModerateQuestionMaxRetries,
+		StreamEditInterval:   StreamEditInterval,
+		RequestTimeout:       config.DefaultRequestTimeout,
+		ShutdownTimeout:      config.DefaultShutdownTimeout,
+		Conversation:         config.ConversationPerUser,
+		SharedHistory:        config.DefaultSharedHistory,
+	}
+}
+
+// Handler answers Discord events. A nil Moderator lets every question through,
+// NewHandler warns about it.
+// Questions are answered within the context given to NewHandler, cancelling it
+// aborts every question in flight.
 type Handler struct {
-	AIContext *aiContext.OpenAiContext
+	ctx context.Context
+
+	Responder Responder
+	Moderator Moderator
 	Limiter   MessageLimiter
+	Guilds    *config.GuildSettings
+
+	settings      Settings
+	settingsMutex sync.RWMutex
+
+	registeredCommands []*discordgo.ApplicationCommand
+	commandsMutex      sync.Mutex
+
+	channelHistory channelHistory
+
+	// inFlight counts the events being handled, draining stops new ones.
+	inFlight   sync.WaitGroup
+	draining   bool
+	drainMutex sync.Mutex
 }
 
-func NewHandler(aiContext *aiContext.OpenAiContext, limiter MessageLimiter) *Handler {
+func NewHandler(ctx context.Context, responder Responder, moderator Moderator, limiter MessageLimiter, guilds *config.GuildSettings, settings Settings) *Handler {
+	if moderator == nil {
+		log.Println("Warning: no moderator is set, questions will be answered without moderation")
+	}
 	return &Handler{
-		AIContext: aiContext,
+		ctx:       ctx,
+		Responder: responder,
+		Moderator: moderator,
 		Limiter:   limiter,
+		Guilds:    guilds,
+		settings:  settings,
+	}
+}
+
+// Settings returns the settings currently in use.
+func (h *Handler) Settings() Settings {
+	h.settingsMutex.RLock()
+	defer h.settingsMutex.RUnlock()
+	return h.settings
+}
+
+// UpdateSettings replaces the settings used by the next questions.
+func (h *Handler) UpdateSettings(settings Settings) {
+	h.settingsMutex.Lock()
+	defer h.settingsMutex.Unlock()
+	h.settings = settings
+}
+
+// begin registers an event being handled. It returns false once the handler is
+// draining, in which case the event must be dropped.
+func (h *Handler) begin() bool {
+	h.drainMutex.Lock()
+	defer h.drainMutex.Unlock()
+
+	if h.draining {
+		return false
+	}
+	h.inFlight.Add(1)
+	return true
+}
+
+func (h *Handler) end() {
+	h.inFlight.Done()
+}
+
+// Drain stops the handler from accepting new events and waits until the events
+// in flight are handled or ctx is done. It can be called again, for instance
+// after cancelling the context given to NewHandler to abort the questions still
+// being answered.
+func (h *Handler) Drain(ctx context.Context) error {
+	h.drainMutex.Lock()
+	h.draining = true
+	h.drainMutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		h.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// requestContext returns the context a question of author is answered in. Its
+// requests to the API wait in the queue with the priority of the author and are
+// charged to their budgets.
+func (h *Handler) requestContext(author asker) (context.Context, context.CancelFunc) {
+	settings := h.Settings()
+
+	ctx := queue.WithRequester(h.ctx, queue.Requester{
+		User:     author.userID,
+		Priority: settings.Priorities.Of(author.guildID, author.roles),
+	})
+	ctx = budget.WithAccount(ctx, budget.Account{User: author.userID, Guild: author.guildID})
+
+	if settings.RequestTimeout > 0 {
+		return context.WithTimeout(ctx, settings.RequestTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// withQueueFeedback reports the position of the requests of ctx in the queue
+// on the message edited by streamer.
+func withQueueFeedback(ctx context.Context, streamer *messageStreamer) context.Context {
+	requester := queue.RequesterFrom(ctx)
+	requester.OnPosition = func(position int) {
+		streamer.Status(fmt.Sprintf(QueuedMsg, position))
+	}
+	return queue.WithRequester(ctx, requester)
+}
+
+// failureMessage is the reply to a question that failed with err in ctx.
+func failureMessage(ctx context.Context, err error) string {
+	if errors.Is(err, breaker.ErrOpen) {
+		return UnavailableMsg
+	}
+	if errors.Is(err, queue.ErrFull) {
+		return BusyMsg
+	}
+	var exhausted *budget.ExhaustedError
+	if errors.As(err, &exhausted) {
+		return formatBudgetExhausted(exhausted.Status, time.Now())
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return TimedOutMsg
 	}
+	return CantAnswerNowMsg
 }
 
-func Ready(s *discordgo.Session, event *discordgo.Ready) {
+func (h *Handler) Ready(s *discordgo.Session, event *discordgo.Ready) {
 	log.Printf("Bot is ready with the following guilds:")
 	for _, guild := range event.Guilds {
 		log.Printf(" - %s", guild.Name)
+		if h.Guilds != nil && !h.Guilds.HasAllowedChannels(guild.ID) {
+			log.Printf("No channel is allowed in guild %s yet, an admin has to run /%s allow", guild.ID, ChannelsCommand)
+		}
+	}
+
+	if err := h.RegisterCommands(s); err != nil {
+		log.Println(err)
 	}
 }
 
-func isAllowedChannel(channelID string) bool {
-	for _, id := range allowedChannels {
-		if channelID == id {
+func isBotMentioned(s *discordgo.Session, m *discordgo.MessageCreate) bool {
+	for _, user := range m.Mentions {
+		if user.ID == s.State.User.ID {
 			return true
 		}
 	}
 	return false
 }
 
+func stripBotMention(s *discordgo.Session, content string) string {
+	content = strings.ReplaceAll(content, "<@"+s.State.User.ID+">", "")
+	content = strings.ReplaceAll(content, "<@!"+s.State.User.ID+">", "")
+	return strings.TrimSpace(content)
+}
+
 func (h *Handler) MessageCreateHandler(s *discordgo.Session, m *discordgo.MessageCreate) {
 	if m.Author.ID == s.State.User.ID {
 		return
 	}
 
-	if !isAllowedChannel(m.ChannelID) {
+	if !h.begin() {
 		return
 	}
+	defer h.end()
+
+	// Free-text messages are only answered when the bot is mentioned, everything
+	// else goes through the application commands. Shared conversations still
+	// keep them to follow the discussion.
+	if !isBotMentioned(s, m) {
+		h.rememberMessage(s, m)
+		return
+	}
+
+	scope := resolveChannelScope(s, m.GuildID, m.ChannelID)
+	if !h.isAllowedScope(scope) {
+		return
+	}
+
+	m.Content = stripBotMention(s, m.Content)
 
 	log.Printf("Message from %s saying %s in channel %s", m.Author.Username, m.Content, m.ChannelID)
 
-	if h.AIContext == nil {
+	if h.Responder == nil {
 		log.Println(aiContext.ErrUninitOpenAI)
 		return
 	}
 
-	response, err := h.GenerateAIResponse(m.Content, m.Author.Username)
+	author := messageAsker(m)
+	ctx, cancel := h.requestContext(author)
+	defer cancel()
+
+	placeholder, err := s.ChannelMessageSendReply(m.ChannelID, StreamPlaceholderMsg, m.Reference())
 	if err != nil {
-		log.Printf("Failed to generate response: %v", err)
+		log.Printf("Failed to send message: %v", err)
 		return
 	}
 
-	if _, err := s.ChannelMessageSendReply(m.ChannelID, response, m.Reference()); err != nil {
-		log.Printf("Failed to send message: %v", err)
+	streamer := newMessageStreamer(func(content string) error {
+		_, err := s.ChannelMessageEdit(placeholder.ChannelID, placeholder.ID, content)
+		return err
+	}, h.Settings().StreamEditInterval)
+
+	response, _ := h.answer(ctx, streamer, m.Content, scope, author)
+	streamer.Finish(response)
+}
+
+// answer checks question and answers it, showing the position of author in the
+// queue and the partial answer on streamer. It returns the reply to finish the
+// streamer with, and whether the question was answered rather than refused.
+func (h *Handler) answer(ctx context.Context, streamer *messageStreamer, question string, scope config.ChannelScope, author asker) (string, bool) {
+	// Moderation waits in the queue as well, so the position is shown from the
+	// start.
+	ctx = withQueueFeedback(ctx, streamer)
+
+	if refusal, ok := h.checkQuestion(ctx, question, author); !ok {
+		return refusal, false
+	}
+
+	ctx, notes := withBudgetNotes(ctx)
+	aiAuthor, opts := h.question(ctx, scope, author)
+	response, err := h.Responder.GenerateResponseStream(ctx, question, aiAuthor, opts, streamer.Update)
+	if err != nil {
+		h.unanswered(scope, opts)
+		log.Printf("Failed to generate response for question from %s: %v", aiAuthor, err)
+		return failureMessage(ctx, err), true
 	}
+	return notes.appendTo(response), true
 }
 
-func (h *Handler) GenerateAIResponse(question string, authorUsername string) (string, error) {
-	if h.AIContext == nil {
+// GenerateAIResponse answers the message m at once, with the same checks and
+// settings as the streamed answers.
+func (h *Handler) GenerateAIResponse(s *discordgo.Session, m *discordgo.MessageCreate) (string, error) {
+	if h.Responder == nil {
 		return UnableToAssistMsg, fmt.Errorf(aiContext.ErrUninitOpenAI.Error())
 	}
 
-	ok, timeLeft := h.Limiter.RegisterMessage(authorUsername)
-	if !ok {
-		return fmt.Sprintf("Sorry, you can ask another question in %.0f minutes", timeLeft.Minutes()), nil
+	author := messageAsker(m)
+	ctx, cancel := h.requestContext(author)
+	defer cancel()
+
+	question := stripBotMention(s, m.Content)
+	if refusal, ok := h.checkQuestion(ctx, question, author); !ok {
+		return refusal, nil
 	}
 
-	flagged, err := h.AIContext.ModerationCheck(question, ModerateQuestionMaxRetries)
+	ctx, notes := withBudgetNotes(ctx)
+	scope := resolveChannelScope(s, m.GuildID, m.ChannelID)
+	aiAuthor, opts := h.question(ctx, scope, author)
+	response, err := h.Responder.GenerateResponse(ctx, question, aiAuthor, opts)
 	if err != nil {
-		log.Printf("Failed to moderate question from %s : %v", authorUsername, err)
-		return CantAnswerNowMsg, err
+		h.unanswered(scope, opts)
+		log.Printf("Failed to generate response for question from %s: %v", aiAuthor, err)
+		return failureMessage(ctx, err), err
 	}
+	return notes.appendTo(response), nil
+}
 
-	if flagged {
-		return UnableToAssistMsg, nil
+// checkQuestion applies the budgets, the rate limit and moderation to a
+// question. When the question must not be answered it returns the reply to
+// send instead.
+func (h *Handler) checkQuestion(ctx context.Context, question string, author asker) (string, bool) {
+	if checker, ok := h.Responder.(BudgetChecker); ok {
+		if err := checker.CheckBudget(budget.AccountFrom(ctx)); err != nil {
+			return failureMessage(ctx, err), false
+		}
+	}
+
+	if ok, timeLeft := h.Limiter.RegisterMessage(author.userID, author.guildID, author.roles); !ok {
+		return fmt.Sprintf("Sorry, you can ask another question in %.0f minutes", timeLeft.Minutes()), false
+	}
+
+	if h.Moderator == nil {
+		return "", true
 	}
 
-	response, err := h.AIContext.GenerateResponse(question, authorUsername)
+	flagged, err := h.Moderator.ModerationCheck(ctx, question, h.Settings().ModerationMaxRetries)
 	if err != nil {
-		log.Printf("Failed to generate response for question from %s: %v", authorUsername, err)
-		return CantAnswerNowMsg, err
+		log.Printf("Failed to moderate question from %s (%s): %v", author.displayName, author.userID, err)
+		return failureMessage(ctx, err), false
 	}
-	return response, nil
+
+	if flagged {
+		return UnableToAssistMsg, false
+	}
+
+	return "", true
 }
@@ -0,0 +1,189 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+
+	config "BrainyBuddyGo/Config"
+	aiContext "BrainyBuddyGo/pkg/openaiclient/context"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// channelHistory keeps the messages posted in channels with a shared
+// conversation that were not addressed to the bot, until the next question
+// there takes them.
+type channelHistory struct {
+	messages map[string][]aiContext.ChannelMessage
+	mutex    sync.Mutex
+}
+
+// add keeps message, dropping the oldest messages of the channel beyond limit.
+func (c *channelHistory) add(channelID string, message aiContext.ChannelMessage, limit int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.messages == nil {
+		c.messages = make(map[string][]aiContext.ChannelMessage)
+	}
+	messages := append(c.messages[channelID], message)
+	if len(messages) > limit {
+		messages = append([]aiContext.ChannelMessage(nil), messages[len(messages)-limit:]...)
+	}
+	c.messages[channelID] = messages
+}
+
+// take returns and forgets the messages kept for the channel.
+func (c *channelHistory) take(channelID string) []aiContext.ChannelMessage {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	messages := c.messages[channelID]
+	delete(c.messages, channelID)
+	return messages
+}
+
+// restore gives back messages taken for a question that was not answered, so
+// that the next question is given them. They are older than the messages kept
+// since, and the oldest are dropped beyond limit.
+func (c *channelHistory) restore(channelID string, messages []aiContext.ChannelMessage, limit int) {
+	if len(messages) == 0 {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.messages == nil {
+		c.messages = make(map[string][]aiContext.ChannelMessage)
+	}
+	messages = append(append([]aiContext.ChannelMessage(nil), messages...), c.messages[channelID]...)
+	if len(messages) > limit {
+		messages = messages[len(messages)-limit:]
+	}
+	c.messages[channelID] = messages
+}
+
+// mayShareConversations reports whether any channel can share a conversation,
+// otherwise the messages not addressed to the bot are not even looked at.
+func (h *Handler) mayShareConversations() bool {
+	switch h.Settings().Conversation {
+	case config.ConversationPerChannel, config.ConversationPerThread:
+		return true
+	}
+	return h.Guilds != nil && h.Guilds.SharesConversations()
+}
+
+// sharesConversation reports whether everyone in the channel of scope shares
+// a single conversation with the bot. Guild and channel settings override the
+// global conversation scope.
+func (h *Handler) sharesConversation(scope config.ChannelScope) bool {
+	conversation := h.Settings().Conversation
+	if h.Guilds != nil {
+		if override := h.Guilds.Generation(scope).Conversation; override != "" {
+			conversation = override
+		}
+	}
+
+	switch conversation {
+	case config.ConversationPerChannel:
+		return true
+	case config.ConversationPerThread:
+		return scope.IsThread
+	default:
+		return false
+	}
+}
+
+// conversationKey is the key of the conversation a question of author in scope
+// continues.
+func (h *Handler) conversationKey(scope config.ChannelScope, author asker) aiContext.ConversationKey {
+	key := aiContext.ConversationKey{GuildID: scope.GuildID, ChannelID: scope.ChannelID}
+	if !h.sharesConversation(scope) {
+		key.UserID = author.userID
+	}
+	return key
+}
+
+// question resolves the conversation a question of author in scope continues
+// and the options to answer it with. Shared conversations are given what was
+// said in the channel since the last question, without the messages flagged
+// by moderation. The caller gives the backlog back with unanswered when the
+// question is not answered.
+func (h *Handler) question(ctx context.Context, scope config.ChannelScope, author asker) (aiContext.Author, aiContext.GenerationOptions) {
+	opts := h.generationOptions(scope)
+	key := h.conversationKey(scope, author)
+	if key.UserID == "" {
+		opts.Backlog = h.moderateBacklog(ctx, h.channelHistory.take(scope.ChannelID))
+	}
+	return author.aiAuthor(key), opts
+}
+
+// unanswered gives the backlog of a question that failed back to its channel.
+func (h *Handler) unanswered(scope config.ChannelScope, opts aiContext.GenerationOptions) {
+	h.channelHistory.restore(scope.ChannelID, opts.Backlog, h.Settings().SharedHistory)
+}
+
+// moderateBacklog drops the messages of backlog flagged by moderation. The
+// messages are checked together, and one by one only when that is flagged.
+// Nothing is kept when moderation fails.
+func (h *Handler) moderateBacklog(ctx context.Context, backlog []aiContext.ChannelMessage) []aiContext.ChannelMessage {
+	if h.Moderator == nil || len(backlog) == 0 {
+		return backlog
+	}
+	maxRetries := h.Settings().ModerationMaxRetries
+
+	contents := make([]string, len(backlog))
+	for i, message := range backlog {
+		contents[i] = message.Content
+	}
+	flagged, err := h.Moderator.ModerationCheck(ctx, strings.Join(contents, "\n"), maxRetries)
+	if err != nil {
+		log.Printf("Failed to moderate the messages of the channel, leaving them out: %v", err)
+		return nil
+	}
+	if !flagged {
+		return backlog
+	}
+
+	var kept []aiContext.ChannelMessage
+	for _, message := range backlog {
+		flagged, err := h.Moderator.ModerationCheck(ctx, message.Content, maxRetries)
+		if err != nil {
+			log.Printf("Failed to moderate a message of %s, leaving it out: %v", message.AuthorName, err)
+			continue
+		}
+		if !flagged {
+			kept = append(kept, message)
+		}
+	}
+	return kept
+}
+
+// rememberMessage keeps a message of a user not addressed to the bot when it
+// is posted in a channel with a shared conversation.
+func (h *Handler) rememberMessage(s *discordgo.Session, m *discordgo.MessageCreate) {
+	limit := h.Settings().SharedHistory
+	if limit <= 0 || m.GuildID == "" || m.Author.Bot || strings.TrimSpace(m.Content) == "" {
+		return
+	}
+	// Resolving the scope may call the API, which is only worth it when some
+	// channel shares its conversation.
+	if !h.mayShareConversations() {
+		return
+	}
+
+	scope := resolveChannelScope(s, m.GuildID, m.ChannelID)
+	if !h.isAllowedScope(scope) || !h.sharesConversation(scope) {
+		return
+	}
+
+	h.channelHistory.add(m.ChannelID, aiContext.ChannelMessage{
+		AuthorName:     displayName(m.Author, m.Member),
+		AuthorUsername: m.Author.Username,
+		AuthorID:       m.Author.ID,
+		Content:        m.Content,
+	}, limit)
+}
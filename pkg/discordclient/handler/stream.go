@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"log"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	config "BrainyBuddyGo/Config"
+)
+
+const (
+	StreamEditInterval   = config.DefaultStreamEditInterval
+	StreamPlaceholderMsg = "Thinking..."
+	StreamCursor         = " ▌"
+	MaxMessageLength     = 2000
+)
+
+// messageStreamer edits a single Discord message as a streamed response grows,
+// dropping intermediate updates that arrive faster than the edit interval.
+// Intermediate edits are made in the background so a slow edit never holds up
+// the stream.
+type messageStreamer struct {
+	edit     func(content string) error
+	interval time.Duration
+	lastEdit time.Time
+	lastSent string
+	// editing is set while an intermediate edit is in progress, updates
+	// arriving meanwhile are dropped.
+	editing  bool
+	finished bool
+	// inFlight tracks the intermediate edits, which must be done before the
+	// final content is sent so they cannot overwrite it.
+	inFlight sync.WaitGroup
+	// mutex guards the fields above, editMutex orders the edits.
+	mutex     sync.Mutex
+	editMutex sync.Mutex
+}
+
+func newMessageStreamer(edit func(content string) error, interval time.Duration) *messageStreamer {
+	return &messageStreamer{
+		edit:     edit,
+		interval: interval,
+		lastEdit: time.Now(),
+	}
+}
+
+// Update is used as the streaming callback and only edits the message when the
+// throttle interval has elapsed since the previous edit. It never waits for the
+// edit to be made.
+func (ms *messageStreamer) Update(partial string) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if ms.finished || ms.editing || time.Since(ms.lastEdit) < ms.interval {
+		return
+	}
+	ms.editing = true
+	ms.lastEdit = time.Now()
+	ms.inFlight.Add(1)
+
+	content := truncateMessage(partial, utf8.RuneCountInString(StreamCursor)) + StreamCursor
+	go func() {
+		defer ms.inFlight.Done()
+		ms.send(content)
+
+		ms.mutex.Lock()
+		ms.editing = false
+		ms.mutex.Unlock()
+	}()
+}
+
+// Status replaces the message with a status line, such as the position of the
+// question in the queue, without waiting for the throttle interval.
+func (ms *messageStreamer) Status(status string) {
+	ms.mutex.Lock()
+	finished := ms.finished
+	ms.mutex.Unlock()
+
+	if !finished {
+		ms.send(status)
+	}
+}
+
+// Finish always performs a final edit with the complete content, after the
+// intermediate edits in progress.
+func (ms *messageStreamer) Finish(content string) {
+	ms.mutex.Lock()
+	ms.finished = true
+	ms.mutex.Unlock()
+
+	ms.inFlight.Wait()
+	ms.send(truncateMessage(content, 0))
+}
+
+// send edits the message unless it already shows content. The state is not
+// locked during the edit, so updates keep flowing while it is made.
+func (ms *messageStreamer) send(content string) {
+	ms.editMutex.Lock()
+	defer ms.editMutex.Unlock()
+
+	ms.mutex.Lock()
+	unchanged := content == ms.lastSent
+	ms.mutex.Unlock()
+	if unchanged {
+		return
+	}
+
+	if err := ms.edit(content); err != nil {
+		log.Printf("Failed to edit streamed message: %v", err)
+		return
+	}
+
+	ms.mutex.Lock()
+	ms.lastSent = content
+	ms.lastEdit = time.Now()
+	ms.mutex.Unlock()
+}
+
+func truncateMessage(content string, reserved int) string {
+	limit := MaxMessageLength - reserved
+	runes := []rune(content)
+	if len(runes) <= limit {
+		return content
+	}
+	return string(runes[:limit-1]) + "…"
+}
@@ -0,0 +1,70 @@
+package limiter
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var usersBucket = []byte("users")
+
+// BoltStore is a Store persisted in a BoltDB file.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open limiter store: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(usersBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create limiter bucket: %w", err)
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+func (b *BoltStore) Load() (map[string]UserState, error) {
+	states := make(map[string]UserState)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(usersBucket).ForEach(func(k, data []byte) error {
+			var state UserState
+			if err := json.Unmarshal(data, &state); err != nil {
+				return fmt.Errorf("failed to decode rate limit of %s: %w", k, err)
+			}
+			states[string(k)] = state
+			return nil
+		})
+	})
+	return states, err
+}
+
+func (b *BoltStore) Put(userID string, state UserState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode rate limit of %s: %w", userID, err)
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(usersBucket).Put([]byte(userID), data)
+	})
+}
+
+func (b *BoltStore) Delete(userID string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(usersBucket).Delete([]byte(userID))
+	})
+}
+
+func (b *BoltStore) Close() error {
+	return b.db.Close()
+}
@@ -1,50 +1,281 @@
+// Package limiter limits how many questions users can ask. The limit of a user
+// depends on the guild and their roles there, see Rules, and is counted with
+// one of the strategies.
 package limiter
 
 import (
+	"fmt"
+	"log"
 	"sync"
 	"time"
+
+	"BrainyBuddyGo/pkg/defaults"
 )
 
-const MaxMessages = 5
-const LimitDuration = 3 * time.Hour
+const MaxMessages = defaults.LimiterMaxMessages
+const LimitDuration = defaults.LimiterWindow
+
+// DefaultPruneInterval is how often StartPruning forgets the users back to a
+// full quota when the configuration does not say otherwise.
+const DefaultPruneInterval = defaults.LimiterPruneInterval
+
+type userState struct {
+	// limit is the limit resolved for the last question of the user.
+	limit   Limit
+	counter counter
+}
 
 type MessageLimiter struct {
-	userMessages map[string][]time.Time
-	mutex        sync.Mutex
+	rules Rules
+	users map[string]*userState
+	// store, when set, receives the state of every user that changes.
+	store Store
+	// storeMutex orders the writes to the store, it is taken before mutex is
+	// released so that the store is written outside of mutex in order.
+	storeMutex sync.Mutex
+	now        func() time.Time
+	mutex      sync.Mutex
+
+	// done stops the pruning started by StartPruning, which closes pruneDone
+	// once it returns.
+	done      chan struct{}
+	pruneDone chan struct{}
 }
 
 func NewMessageLimiter() *MessageLimiter {
+	return NewMessageLimiterWithLimits(MaxMessages, LimitDuration)
+}
+
+// NewMessageLimiterWithLimits allows maxMessages per user within window.
+func NewMessageLimiterWithLimits(maxMessages int, window time.Duration) *MessageLimiter {
+	return NewMessageLimiterWithRules(DefaultRules(maxMessages, window))
+}
+
+func NewMessageLimiterWithRules(rules Rules) *MessageLimiter {
 	return &MessageLimiter{
-		userMessages: make(map[string][]time.Time),
+		rules: rules,
+		users: make(map[string]*userState),
+		now:   time.Now,
+	}
+}
+
+// NewPersistentMessageLimiter restores the users kept in store, which then
+// receives every change. The limiter owns the store and closes it in Close.
+// Earlier versions kept the users by username: their entries cannot be matched
+// to a user ID and are deleted from the store.
+func NewPersistentMessageLimiter(rules Rules, store Store) (*MessageLimiter, error) {
+	states, err := store.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load limiter state: %w", err)
+	}
+
+	m := NewMessageLimiterWithRules(rules)
+	m.store = store
+	legacy := 0
+	for userID, state := range states {
+		if !isUserID(userID) {
+			if err := store.Delete(userID); err != nil {
+				return nil, fmt.Errorf("failed to delete the rate limit kept for username %q: %w", userID, err)
+			}
+			legacy++
+			continue
+		}
+		m.users[userID] = &userState{
+			limit:   state.Limit,
+			counter: newCounter(state.Limit, state),
+		}
+	}
+
+	if legacy > 0 {
+		log.Printf("Deleted the rate limits of %d users kept by username by an earlier version", legacy)
+	}
+	log.Printf("Restored the rate limits of %d users", len(m.users))
+	return m, nil
+}
+
+// isUserID reports whether key is a Discord user ID, a snowflake written in
+// decimal.
+func isUserID(key string) bool {
+	if key == "" {
+		return false
 	}
+	for _, r := range key {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// SetClock replaces the source of the current time, for tests.
+func (m *MessageLimiter) SetClock(now func() time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.now = now
 }
 
-func (m *MessageLimiter) RegisterMessage(userID string) (bool, time.Duration) {
+// StartPruning forgets the idle users every interval until Close is called.
+func (m *MessageLimiter) StartPruning(interval time.Duration) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	messages, ok := m.userMessages[userID]
-	now := time.Now()
+	if m.done != nil {
+		return
+	}
+	m.done = make(chan struct{})
+	m.pruneDone = make(chan struct{})
+	go m.runPruning(interval, m.done, m.pruneDone)
+}
+
+func (m *MessageLimiter) runPruning(interval time.Duration, done <-chan struct{}, pruneDone chan<- struct{}) {
+	defer close(pruneDone)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
-	if ok {
-		startIndex := 0
-		for i, t := range messages {
-			if now.Sub(t).Hours() <= LimitDuration.Hours() {
-				startIndex = i
-				break
+	for {
+		select {
+		case <-ticker.C:
+			if pruned := m.Prune(); pruned > 0 {
+				log.Printf("Forgot the rate limits of %d idle users", pruned)
 			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// Prune forgets the users whose limit is back to its initial state, and
+// returns how many were forgotten.
+func (m *MessageLimiter) Prune() int {
+	m.mutex.Lock()
+
+	now := m.now()
+	var pruned []string
+	for userID, state := range m.users {
+		if !state.limit.Unlimited && !state.counter.idle(now, state.limit) {
+			continue
+		}
+		delete(m.users, userID)
+		pruned = append(pruned, userID)
+	}
+
+	if m.store == nil || len(pruned) == 0 {
+		m.mutex.Unlock()
+		return len(pruned)
+	}
+
+	m.storeMutex.Lock()
+	m.mutex.Unlock()
+	defer m.storeMutex.Unlock()
+	for _, userID := range pruned {
+		if err := m.store.Delete(userID); err != nil {
+			log.Printf("Failed to delete the rate limit of %s: %v", userID, err)
 		}
+	}
+	return len(pruned)
+}
+
+// Users returns the number of users currently kept.
+func (m *MessageLimiter) Users() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return len(m.users)
+}
+
+// Close stops the pruning and closes the store of a persistent limiter.
+func (m *MessageLimiter) Close() error {
+	m.mutex.Lock()
+	done, pruneDone := m.done, m.pruneDone
+	m.done = nil
+	m.mutex.Unlock()
+
+	if done != nil {
+		close(done)
+		<-pruneDone
+	}
+
+	if m.store == nil {
+		return nil
+	}
+	return m.store.Close()
+}
+
+// SetRules changes the limits applied from the next message on. Messages
+// already registered are kept, unless the strategy of a user changes.
+func (m *MessageLimiter) SetRules(rules Rules) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.rules = rules
+}
+
+// RegisterMessage applies the limit of a user with roleIDs in the guild, the
+// default limit when guildID is empty.
+func (m *MessageLimiter) RegisterMessage(userID string, guildID string, roleIDs []string) (bool, time.Duration) {
+	m.mutex.Lock()
+
+	limit := m.rules.Resolve(guildID, roleIDs)
+	// Unlimited users are neither counted nor kept in the store
+	if limit.Unlimited {
+		m.mutex.Unlock()
+		return true, 0
+	}
+
+	state, ok := m.users[userID]
+	if !ok || state.limit.Strategy != limit.Strategy {
+		state = &userState{counter: newCounter(limit, UserState{})}
+		m.users[userID] = state
+	}
+	state.limit = limit
+
+	allowed, timeLeft := state.counter.register(m.now(), limit)
 
-		messages = messages[startIndex:]
+	m.save(userID, state)
+	return allowed, timeLeft
+}
+
+// save is called with mutex held and releases it before writing the state of
+// a user to the store. Failures are only logged, questions keep being limited
+// from memory.
+func (m *MessageLimiter) save(userID string, state *userState) {
+	if m.store == nil {
+		m.mutex.Unlock()
+		return
+	}
+
+	saved := UserState{Limit: state.limit}
+	state.counter.save(&saved)
+
+	m.storeMutex.Lock()
+	m.mutex.Unlock()
+	defer m.storeMutex.Unlock()
+	if err := m.store.Put(userID, saved); err != nil {
+		log.Printf("Failed to save the rate limit of %s: %v", userID, err)
 	}
+}
 
-	messages = append(messages, now)
-	m.userMessages[userID] = messages
+// Usage reports how many messages the user with roleIDs in the guild sent that
+// count against their limit, the maximum allowed, zero when unlimited, and the
+// time until they can send one more. The limit is resolved as RegisterMessage
+// does.
+func (m *MessageLimiter) Usage(userID string, guildID string, roleIDs []string) (int, int, time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	limit := m.rules.Resolve(guildID, roleIDs)
+	if limit.Unlimited {
+		return 0, 0, 0
+	}
 
-	if len(messages) > MaxMessages {
-		timeLeft := LimitDuration - now.Sub(messages[0])
-		return false, timeLeft
+	// A new strategy starts counting afresh at the next message.
+	state, ok := m.users[userID]
+	if !ok || state.limit.Strategy != limit.Strategy {
+		return 0, limit.MaxMessages, 0
 	}
 
-	return true, 0
+	used, resetIn := state.counter.usage(m.now(), limit)
+	return used, limit.MaxMessages, resetIn
 }
@@ -0,0 +1,71 @@
+package limiter
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"BrainyBuddyGo/pkg/redis"
+)
+
+// DefaultRedisKey is the hash holding the state of the users, by user ID.
+const DefaultRedisKey = "brainybuddy:limiter"
+
+// RedisStore is a Store kept in a hash of a Redis compatible server. Like every
+// Store it is only written by the limiter using it, which counts from memory,
+// so it must not be shared by several instances of the bot running at once.
+type RedisStore struct {
+	client *redis.Client
+	key    string
+}
+
+// NewRedisStore connects to the server at rawURL, see redis.Dial.
+func NewRedisStore(rawURL string) (*RedisStore, error) {
+	client, err := redis.Dial(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open limiter store: %w", err)
+	}
+	return &RedisStore{client: client, key: DefaultRedisKey}, nil
+}
+
+func (r *RedisStore) Load() (map[string]UserState, error) {
+	reply, err := r.client.Do("HGETALL", r.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rate limits: %w", err)
+	}
+	values, ok := reply.([]interface{})
+	if !ok || len(values)%2 != 0 {
+		return nil, fmt.Errorf("unexpected reply %T to HGETALL", reply)
+	}
+
+	states := make(map[string]UserState, len(values)/2)
+	for i := 0; i < len(values); i += 2 {
+		userID, _ := values[i].(string)
+		data, _ := values[i+1].(string)
+
+		var state UserState
+		if err := json.Unmarshal([]byte(data), &state); err != nil {
+			return nil, fmt.Errorf("failed to decode rate limit of %s: %w", userID, err)
+		}
+		states[userID] = state
+	}
+	return states, nil
+}
+
+func (r *RedisStore) Put(userID string, state UserState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode rate limit of %s: %w", userID, err)
+	}
+
+	_, err = r.client.Do("HSET", r.key, userID, string(data))
+	return err
+}
+
+func (r *RedisStore) Delete(userID string) error {
+	_, err := r.client.Do("HDEL", r.key, userID)
+	return err
+}
+
+func (r *RedisStore) Close() error {
+	return r.client.Close()
+}
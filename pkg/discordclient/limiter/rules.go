@@ -0,0 +1,50 @@
+package limiter
+
+import "time"
+
+// Rules decide the limit of a user from the guild a question is asked in and
+// the roles of the user there.
+type Rules struct {
+	Default Limit
+	// Guilds overrides Default by guild ID.
+	Guilds map[string]GuildRules
+}
+
+type GuildRules struct {
+	// Limit replaces the fields of the default limit that are set.
+	Limit Limit
+	// Roles replace the fields of the guild limit that are set, by role ID. A
+	// user with several of these roles gets the most generous limit.
+	Roles map[string]Limit
+}
+
+// DefaultRules applies the same sliding window to everyone.
+func DefaultRules(maxMessages int, window time.Duration) Rules {
+	return Rules{Default: Limit{Strategy: SlidingWindow, MaxMessages: maxMessages, Window: window}}
+}
+
+// Resolve returns the limit of a user with roleIDs in the guild, the default
+// limit outside of guilds.
+func (r Rules) Resolve(guildID string, roleIDs []string) Limit {
+	limit := r.Default
+	guild, ok := r.Guilds[guildID]
+	if ok {
+		limit = limit.With(guild.Limit)
+	}
+
+	best, found := limit, false
+	for _, roleID := range roleIDs {
+		role, ok := guild.Roles[roleID]
+		if !ok {
+			continue
+		}
+		if candidate := limit.With(role); !found || candidate.perHour() > best.perHour() {
+			best, found = candidate, true
+		}
+	}
+
+	if best.Strategy == "" {
+		best.Strategy = SlidingWindow
+	}
+	return best
+}
@@ -0,0 +1,30 @@
+package limiter
+
+import "time"
+
+// UserState is the state of a user as kept by a Store. Only the fields of the
+// strategy of Limit are set.
+type UserState struct {
+	// Limit is the limit resolved for the last question of the user.
+	Limit Limit `json:"limit"`
+
+	// Messages are the questions counted by a sliding window.
+	Messages []time.Time `json:"messages,omitempty"`
+	// Tokens is the content of a token bucket at Last.
+	Tokens float64   `json:"tokens,omitempty"`
+	Last   time.Time `json:"last,omitempty"`
+	// Count is the number of questions of a daily quota on Day.
+	Day   time.Time `json:"day,omitempty"`
+	Count int       `json:"count,omitempty"`
+}
+
+// Store persists the state of the users so that their limits survive
+// restarts. The limiter keeps every user in memory: the store is read once at
+// startup and then receives every change.
+type Store interface {
+	// Load returns the state of every user.
+	Load() (map[string]UserState, error)
+	Put(userID string, state UserState) error
+	Delete(userID string) error
+	Close() error
+}
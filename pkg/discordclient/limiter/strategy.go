@@ -0,0 +1,256 @@
+package limiter
+
+import (
+	"math"
+	"time"
+
+	"BrainyBuddyGo/pkg/defaults"
+)
+
+// Strategy is the algorithm counting the questions of a user. It is defined
+// with the defaults, so that the configuration can name it without importing
+// the limiter.
+type Strategy = defaults.Strategy
+
+const (
+	// SlidingWindow allows MaxMessages questions in any period of Window.
+	SlidingWindow = defaults.SlidingWindow
+	// TokenBucket allows bursts of MaxMessages questions, refilled evenly over
+	// Window.
+	TokenBucket = defaults.TokenBucket
+	// DailyQuota allows MaxMessages questions per day, the quota resets at
+	// midnight UTC and Window is ignored.
+	DailyQuota = defaults.DailyQuota
+)
+
+// Limit is the quota of a user.
+type Limit struct {
+	// Strategy defaults to SlidingWindow when empty.
+	Strategy    Strategy
+	MaxMessages int
+	Window      time.Duration
+	Unlimited   bool
+	// CountRejected counts refused questions too, so that users who keep
+	// asking keep waiting. A daily quota resets at midnight either way.
+	CountRejected bool
+}
+
+// With returns the limit with the fields that are set in override replaced.
+// CountRejected is only taken from the default limit.
+func (l Limit) With(override Limit) Limit {
+	if override.Strategy != "" {
+		l.Strategy = override.Strategy
+	}
+	if override.MaxMessages > 0 {
+		l.MaxMessages = override.MaxMessages
+	}
+	if override.Window > 0 {
+		l.Window = override.Window
+	}
+	if override.Unlimited {
+		l.Unlimited = true
+	}
+	return l
+}
+
+// perHour is the average number of questions allowed per hour, used to find the
+// most generous of several limits.
+func (l Limit) perHour() float64 {
+	window := l.Window
+	if l.Strategy == DailyQuota {
+		window = 24 * time.Hour
+	}
+	if l.Unlimited || window <= 0 {
+		return math.Inf(1)
+	}
+	return float64(l.MaxMessages) / window.Hours()
+}
+
+// counter keeps the questions of a single user for one strategy. The limit is
+// given on every call so that changed limits apply to the questions already
+// counted.
+type counter interface {
+	// register counts a question asked at now, or reports how long the user
+	// has to wait when the limit is reached.
+	register(now time.Time, limit Limit) (bool, time.Duration)
+	// usage reports the questions counted and the time until the user can ask
+	// one more.
+	usage(now time.Time, limit Limit) (int, time.Duration)
+	// idle reports whether the counter is back to its initial state, so that
+	// forgetting the user changes nothing.
+	idle(now time.Time, limit Limit) bool
+	// save copies the counter to the fields of its strategy in state.
+	save(state *UserState)
+}
+
+// newCounter restores the counter of the strategy of limit from state.
+func newCounter(limit Limit, state UserState) counter {
+	switch limit.Strategy {
+	case TokenBucket:
+		return &tokenBucket{tokens: state.Tokens, last: state.Last}
+	case DailyQuota:
+		return &dailyQuota{day: state.Day, count: state.Count}
+	default:
+		return &slidingWindow{messages: state.Messages}
+	}
+}
+
+// slidingWindow keeps the time of the questions of the last Window, at most
+// MaxMessages of them since older ones cannot change the outcome.
+type slidingWindow struct {
+	messages []time.Time
+}
+
+// prune forgets the questions that left the window.
+func (w *slidingWindow) prune(now time.Time, limit Limit) {
+	i := 0
+	for i < len(w.messages) && now.Sub(w.messages[i]) >= limit.Window {
+		i++
+	}
+	w.messages = append(w.messages[:0], w.messages[i:]...)
+}
+
+func (w *slidingWindow) register(now time.Time, limit Limit) (bool, time.Duration) {
+	w.prune(now, limit)
+	if limit.MaxMessages <= 0 {
+		return false, limit.Window
+	}
+
+	if len(w.messages) < limit.MaxMessages {
+		w.messages = append(w.messages, now)
+		return true, 0
+	}
+
+	// The question that has to leave the window for one more to fit
+	oldest := w.messages[len(w.messages)-limit.MaxMessages]
+	timeLeft := limit.Window - now.Sub(oldest)
+	if limit.CountRejected {
+		w.messages = append(w.messages, now)
+		w.messages = append(w.messages[:0], w.messages[len(w.messages)-limit.MaxMessages:]...)
+	}
+	return false, timeLeft
+}
+
+func (w *slidingWindow) save(state *UserState) {
+	state.Messages = append([]time.Time(nil), w.messages...)
+}
+
+func (w *slidingWindow) usage(now time.Time, limit Limit) (int, time.Duration) {
+	w.prune(now, limit)
+	if len(w.messages) == 0 {
+		return 0, 0
+	}
+	return len(w.messages), limit.Window - now.Sub(w.messages[0])
+}
+
+func (w *slidingWindow) idle(now time.Time, limit Limit) bool {
+	w.prune(now, limit)
+	return len(w.messages) == 0
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens earned since the last call, a new bucket starts full.
+func (b *tokenBucket) refill(now time.Time, limit Limit) {
+	capacity := float64(limit.MaxMessages)
+	if b.last.IsZero() {
+		b.tokens = capacity
+	} else if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += capacity * float64(elapsed) / float64(limit.Window)
+	}
+	if b.tokens > capacity {
+		b.tokens = capacity
+	}
+	b.last = now
+}
+
+// tokenEpsilon absorbs the rounding of refills, so that waiting the time
+// reported by untilNextToken is always enough.
+const tokenEpsilon = 1e-9
+
+// untilNextToken is the time until the bucket holds one more whole token,
+// rounded up.
+func (b *tokenBucket) untilNextToken(limit Limit) time.Duration {
+	missing := math.Floor(b.tokens+tokenEpsilon) + 1 - b.tokens
+	return time.Duration(math.Ceil(missing * float64(limit.Window) / float64(limit.MaxMessages)))
+}
+
+func (b *tokenBucket) register(now time.Time, limit Limit) (bool, time.Duration) {
+	b.refill(now, limit)
+
+	if b.tokens < 1-tokenEpsilon {
+		timeLeft := b.untilNextToken(limit)
+		if limit.CountRejected {
+			// The partial token is spent, the wait starts over
+			b.tokens = 0
+			timeLeft = b.untilNextToken(limit)
+		}
+		return false, timeLeft
+	}
+
+	b.tokens = math.Max(b.tokens-1, 0)
+	return true, 0
+}
+
+func (b *tokenBucket) save(state *UserState) {
+	state.Tokens = b.tokens
+	state.Last = b.last
+}
+
+func (b *tokenBucket) usage(now time.Time, limit Limit) (int, time.Duration) {
+	b.refill(now, limit)
+
+	used := limit.MaxMessages - int(math.Floor(b.tokens+tokenEpsilon))
+	if used <= 0 {
+		return 0, 0
+	}
+	return used, b.untilNextToken(limit)
+}
+
+func (b *tokenBucket) idle(now time.Time, limit Limit) bool {
+	b.refill(now, limit)
+	return b.tokens >= float64(limit.MaxMessages)-tokenEpsilon
+}
+
+type dailyQuota struct {
+	day   time.Time
+	count int
+}
+
+func startOfDay(now time.Time) time.Time {
+	year, month, day := now.UTC().Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func (q *dailyQuota) register(now time.Time, limit Limit) (bool, time.Duration) {
+	if day := startOfDay(now); !day.Equal(q.day) {
+		q.day = day
+		q.count = 0
+	}
+
+	if q.count >= limit.MaxMessages {
+		return false, q.day.Add(24 * time.Hour).Sub(now)
+	}
+
+	q.count++
+	return true, 0
+}
+
+func (q *dailyQuota) save(state *UserState) {
+	state.Day = q.day
+	state.Count = q.count
+}
+
+func (q *dailyQuota) usage(now time.Time, limit Limit) (int, time.Duration) {
+	if !startOfDay(now).Equal(q.day) || q.count == 0 {
+		return 0, 0
+	}
+	return q.count, q.day.Add(24 * time.Hour).Sub(now)
+}
+
+func (q *dailyQuota) idle(now time.Time, limit Limit) bool {
+	return !startOfDay(now).Equal(q.day) || q.count == 0
+}
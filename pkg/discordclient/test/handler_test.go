@@ -0,0 +1,309 @@
+package handler_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	config "BrainyBuddyGo/Config"
+	discordContext "BrainyBuddyGo/pkg/discordclient/context"
+	"BrainyBuddyGo/pkg/discordclient/discordtest"
+	"BrainyBuddyGo/pkg/discordclient/handler"
+	"BrainyBuddyGo/pkg/discordclient/limiter"
+	aiContext "BrainyBuddyGo/pkg/openaiclient/context"
+	"BrainyBuddyGo/pkg/openaiclient/openaitest"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	guildID          = "300000000000000001"
+	allowedChannelID = "300000000000000002"
+	otherChannelID   = "300000000000000003"
+)
+
+var alice = discordtest.User("400000000000000001", "alice")
+
+type testBot struct {
+	discord *discordtest.Harness
+	openai  *openaitest.Server
+	context *discordContext.DiscordContext
+}
+
+// newTestBot connects a bot to a fake Discord and a fake OpenAI. It may only
+// answer in allowedChannelID.
+func newTestBot(t *testing.T) *testBot {
+	server := openaitest.NewServer()
+	t.Cleanup(server.Close)
+
+	dir := t.TempDir()
+	promptFile := filepath.Join(dir, "prompt.json")
+	if err := ioutil.WriteFile(promptFile, []byte(`{"normal": {"welcome": ["You are a helpful assistant."]}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	oaConfig := aiContext.DefaultConfig(openaitest.APIKey, 1)
+	oaConfig.BaseURL = server.BaseURL()
+	oaConfig.PromptFile = promptFile
+	oa, err := aiContext.NewOpenAiContextWithConfig(oaConfig, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(oa.Close)
+
+	discord := discordtest.New(t)
+	return &testBot{discord: discord, openai: server, context: connectBot(t, context.Background(), discord, oa, oa)}
+}
+
+// connectBot connects a bot answering with responder within ctx to the fake
+// Discord. It may only answer in allowedChannelID.
+func connectBot(t *testing.T, ctx context.Context, discord *discordtest.Harness, responder handler.Responder, moderator handler.Moderator) *discordContext.DiscordContext {
+	discord.AddChannel(&discordgo.Channel{ID: allowedChannelID, GuildID: guildID, Name: "general", Type: discordgo.ChannelTypeGuildText})
+	discord.AddChannel(&discordgo.Channel{ID: otherChannelID, GuildID: guildID, Name: "random", Type: discordgo.ChannelTypeGuildText})
+
+	guilds, err := config.LoadGuildSettings(filepath.Join(t.TempDir(), "guilds.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := guilds.AllowChannel(guildID, allowedChannelID); err != nil {
+		t.Fatal(err)
+	}
+
+	settings := handler.Settings{ModerationMaxRetries: 1, StreamEditInterval: 10 * time.Millisecond}
+	dc, err := discordContext.Initialize(ctx, discordtest.Token, responder, moderator, limiter.NewMessageLimiter(), guilds, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Handle events in order so a test can rely on earlier events being done.
+	dc.Session.SyncEvents = true
+	if err := dc.OpenConnection(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dc.CloseConnection() })
+
+	return dc
+}
+
+func TestReadyRegistersCommands(t *testing.T) {
+	bot := newTestBot(t)
+
+	bot.discord.WaitFor(t, "registered commands", func() bool {
+		return len(bot.discord.Commands()) > 0
+	})
+
+	names := make(map[string]bool)
+	for _, command := range bot.discord.Commands() {
+		names[command.Name] = true
+	}
+	for _, name := range []string{handler.AskCommand, handler.ResetCommand, handler.HistoryCommand, handler.UsageCommand, handler.StatusCommand, handler.ChannelsCommand} {
+		if !names[name] {
+			t.Errorf("Expected /%s to be registered, got %v", name, names)
+		}
+	}
+
+	if err := bot.context.CloseConnection(); err != nil {
+		t.Fatal(err)
+	}
+	if commands := bot.discord.Commands(); len(commands) != 0 {
+		t.Errorf("Expected the commands to be removed on close, got %d", len(commands))
+	}
+}
+
+func TestSessionRequestsMessageContent(t *testing.T) {
+	bot := newTestBot(t)
+
+	intents := bot.discord.Intents()
+	if intents&discordgo.IntentMessageContent == 0 {
+		t.Errorf("Expected the Message Content intent to be requested, got %b", intents)
+	}
+	if intents&discordgo.IntentsAllWithoutPrivileged != discordgo.IntentsAllWithoutPrivileged {
+		t.Errorf("Expected every intent without privileges to be requested, got %b", intents)
+	}
+	if intents&(discordgo.IntentGuildMembers|discordgo.IntentGuildPresences) != 0 {
+		t.Errorf("Expected no other privileged intent to be requested, got %b", intents)
+	}
+}
+
+func TestMentionIsAnsweredWithStreamedReply(t *testing.T) {
+	bot := newTestBot(t)
+	bot.openai.EnqueueChat(openaitest.Response{Chunks: []string{"Hello ", "alice!"}, FinishReason: "stop"})
+
+	question := bot.discord.NewMessage(guildID, allowedChannelID, alice, bot.discord.Mention()+" hi there")
+	if err := bot.discord.MessageCreate(question); err != nil {
+		t.Fatal(err)
+	}
+
+	reply := bot.discord.WaitForMessage(t, allowedChannelID, func(m discordtest.Message) bool {
+		return m.Content() == "Hello alice!"
+	})
+
+	if reply.Contents[0] != handler.StreamPlaceholderMsg {
+		t.Errorf("Expected the reply to start as a placeholder, got %q", reply.Contents)
+	}
+	if reply.Reference == nil || reply.Reference.MessageID != question.ID {
+		t.Errorf("Expected the reply to reference the question, got %+v", reply.Reference)
+	}
+
+	requests := bot.openai.Requests()
+	if len(requests) != 1 || requests[0].Messages[len(requests[0].Messages)-1].Content != "hi there" {
+		t.Errorf("Expected the question without the mention to be sent, got %+v", requests)
+	}
+}
+
+func TestMessagesWithoutMentionOrInOtherChannelsAreIgnored(t *testing.T) {
+	bot := newTestBot(t)
+
+	for _, message := range []*discordgo.Message{
+		bot.discord.NewMessage(guildID, allowedChannelID, alice, "no mention"),
+		bot.discord.NewMessage(guildID, otherChannelID, alice, bot.discord.Mention()+" wrong channel"),
+		bot.discord.NewMessage(guildID, allowedChannelID, alice, bot.discord.Mention()+" answered"),
+	} {
+		if err := bot.discord.MessageCreate(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bot.discord.WaitForMessage(t, allowedChannelID, func(m discordtest.Message) bool {
+		return m.Content() == openaitest.DefaultReply
+	})
+
+	if messages := bot.discord.Messages(allowedChannelID); len(messages) != 1 {
+		t.Errorf("Expected a single reply, got %+v", messages)
+	}
+	if messages := bot.discord.Messages(otherChannelID); len(messages) != 0 {
+		t.Errorf("Expected no reply outside of allowed channels, got %+v", messages)
+	}
+}
+
+func TestFlaggedQuestionIsRefused(t *testing.T) {
+	bot := newTestBot(t)
+	bot.openai.FlaggedWords = []string{"kill"}
+
+	question := bot.discord.NewMessage(guildID, allowedChannelID, alice, bot.discord.Mention()+" I want to kill this noobs")
+	if err := bot.discord.MessageCreate(question); err != nil {
+		t.Fatal(err)
+	}
+
+	bot.discord.WaitForMessage(t, allowedChannelID, func(m discordtest.Message) bool {
+		return m.Content() == handler.UnableToAssistMsg
+	})
+
+	if requests := bot.openai.Requests(); len(requests) != 0 {
+		t.Errorf("Expected no completion for a flagged question, got %d", len(requests))
+	}
+}
+
+func TestAskCommandDefersAndEditsResponse(t *testing.T) {
+	bot := newTestBot(t)
+
+	interaction := bot.discord.NewCommand(guildID, allowedChannelID, alice, handler.AskCommand, map[string]string{"question": "What is Go?"})
+	if err := bot.discord.InteractionCreate(interaction); err != nil {
+		t.Fatal(err)
+	}
+
+	want := fmt.Sprintf("> What is Go?\n\n%s", openaitest.DefaultReply)
+	response := bot.discord.WaitForInteractionResponse(t, interaction.ID, func(r discordtest.InteractionResponse) bool {
+		return r.Content() == want
+	})
+
+	if response.Type != discordgo.InteractionResponseDeferredChannelMessageWithSource {
+		t.Errorf("Expected a deferred response, got %v", response.Type)
+	}
+}
+
+func TestUsageCommandAppliesRoleLimits(t *testing.T) {
+	discord := discordtest.New(t)
+	dc := connectBot(t, context.Background(), discord, &faqResponder{}, nil)
+	dc.Handler.Limiter = limiter.NewMessageLimiterWithRules(limiter.Rules{
+		Default: limiter.Limit{Strategy: limiter.SlidingWindow, MaxMessages: 1, Window: time.Hour},
+		Guilds: map[string]limiter.GuildRules{
+			guildID: {Roles: map[string]limiter.Limit{memberRole: {MaxMessages: 4}}},
+		},
+	})
+
+	usage := func(roles []string) string {
+		interaction := discord.NewCommand(guildID, allowedChannelID, alice, handler.UsageCommand, nil)
+		interaction.Member.Roles = roles
+		if err := discord.InteractionCreate(interaction); err != nil {
+			t.Fatal(err)
+		}
+		return discord.WaitForInteractionResponse(t, interaction.ID, func(r discordtest.InteractionResponse) bool {
+			return true
+		}).Content()
+	}
+
+	if got := usage(nil); got != "You have asked 0 of 1 questions." {
+		t.Errorf("Expected the default limit without roles, got %q", got)
+	}
+	if got := usage([]string{memberRole}); got != "You have asked 0 of 4 questions." {
+		t.Errorf("Expected the limit of the role, got %q", got)
+	}
+}
+
+func TestAskCommandOutsideAllowedChannel(t *testing.T) {
+	bot := newTestBot(t)
+
+	interaction := bot.discord.NewCommand(guildID, otherChannelID, alice, handler.AskCommand, map[string]string{"question": "Hello?"})
+	if err := bot.discord.InteractionCreate(interaction); err != nil {
+		t.Fatal(err)
+	}
+
+	response := bot.discord.WaitForInteractionResponse(t, interaction.ID, func(r discordtest.InteractionResponse) bool {
+		return true
+	})
+
+	if response.Content() != handler.ChannelNotAllowedMsg || response.Flags&discordgo.MessageFlagsEphemeral == 0 {
+		t.Errorf("Expected an ephemeral refusal, got %+v", response)
+	}
+}
+
+func TestThreadsAndCategoriesFollowTheirParents(t *testing.T) {
+	const (
+		categoryID = "300000000000000004"
+		filedID    = "300000000000000005"
+		threadID   = "300000000000000006"
+	)
+
+	discord := discordtest.New(t)
+	faq := &faqResponder{answers: map[string]string{"hi": "hello"}}
+	dc := connectBot(t, context.Background(), discord, faq, nil)
+	discord.AddChannel(&discordgo.Channel{ID: categoryID, GuildID: guildID, Name: "help", Type: discordgo.ChannelTypeGuildCategory})
+	discord.AddChannel(&discordgo.Channel{ID: filedID, GuildID: guildID, Name: "questions", ParentID: categoryID, Type: discordgo.ChannelTypeGuildText})
+	discord.AddChannel(&discordgo.Channel{ID: threadID, GuildID: guildID, Name: "a thread", ParentID: allowedChannelID, Type: discordgo.ChannelTypeGuildPublicThread})
+
+	ask := func(channelID string) {
+		if err := discord.MessageCreate(discord.NewMessage(guildID, channelID, alice, discord.Mention()+" hi")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	answered := func(channelID string) {
+		discord.WaitForMessage(t, channelID, func(m discordtest.Message) bool {
+			return m.Content() == "hello"
+		})
+	}
+
+	// Events are handled in order, so the answer in the allowed channel comes
+	// after the other questions were ignored.
+	ask(threadID)
+	ask(filedID)
+	ask(allowedChannelID)
+	answered(allowedChannelID)
+	if len(discord.Messages(threadID)) != 0 || len(discord.Messages(filedID)) != 0 {
+		t.Fatal("Expected no answer in threads and other channels before they are allowed")
+	}
+
+	if err := dc.Handler.Guilds.SetAllowThreads(guildID, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := dc.Handler.Guilds.AllowCategory(guildID, categoryID); err != nil {
+		t.Fatal(err)
+	}
+	ask(threadID)
+	ask(filedID)
+	answered(threadID)
+	answered(filedID)
+}
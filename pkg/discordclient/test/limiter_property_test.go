@@ -0,0 +1,274 @@
+package handler_test
+
+import (
+	"math"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"BrainyBuddyGo/pkg/discordclient/limiter"
+)
+
+const (
+	propertyMaxMessages = 3
+	propertyWindow      = time.Hour
+)
+
+// fakeClock is the time source of a limiter under test, moved by hand.
+type fakeClock struct {
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 3, 1, 22, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// step is a question asked by one of a few users after some time passed.
+type step struct {
+	Advance time.Duration
+	User    string
+}
+
+// scenario is a random sequence of questions, dense enough to hit the limits
+// and spread enough to cross windows and days.
+type scenario []step
+
+func (scenario) Generate(r *rand.Rand, size int) reflect.Value {
+	users := []string{"alice", "bob", "carol"}
+	steps := make(scenario, 1+r.Intn(10*size+1))
+	for i := range steps {
+		var advance time.Duration
+		switch r.Intn(4) {
+		case 0:
+			advance = 0
+		case 1:
+			advance = time.Duration(r.Int63n(int64(time.Minute)))
+		case 2:
+			advance = time.Duration(r.Int63n(int64(propertyWindow)))
+		default:
+			advance = time.Duration(r.Int63n(int64(3 * propertyWindow)))
+		}
+		steps[i] = step{Advance: advance, User: users[r.Intn(len(users))]}
+	}
+	return reflect.ValueOf(steps)
+}
+
+func newClockedLimiter(limit limiter.Limit) (*limiter.MessageLimiter, *fakeClock) {
+	clock := newFakeClock()
+	lim := limiter.NewMessageLimiterWithRules(limiter.Rules{Default: limit})
+	lim.SetClock(clock.Now)
+	return lim, clock
+}
+
+func checkProperty(t *testing.T, property interface{}) {
+	t.Helper()
+	if err := quick.Check(property, &quick.Config{MaxCount: 200}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSlidingWindowMatchesModel(t *testing.T) {
+	checkProperty(t, func(steps scenario) bool {
+		lim, clock := newClockedLimiter(limiter.Limit{Strategy: limiter.SlidingWindow, MaxMessages: propertyMaxMessages, Window: propertyWindow})
+		accepted := make(map[string][]time.Time)
+
+		for _, s := range steps {
+			clock.Advance(s.Advance)
+			now := clock.Now()
+
+			inWindow := 0
+			for _, at := range accepted[s.User] {
+				if now.Sub(at) < propertyWindow {
+					inWindow++
+				}
+			}
+
+			ok, wait := lim.RegisterMessage(s.User, "", nil)
+			if ok != (inWindow < propertyMaxMessages) {
+				t.Logf("At %v %s was allowed=%v with %d questions in the window", now, s.User, ok, inWindow)
+				return false
+			}
+			if ok {
+				accepted[s.User] = append(accepted[s.User], now)
+			} else if wait <= 0 || wait > propertyWindow {
+				t.Logf("Unexpected wait %v", wait)
+				return false
+			}
+
+			if used, _, _ := lim.Usage(s.User, "", nil); used != inWindow && used != inWindow+1 || used > propertyMaxMessages {
+				t.Logf("Usage of %s is %d with %d questions in the window", s.User, used, inWindow)
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func TestTokenBucketNeverExceedsRate(t *testing.T) {
+	checkProperty(t, func(steps scenario) bool {
+		lim, clock := newClockedLimiter(limiter.Limit{Strategy: limiter.TokenBucket, MaxMessages: propertyMaxMessages, Window: propertyWindow})
+		accepted := make(map[string][]time.Time)
+
+		for _, s := range steps {
+			clock.Advance(s.Advance)
+			if ok, _ := lim.RegisterMessage(s.User, "", nil); ok {
+				accepted[s.User] = append(accepted[s.User], clock.Now())
+			}
+		}
+
+		// Any period holds at most a full bucket and what refilled during it
+		for _, times := range accepted {
+			for i := range times {
+				for j := i; j < len(times); j++ {
+					refilled := propertyMaxMessages * float64(times[j].Sub(times[i])) / float64(propertyWindow)
+					if float64(j-i+1) > propertyMaxMessages+math.Floor(refilled+1e-9) {
+						t.Logf("%d questions between %v and %v", j-i+1, times[i], times[j])
+						return false
+					}
+				}
+			}
+		}
+		return true
+	})
+}
+
+func TestDailyQuotaPerDay(t *testing.T) {
+	checkProperty(t, func(steps scenario) bool {
+		lim, clock := newClockedLimiter(limiter.Limit{Strategy: limiter.DailyQuota, MaxMessages: propertyMaxMessages})
+		perDay := make(map[string]int)
+
+		for _, s := range steps {
+			clock.Advance(s.Advance)
+			day := s.User + clock.Now().Format("2006-01-02")
+
+			ok, wait := lim.RegisterMessage(s.User, "", nil)
+			if ok != (perDay[day] < propertyMaxMessages) {
+				return false
+			}
+			if ok {
+				perDay[day]++
+			} else if !clock.Now().Add(wait).Equal(clock.Now().Truncate(24 * time.Hour).Add(24 * time.Hour)) {
+				t.Logf("Expected to wait until midnight, got %v", wait)
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// spamDuringLockout uses up the quota, then keeps asking at random times while
+// refused, and reports whether a question is allowed once the first wait is
+// over.
+func spamDuringLockout(limit limiter.Limit, spam []time.Duration) bool {
+	lim, clock := newClockedLimiter(limit)
+	for i := 0; i < limit.MaxMessages; i++ {
+		lim.RegisterMessage("alice", "", nil)
+	}
+	_, wait := lim.RegisterMessage("alice", "", nil)
+
+	elapsed := time.Duration(0)
+	for _, d := range spam {
+		d = time.Duration(int64(d)&math.MaxInt64) % wait
+		if elapsed+d >= wait {
+			break
+		}
+		clock.Advance(d)
+		elapsed += d
+		lim.RegisterMessage("alice", "", nil)
+	}
+
+	clock.Advance(wait - elapsed)
+	ok, _ := lim.RegisterMessage("alice", "", nil)
+	return ok
+}
+
+func TestRejectedQuestionsDoNotExtendTheWait(t *testing.T) {
+	strategies := []limiter.Strategy{limiter.SlidingWindow, limiter.TokenBucket, limiter.DailyQuota}
+	for _, strategy := range strategies {
+		t.Run(string(strategy), func(t *testing.T) {
+			limit := limiter.Limit{Strategy: strategy, MaxMessages: propertyMaxMessages, Window: propertyWindow}
+			checkProperty(t, func(spam []time.Duration) bool {
+				return spamDuringLockout(limit, spam)
+			})
+		})
+	}
+}
+
+func TestCountRejectedExtendsTheWait(t *testing.T) {
+	for _, strategy := range []limiter.Strategy{limiter.SlidingWindow, limiter.TokenBucket} {
+		t.Run(string(strategy), func(t *testing.T) {
+			limit := limiter.Limit{Strategy: strategy, MaxMessages: propertyMaxMessages, Window: propertyWindow, CountRejected: true}
+			// A full quota of questions right before the end of the wait
+			// restarts it
+			if spamDuringLockout(limit, []time.Duration{propertyWindow - time.Second, 0, 0}) {
+				t.Fatal("Expected the refused question to extend the wait")
+			}
+		})
+	}
+}
+
+func TestCountRejectedKeepsMemoryBounded(t *testing.T) {
+	lim, clock := newClockedLimiter(limiter.Limit{Strategy: limiter.SlidingWindow, MaxMessages: propertyMaxMessages, Window: propertyWindow, CountRejected: true})
+	for i := 0; i < 1000; i++ {
+		clock.Advance(time.Second)
+		lim.RegisterMessage("alice", "", nil)
+	}
+	if used, _, _ := lim.Usage("alice", "", nil); used != propertyMaxMessages {
+		t.Fatalf("Expected only the last %d questions to be kept, got %d", propertyMaxMessages, used)
+	}
+}
+
+func TestPruneForgetsIdleUsers(t *testing.T) {
+	strategies := []limiter.Strategy{limiter.SlidingWindow, limiter.TokenBucket, limiter.DailyQuota}
+	for _, strategy := range strategies {
+		t.Run(string(strategy), func(t *testing.T) {
+			store, err := limiter.NewBoltStore(filepath.Join(t.TempDir(), "limiter.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rules := limiter.Rules{
+				Default: limiter.Limit{Strategy: strategy, MaxMessages: propertyMaxMessages, Window: propertyWindow},
+			}
+			lim, err := limiter.NewPersistentMessageLimiter(rules, store)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer lim.Close()
+			clock := newFakeClock()
+			lim.SetClock(clock.Now)
+
+			for i := 0; i < propertyMaxMessages; i++ {
+				lim.RegisterMessage("alice", "", nil)
+			}
+			clock.Advance(propertyWindow / 2)
+			lim.RegisterMessage("bob", "", nil)
+
+			if pruned := lim.Prune(); pruned != 0 {
+				t.Fatalf("Expected active users to be kept, %d were pruned", pruned)
+			}
+
+			clock.Advance(24 * time.Hour)
+			if pruned := lim.Prune(); pruned != 2 || lim.Users() != 0 {
+				t.Fatalf("Expected both users to be pruned, pruned %d and kept %d", pruned, lim.Users())
+			}
+			states, err := store.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(states) != 0 {
+				t.Fatalf("Expected the pruned users to be deleted from the store, got %v", states)
+			}
+		})
+	}
+}
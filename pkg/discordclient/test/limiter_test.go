@@ -0,0 +1,356 @@
+package handler_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"BrainyBuddyGo/pkg/discordclient/handler"
+	"BrainyBuddyGo/pkg/discordclient/limiter"
+	"BrainyBuddyGo/pkg/redis/redistest"
+)
+
+const (
+	moderatorRole = "500000000000000001"
+	memberRole    = "500000000000000002"
+)
+
+func TestTokenBucketRefills(t *testing.T) {
+	lim := limiter.NewMessageLimiterWithRules(limiter.Rules{
+		Default: limiter.Limit{Strategy: limiter.TokenBucket, MaxMessages: 2, Window: 200 * time.Millisecond},
+	})
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := lim.RegisterMessage("alice", "", nil); !ok {
+			t.Fatalf("Expected question %d of the burst to be allowed", i+1)
+		}
+	}
+
+	ok, wait := lim.RegisterMessage("alice", "", nil)
+	if ok {
+		t.Fatal("Expected the empty bucket to refuse the question")
+	}
+	if wait <= 0 || wait > 100*time.Millisecond {
+		t.Fatalf("Expected a token within 100ms, got %v", wait)
+	}
+
+	time.Sleep(wait + 10*time.Millisecond)
+	if ok, _ := lim.RegisterMessage("alice", "", nil); !ok {
+		t.Fatal("Expected the refilled token to allow a question")
+	}
+}
+
+func TestDailyQuota(t *testing.T) {
+	lim := limiter.NewMessageLimiterWithRules(limiter.Rules{
+		Default: limiter.Limit{Strategy: limiter.DailyQuota, MaxMessages: 2},
+	})
+
+	lim.RegisterMessage("alice", "", nil)
+	lim.RegisterMessage("alice", "", nil)
+	ok, wait := lim.RegisterMessage("alice", "", nil)
+	if ok || wait <= 0 || wait > 24*time.Hour {
+		t.Fatalf("Expected the quota to be used up until midnight, got %v %v", ok, wait)
+	}
+
+	used, limit, _ := lim.Usage("alice", "", nil)
+	if used != 2 || limit != 2 {
+		t.Fatalf("Expected 2 of 2 questions used, got %d of %d", used, limit)
+	}
+}
+
+func TestRoleLimits(t *testing.T) {
+	lim := limiter.NewMessageLimiterWithRules(limiter.Rules{
+		Default: limiter.Limit{Strategy: limiter.SlidingWindow, MaxMessages: 1, Window: time.Hour},
+		Guilds: map[string]limiter.GuildRules{
+			guildID: {
+				Roles: map[string]limiter.Limit{
+					moderatorRole:       {Unlimited: true},
+					handler.BoosterRole: {MaxMessages: 3},
+				},
+			},
+		},
+	})
+
+	allowed := func(userID string, roles []string) int {
+		count := 0
+		for i := 0; i < 5; i++ {
+			if ok, _ := lim.RegisterMessage(userID, guildID, roles); ok {
+				count++
+			}
+		}
+		return count
+	}
+
+	if got := allowed("member", []string{memberRole}); got != 1 {
+		t.Errorf("Expected a member to ask 1 question, asked %d", got)
+	}
+	if got := allowed("booster", []string{memberRole, handler.BoosterRole}); got != 3 {
+		t.Errorf("Expected a booster to ask 3 questions, asked %d", got)
+	}
+	if got := allowed("moderator", []string{handler.BoosterRole, moderatorRole}); got != 5 {
+		t.Errorf("Expected a moderator to be unlimited, asked %d", got)
+	}
+	if _, limit, _ := lim.Usage("moderator", guildID, []string{handler.BoosterRole, moderatorRole}); limit != 0 {
+		t.Errorf("Expected no limit to be reported for a moderator, got %d", limit)
+	}
+	if used, limit, _ := lim.Usage("booster", guildID, []string{memberRole, handler.BoosterRole}); used != 3 || limit != 3 {
+		t.Errorf("Expected a booster to have used 3 of 3 questions, got %d of %d", used, limit)
+	}
+
+	// The roles of another guild do not apply
+	if ok, _ := lim.RegisterMessage("elsewhere", "another guild", []string{moderatorRole}); !ok {
+		t.Fatal("Expected the first question to be allowed")
+	}
+	if ok, _ := lim.RegisterMessage("elsewhere", "another guild", []string{moderatorRole}); ok {
+		t.Error("Expected the default limit outside of the guild")
+	}
+}
+
+func TestUnlimitedUsersAreNotStored(t *testing.T) {
+	store, err := limiter.NewBoltStore(filepath.Join(t.TempDir(), "limiter.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lim, err := limiter.NewPersistentMessageLimiter(limiter.Rules{
+		Default: limiter.Limit{Strategy: limiter.SlidingWindow, MaxMessages: 1, Window: time.Hour},
+		Guilds: map[string]limiter.GuildRules{
+			guildID: {Roles: map[string]limiter.Limit{moderatorRole: {Unlimited: true}}},
+		},
+	}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lim.Close()
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := lim.RegisterMessage("400000000000000001", guildID, []string{moderatorRole}); !ok {
+			t.Fatal("Expected a moderator to be unlimited")
+		}
+	}
+	if users := lim.Users(); users != 0 {
+		t.Errorf("Expected no state kept for an unlimited user, got %d users", users)
+	}
+	states, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 0 {
+		t.Errorf("Expected nothing written for an unlimited user, got %v", states)
+	}
+}
+
+// runRestartSuite checks that every strategy remembers the questions asked
+// before a restart, simulated by a new limiter on a new store.
+func runRestartSuite(t *testing.T, openStore func(t *testing.T) limiter.Store) {
+	strategies := []limiter.Strategy{limiter.SlidingWindow, limiter.TokenBucket, limiter.DailyQuota}
+	for i, strategy := range strategies {
+		t.Run(string(strategy), func(t *testing.T) {
+			rules := limiter.Rules{
+				Default: limiter.Limit{Strategy: strategy, MaxMessages: 2, Window: time.Hour},
+			}
+
+			lim, err := limiter.NewPersistentMessageLimiter(rules, openStore(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			userID := fmt.Sprintf("40000000000000000%d", i)
+			lim.RegisterMessage(userID, "", nil)
+			lim.RegisterMessage(userID, "", nil)
+			if err := lim.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			restarted, err := limiter.NewPersistentMessageLimiter(rules, openStore(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer restarted.Close()
+
+			if used, _, _ := restarted.Usage(userID, "", nil); used != 2 {
+				t.Errorf("Expected 2 questions remembered, got %d", used)
+			}
+			if ok, wait := restarted.RegisterMessage(userID, "", nil); ok || wait <= 0 {
+				t.Errorf("Expected the limit to survive the restart, got %v %v", ok, wait)
+			}
+			if ok, _ := restarted.RegisterMessage(fmt.Sprintf("50000000000000000%d", i), "", nil); !ok {
+				t.Error("Expected other users to be unaffected")
+			}
+		})
+	}
+}
+
+func TestBoltStoreSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limiter.db")
+	runRestartSuite(t, func(t *testing.T) limiter.Store {
+		store, err := limiter.NewBoltStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	})
+}
+
+func TestRedisStoreSurvivesRestart(t *testing.T) {
+	server := redistest.NewServerWithPassword("secret")
+	defer server.Close()
+
+	runRestartSuite(t, func(t *testing.T) limiter.Store {
+		store, err := limiter.NewRedisStore(server.URL())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	})
+}
+
+func TestUsernameKeysAreDeletedOnRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limiter.db")
+	store, err := limiter.NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := limiter.UserState{
+		Limit:    limiter.Limit{Strategy: limiter.SlidingWindow, MaxMessages: 2, Window: time.Hour},
+		Messages: []time.Time{time.Now()},
+	}
+	for _, key := range []string{"alice", "bob.smith", "400000000000000001"} {
+		if err := store.Put(key, state); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lim, err := limiter.NewPersistentMessageLimiter(limiter.Rules{Default: state.Limit}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if users := lim.Users(); users != 1 {
+		t.Errorf("Expected only the user kept by ID to be restored, got %d users", users)
+	}
+	if err := lim.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := limiter.NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	states, err := reopened.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := states["400000000000000001"]; len(states) != 1 || !ok {
+		t.Errorf("Expected the usernames to be deleted from the store, got %v", states)
+	}
+}
+
+func TestRedisStoreRejectsWrongPassword(t *testing.T) {
+	server := redistest.NewServerWithPassword("secret")
+	defer server.Close()
+
+	if _, err := limiter.NewRedisStore("redis://:wrong@" + server.Addr()); err == nil {
+		t.Fatal("Expected the wrong password to be refused")
+	}
+}
+
+// blockingStore is a Store whose writes of the kind given by blocks, "put" or
+// "delete", wait until release is closed.
+type blockingStore struct {
+	blocks  string
+	writing chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func newBlockingStore(blocks string) *blockingStore {
+	return &blockingStore{blocks: blocks, writing: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (s *blockingStore) Load() (map[string]limiter.UserState, error) { return nil, nil }
+func (s *blockingStore) Close() error                                { return nil }
+
+func (s *blockingStore) Put(userID string, state limiter.UserState) error {
+	s.wait("put")
+	return nil
+}
+
+func (s *blockingStore) Delete(userID string) error {
+	s.wait("delete")
+	return nil
+}
+
+func (s *blockingStore) wait(kind string) {
+	if kind != s.blocks {
+		return
+	}
+	s.once.Do(func() { close(s.writing) })
+	<-s.release
+}
+
+func newSlowLimiter(t *testing.T, store *blockingStore) *limiter.MessageLimiter {
+	lim, err := limiter.NewPersistentMessageLimiter(limiter.Rules{Default: limiter.Limit{Strategy: limiter.SlidingWindow, MaxMessages: 2, Window: time.Hour}}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lim.Close() })
+	return lim
+}
+
+func TestSlowStoreDoesNotHoldTheLimiter(t *testing.T) {
+	store := newBlockingStore("put")
+	lim := newSlowLimiter(t, store)
+
+	registered := make(chan struct{})
+	go func() {
+		lim.RegisterMessage("alice", "", nil)
+		close(registered)
+	}()
+	<-store.writing
+
+	read := make(chan int)
+	go func() {
+		used, _, _ := lim.Usage("alice", "", nil)
+		read <- used
+	}()
+	select {
+	case used := <-read:
+		if used != 1 {
+			t.Errorf("Expected the question to be counted before it is written, got %d", used)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected the usage to be read while the store is written")
+	}
+
+	close(store.release)
+	<-registered
+}
+
+func TestSlowStoreDoesNotHoldTheLimiterWhilePruning(t *testing.T) {
+	store := newBlockingStore("delete")
+	lim := newSlowLimiter(t, store)
+	lim.RegisterMessage("alice", "", nil)
+
+	now := time.Now()
+	lim.SetClock(func() time.Time { return now.Add(2 * time.Hour) })
+	pruned := make(chan int)
+	go func() { pruned <- lim.Prune() }()
+	<-store.writing
+
+	read := make(chan int)
+	go func() { read <- lim.Users() }()
+	select {
+	case users := <-read:
+		if users != 0 {
+			t.Errorf("Expected alice to be forgotten before the store is written, got %d users", users)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected the users to be read while the store is written")
+	}
+
+	close(store.release)
+	if n := <-pruned; n != 1 {
+		t.Errorf("Expected 1 user pruned, got %d", n)
+	}
+}
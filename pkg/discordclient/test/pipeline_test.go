@@ -0,0 +1,652 @@
+package handler_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	config "BrainyBuddyGo/Config"
+	discordContext "BrainyBuddyGo/pkg/discordclient/context"
+	"BrainyBuddyGo/pkg/discordclient/discordtest"
+	"BrainyBuddyGo/pkg/discordclient/handler"
+	"BrainyBuddyGo/pkg/discordclient/limiter"
+	"BrainyBuddyGo/pkg/openaiclient/breaker"
+	"BrainyBuddyGo/pkg/openaiclient/budget"
+	aiContext "BrainyBuddyGo/pkg/openaiclient/context"
+	"BrainyBuddyGo/pkg/openaiclient/queue"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sashabaranov/go-openai"
+)
+
+// faqResponder answers from a fixed list of questions instead of a model.
+type faqResponder struct {
+	answers map[string]string
+
+	mu       sync.Mutex
+	asked    []string
+	authors  []aiContext.Author
+	backlogs [][]aiContext.ChannelMessage
+}
+
+func (f *faqResponder) GenerateResponse(ctx context.Context, input string, author aiContext.Author, opts aiContext.GenerationOptions) (string, error) {
+	f.mu.Lock()
+	f.asked = append(f.asked, input)
+	f.authors = append(f.authors, author)
+	f.backlogs = append(f.backlogs, opts.Backlog)
+	f.mu.Unlock()
+
+	if answer, ok := f.answers[strings.ToLower(input)]; ok {
+		return answer, nil
+	}
+	return "", errors.New("no answer")
+}
+
+func (f *faqResponder) GenerateResponseStream(ctx context.Context, input string, author aiContext.Author, opts aiContext.GenerationOptions, onUpdate func(partial string)) (string, error) {
+	return f.GenerateResponse(ctx, input, author, opts)
+}
+
+// stuckResponder never answers before its context is done.
+type stuckResponder struct {
+	started chan struct{}
+}
+
+func (r stuckResponder) GenerateResponse(ctx context.Context, input string, author aiContext.Author, opts aiContext.GenerationOptions) (string, error) {
+	r.started <- struct{}{}
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func (r stuckResponder) GenerateResponseStream(ctx context.Context, input string, author aiContext.Author, opts aiContext.GenerationOptions, onUpdate func(partial string)) (string, error) {
+	return r.GenerateResponse(ctx, input, author, opts)
+}
+
+// unavailableResponder behaves like a responder whose circuit breaker is open.
+type unavailableResponder struct{}
+
+func (unavailableResponder) GenerateResponse(ctx context.Context, input string, author aiContext.Author, opts aiContext.GenerationOptions) (string, error) {
+	return "", breaker.ErrOpen
+}
+
+func (r unavailableResponder) GenerateResponseStream(ctx context.Context, input string, author aiContext.Author, opts aiContext.GenerationOptions, onUpdate func(partial string)) (string, error) {
+	return r.GenerateResponse(ctx, input, author, opts)
+}
+
+func (unavailableResponder) APIStatus() breaker.Status {
+	return breaker.Status{State: breaker.Open, Failures: 5, RetryAt: time.Now().Add(30 * time.Second)}
+}
+
+// queuedResponder answers once it gets one of the workers of its queue.
+type queuedResponder struct {
+	workers *queue.Queue
+}
+
+func (r queuedResponder) GenerateResponse(ctx context.Context, input string, author aiContext.Author, opts aiContext.GenerationOptions) (string, error) {
+	release, err := r.workers.Acquire(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	return "Answer to " + input, nil
+}
+
+func (r queuedResponder) GenerateResponseStream(ctx context.Context, input string, author aiContext.Author, opts aiContext.GenerationOptions, onUpdate func(partial string)) (string, error) {
+	return r.GenerateResponse(ctx, input, author, opts)
+}
+
+// brokeResponder refuses every question because the daily budget is used up.
+type brokeResponder struct{}
+
+func (brokeResponder) GenerateResponse(ctx context.Context, input string, author aiContext.Author, opts aiContext.GenerationOptions) (string, error) {
+	return "", &budget.ExhaustedError{Status: budget.Status{Scope: budget.User, ID: author.ID, ResetAt: time.Now().Add(3 * time.Hour)}}
+}
+
+func (r brokeResponder) GenerateResponseStream(ctx context.Context, input string, author aiContext.Author, opts aiContext.GenerationOptions, onUpdate func(partial string)) (string, error) {
+	return r.GenerateResponse(ctx, input, author, opts)
+}
+
+// exhaustedResponder reports that the daily budget of everyone is used up.
+type exhaustedResponder struct {
+	faqResponder
+}
+
+func (r *exhaustedResponder) CheckBudget(account budget.Account) error {
+	return &budget.ExhaustedError{Status: budget.Status{Scope: budget.User, ID: account.User, ResetAt: time.Now().Add(3 * time.Hour)}}
+}
+
+// wordModerator flags every question containing word.
+type wordModerator struct {
+	word string
+}
+
+func (m wordModerator) ModerationCheck(ctx context.Context, input string, maxRetries int) (bool, error) {
+	return strings.Contains(input, m.word), nil
+}
+
+// queuedModerator lets every question through once it gets one of the workers
+// of its queue.
+type queuedModerator struct {
+	workers *queue.Queue
+}
+
+func (m queuedModerator) ModerationCheck(ctx context.Context, input string, maxRetries int) (bool, error) {
+	release, err := m.workers.Acquire(ctx)
+	if err != nil {
+		return false, err
+	}
+	release()
+	return false, nil
+}
+
+func TestHandlerWithAlternativePipeline(t *testing.T) {
+	discord := discordtest.New(t)
+	faq := &faqResponder{answers: map[string]string{"where are the rules?": "In #rules."}}
+	connectBot(t, context.Background(), discord, faq, wordModerator{word: "spam"})
+
+	for _, content := range []string{"Where are the rules?", "buy spam", "What is the meaning of life?"} {
+		if err := discord.MessageCreate(discord.NewMessage(guildID, allowedChannelID, alice, discord.Mention()+" "+content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	discord.WaitForMessage(t, allowedChannelID, func(m discordtest.Message) bool {
+		return m.Content() == handler.CantAnswerNowMsg
+	})
+
+	var replies []string
+	for _, message := range discord.Messages(allowedChannelID) {
+		replies = append(replies, message.Content())
+	}
+	want := []string{"In #rules.", handler.UnableToAssistMsg, handler.CantAnswerNowMsg}
+	if strings.Join(replies, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected replies %q, got %q", want, replies)
+	}
+
+	faq.mu.Lock()
+	defer faq.mu.Unlock()
+	if len(faq.asked) != 2 {
+		t.Errorf("Expected the flagged question not to reach the responder, got %q", faq.asked)
+	}
+}
+
+func TestQuestionsAreKeyedByUserID(t *testing.T) {
+	discord := discordtest.New(t)
+	faq := &faqResponder{answers: map[string]string{"who am i?": "Ally."}}
+	connectBot(t, context.Background(), discord, faq, nil)
+
+	message := discord.NewMessage(guildID, allowedChannelID, alice, discord.Mention()+" who am I?")
+	message.Member = &discordgo.Member{Nick: "Ally"}
+	if err := discord.MessageCreate(message); err != nil {
+		t.Fatal(err)
+	}
+	discord.WaitForMessage(t, allowedChannelID, func(m discordtest.Message) bool {
+		return m.Content() == "Ally."
+	})
+
+	faq.mu.Lock()
+	defer faq.mu.Unlock()
+	want := aiContext.Author{
+		ID:           alice.ID,
+		DisplayName:  "Ally",
+		Username:     alice.Username,
+		Conversation: aiContext.ConversationKey{GuildID: guildID, ChannelID: allowedChannelID, UserID: alice.ID},
+	}
+	if len(faq.authors) != 1 || faq.authors[0] != want {
+		t.Errorf("Expected the question to be asked by %+v, got %+v", want, faq.authors)
+	}
+}
+
+func TestSharedChannelConversation(t *testing.T) {
+	discord := discordtest.New(t)
+	faq := &faqResponder{answers: map[string]string{"who is right?": "Carol.", "why?": "Because."}}
+	dc := connectBot(t, context.Background(), discord, faq, nil)
+	sharedChannel(dc, 2)
+
+	bob := discordtest.User("400000000000000002", "bob")
+	carol := discordtest.User("400000000000000003", "carol")
+	messages := []*discordgo.Message{
+		discord.NewMessage(guildID, allowedChannelID, bob, "Too old to be kept."),
+		discord.NewMessage(guildID, allowedChannelID, bob, "It's 42."),
+		discord.NewMessage(guildID, allowedChannelID, carol, "No, it's 7."),
+		discord.NewMessage(guildID, otherChannelID, carol, "Not allowed here."),
+		discord.NewMessage(guildID, allowedChannelID, alice, discord.Mention()+" who is right?"),
+		discord.NewMessage(guildID, allowedChannelID, bob, discord.Mention()+" why?"),
+	}
+	for _, message := range messages {
+		if err := discord.MessageCreate(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	discord.WaitForMessage(t, allowedChannelID, func(m discordtest.Message) bool {
+		return m.Content() == "Because."
+	})
+
+	faq.mu.Lock()
+	defer faq.mu.Unlock()
+	shared := aiContext.ConversationKey{GuildID: guildID, ChannelID: allowedChannelID}
+	if len(faq.authors) != 2 || faq.authors[0].Conversation != shared || faq.authors[1].Conversation != shared {
+		t.Fatalf("Expected both questions to continue the conversation of the channel, got %+v", faq.authors)
+	}
+	if faq.authors[1].ID != bob.ID {
+		t.Errorf("Expected the second question to be asked by bob, got %+v", faq.authors[1])
+	}
+
+	want := []aiContext.ChannelMessage{
+		{AuthorName: "bob", AuthorUsername: bob.Username, AuthorID: bob.ID, Content: "It's 42."},
+		{AuthorName: "carol", AuthorUsername: carol.Username, AuthorID: carol.ID, Content: "No, it's 7."},
+	}
+	if !reflect.DeepEqual(faq.backlogs[0], want) {
+		t.Errorf("Expected the latest messages of the channel, got %+v", faq.backlogs[0])
+	}
+	if len(faq.backlogs[1]) != 0 {
+		t.Errorf("Expected the messages to be given only once, got %+v", faq.backlogs[1])
+	}
+}
+
+// sharedChannel lets everyone in the channels share a conversation, keeping
+// up to history messages.
+func sharedChannel(dc *discordContext.DiscordContext, history int) {
+	settings := dc.Handler.Settings()
+	settings.Conversation = config.ConversationPerChannel
+	settings.SharedHistory = history
+	dc.Handler.UpdateSettings(settings)
+}
+
+func TestSharedBacklogLeavesOutBotsAndFlaggedMessages(t *testing.T) {
+	discord := discordtest.New(t)
+	faq := &faqResponder{answers: map[string]string{"who is right?": "Carol."}}
+	dc := connectBot(t, context.Background(), discord, faq, wordModerator{word: "spam"})
+	sharedChannel(dc, 5)
+
+	bob := discordtest.User("400000000000000002", "bob")
+	carol := discordtest.User("400000000000000003", "carol")
+	helper := discordtest.User("400000000000000004", "helper")
+	helper.Bot = true
+	messages := []*discordgo.Message{
+		discord.NewMessage(guildID, allowedChannelID, bob, "It's 42."),
+		discord.NewMessage(guildID, allowedChannelID, helper, "Beep, I am a bot."),
+		discord.NewMessage(guildID, allowedChannelID, carol, "buy spam"),
+		discord.NewMessage(guildID, allowedChannelID, alice, discord.Mention()+" who is right?"),
+	}
+	for _, message := range messages {
+		if err := discord.MessageCreate(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	discord.WaitForMessage(t, allowedChannelID, func(m discordtest.Message) bool {
+		return m.Content() == "Carol."
+	})
+
+	faq.mu.Lock()
+	defer faq.mu.Unlock()
+	want := []aiContext.ChannelMessage{{AuthorName: "bob", AuthorUsername: bob.Username, AuthorID: bob.ID, Content: "It's 42."}}
+	if len(faq.backlogs) != 1 || !reflect.DeepEqual(faq.backlogs[0], want) {
+		t.Errorf("Expected only the message of bob, got %+v", faq.backlogs)
+	}
+}
+
+func TestSharedBacklogIsKeptWhenTheAnswerFails(t *testing.T) {
+	discord := discordtest.New(t)
+	faq := &faqResponder{answers: map[string]string{"why?": "Because."}}
+	dc := connectBot(t, context.Background(), discord, faq, nil)
+	sharedChannel(dc, 5)
+
+	bob := discordtest.User("400000000000000002", "bob")
+	carol := discordtest.User("400000000000000003", "carol")
+	for _, message := range []*discordgo.Message{
+		discord.NewMessage(guildID, allowedChannelID, bob, "It's 42."),
+		discord.NewMessage(guildID, allowedChannelID, alice, discord.Mention()+" who is right?"),
+	} {
+		if err := discord.MessageCreate(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	discord.WaitForMessage(t, allowedChannelID, func(m discordtest.Message) bool {
+		return m.Content() == handler.CantAnswerNowMsg
+	})
+
+	for _, message := range []*discordgo.Message{
+		discord.NewMessage(guildID, allowedChannelID, carol, "No, it's 7."),
+		discord.NewMessage(guildID, allowedChannelID, bob, discord.Mention()+" why?"),
+	} {
+		if err := discord.MessageCreate(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	discord.WaitForMessage(t, allowedChannelID, func(m discordtest.Message) bool {
+		return m.Content() == "Because."
+	})
+
+	faq.mu.Lock()
+	defer faq.mu.Unlock()
+	want := []aiContext.ChannelMessage{
+		{AuthorName: "bob", AuthorUsername: bob.Username, AuthorID: bob.ID, Content: "It's 42."},
+		{AuthorName: "carol", AuthorUsername: carol.Username, AuthorID: carol.ID, Content: "No, it's 7."},
+	}
+	if len(faq.backlogs) != 2 || !reflect.DeepEqual(faq.backlogs[1], want) {
+		t.Errorf("Expected the messages of the failed question to be given again, got %+v", faq.backlogs)
+	}
+}
+
+func TestPerUserConversationKeepsNoBacklog(t *testing.T) {
+	discord := discordtest.New(t)
+	faq := &faqResponder{answers: map[string]string{"who is right?": "Carol."}}
+	connectBot(t, context.Background(), discord, faq, nil)
+
+	bob := discordtest.User("400000000000000002", "bob")
+	if err := discord.MessageCreate(discord.NewMessage(guildID, allowedChannelID, bob, "It's 42.")); err != nil {
+		t.Fatal(err)
+	}
+	if err := discord.MessageCreate(discord.NewMessage(guildID, allowedChannelID, alice, discord.Mention()+" who is right?")); err != nil {
+		t.Fatal(err)
+	}
+	discord.WaitForMessage(t, allowedChannelID, func(m discordtest.Message) bool {
+		return m.Content() == "Carol."
+	})
+
+	faq.mu.Lock()
+	defer faq.mu.Unlock()
+	if len(faq.backlogs) != 1 || len(faq.backlogs[0]) != 0 || faq.authors[0].Conversation.UserID != alice.ID {
+		t.Errorf("Expected a conversation of alice alone, got %+v with %+v", faq.authors, faq.backlogs)
+	}
+}
+
+func TestHistoryCommandWithoutConversations(t *testing.T) {
+	discord := discordtest.New(t)
+	connectBot(t, context.Background(), discord, &faqResponder{}, nil)
+
+	interaction := discord.NewCommand(guildID, allowedChannelID, alice, handler.HistoryCommand, nil)
+	if err := discord.InteractionCreate(interaction); err != nil {
+		t.Fatal(err)
+	}
+
+	response := discord.WaitForInteractionResponse(t, interaction.ID, func(r discordtest.InteractionResponse) bool {
+		return true
+	})
+	if response.Content() != handler.UnableToAssistMsg {
+		t.Errorf("Expected /history to be unavailable, got %+v", response)
+	}
+}
+
+// keeperResponder is a faqResponder remembering the conversations reset.
+type keeperResponder struct {
+	faqResponder
+	resets []aiContext.ConversationKey
+}
+
+func (k *keeperResponder) ConversationHistory(key aiContext.ConversationKey) []openai.ChatCompletionMessage {
+	return nil
+}
+
+func (k *keeperResponder) ResetConversation(key aiContext.ConversationKey) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.resets = append(k.resets, key)
+}
+
+func TestSharedResetNeedsToManageMessages(t *testing.T) {
+	discord := discordtest.New(t)
+	keeper := &keeperResponder{}
+	dc := connectBot(t, context.Background(), discord, keeper, nil)
+	sharedChannel(dc, 5)
+
+	reset := func(permissions int64) string {
+		interaction := discord.NewCommand(guildID, allowedChannelID, alice, handler.ResetCommand, nil)
+		interaction.Member.Permissions = permissions
+		if err := discord.InteractionCreate(interaction); err != nil {
+			t.Fatal(err)
+		}
+		return discord.WaitForInteractionResponse(t, interaction.ID, func(r discordtest.InteractionResponse) bool {
+			return true
+		}).Content()
+	}
+
+	if got := reset(discordgo.PermissionSendMessages); got != handler.SharedResetDenyMsg {
+		t.Errorf("Expected a member to be refused, got %q", got)
+	}
+	if got := reset(discordgo.PermissionManageMessages); got != handler.SharedResetDoneMsg {
+		t.Errorf("Expected a moderator to reset the conversation, got %q", got)
+	}
+	if got := reset(discordgo.PermissionAdministrator); got != handler.SharedResetDoneMsg {
+		t.Errorf("Expected an administrator to reset the conversation, got %q", got)
+	}
+
+	keeper.mu.Lock()
+	defer keeper.mu.Unlock()
+	if len(keeper.resets) != 2 {
+		t.Errorf("Expected only the allowed resets to happen, got %+v", keeper.resets)
+	}
+}
+
+func TestOwnResetNeedsNoPermission(t *testing.T) {
+	discord := discordtest.New(t)
+	keeper := &keeperResponder{}
+	connectBot(t, context.Background(), discord, keeper, nil)
+
+	interaction := discord.NewCommand(guildID, allowedChannelID, alice, handler.ResetCommand, nil)
+	if err := discord.InteractionCreate(interaction); err != nil {
+		t.Fatal(err)
+	}
+	response := discord.WaitForInteractionResponse(t, interaction.ID, func(r discordtest.InteractionResponse) bool {
+		return true
+	})
+	if response.Content() != handler.ResetDoneMsg {
+		t.Errorf("Expected a member to reset their own conversation, got %+v", response)
+	}
+
+	keeper.mu.Lock()
+	defer keeper.mu.Unlock()
+	if len(keeper.resets) != 1 || keeper.resets[0].UserID != alice.ID {
+		t.Errorf("Expected the conversation of alice to be reset, got %+v", keeper.resets)
+	}
+}
+
+func TestQuestionTimesOut(t *testing.T) {
+	discord := discordtest.New(t)
+	dc := connectBot(t, context.Background(), discord, stuckResponder{started: make(chan struct{}, 1)}, nil)
+
+	settings := dc.Handler.Settings()
+	settings.RequestTimeout = 50 * time.Millisecond
+	dc.Handler.UpdateSettings(settings)
+
+	if err := discord.MessageCreate(discord.NewMessage(guildID, allowedChannelID, alice, discord.Mention()+" hello?")); err != nil {
+		t.Fatal(err)
+	}
+
+	discord.WaitForMessage(t, allowedChannelID, func(m discordtest.Message) bool {
+		return m.Content() == handler.TimedOutMsg
+	})
+}
+
+func TestCancellingContextAbortsQuestions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	discord := discordtest.New(t)
+	responder := stuckResponder{started: make(chan struct{}, 1)}
+	connectBot(t, ctx, discord, responder, nil)
+
+	interaction := discord.NewCommand(guildID, allowedChannelID, alice, handler.AskCommand, map[string]string{"question": "Still there?"})
+	if err := discord.InteractionCreate(interaction); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-responder.started:
+	case <-time.After(discordtest.WaitTimeout):
+		t.Fatal("Timed out waiting for the question to be asked")
+	}
+	cancel()
+
+	want := "> Still there?\n\n" + handler.CantAnswerNowMsg
+	discord.WaitForInteractionResponse(t, interaction.ID, func(r discordtest.InteractionResponse) bool {
+		return r.Content() == want
+	})
+}
+
+func TestDrainWaitsForQuestionsInFlight(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	discord := discordtest.New(t)
+	responder := stuckResponder{started: make(chan struct{}, 1)}
+	dc := connectBot(t, ctx, discord, responder, nil)
+
+	if err := discord.MessageCreate(discord.NewMessage(guildID, allowedChannelID, alice, discord.Mention()+" hello?")); err != nil {
+		t.Fatal(err)
+	}
+	<-responder.started
+
+	drain, cancelDrain := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelDrain()
+	if err := dc.Handler.Drain(drain); err != context.DeadlineExceeded {
+		t.Fatalf("Expected the drain to wait for the question in flight, got %v", err)
+	}
+
+	cancel()
+	if err := dc.Handler.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if messages := discord.Messages(allowedChannelID); len(messages) != 1 || messages[0].Content() != handler.CantAnswerNowMsg {
+		t.Errorf("Expected the aborted question to be answered before the drain ends, got %+v", messages)
+	}
+
+	interaction := discord.NewCommand(guildID, allowedChannelID, alice, handler.AskCommand, map[string]string{"question": "Too late?"})
+	if err := discord.InteractionCreate(interaction); err != nil {
+		t.Fatal(err)
+	}
+	response := discord.WaitForInteractionResponse(t, interaction.ID, func(r discordtest.InteractionResponse) bool {
+		return true
+	})
+	if response.Content() != handler.ShuttingDownMsg {
+		t.Errorf("Expected new questions to be refused, got %+v", response)
+	}
+}
+
+func TestUnavailableAPIIsReported(t *testing.T) {
+	discord := discordtest.New(t)
+	connectBot(t, context.Background(), discord, unavailableResponder{}, nil)
+
+	if err := discord.MessageCreate(discord.NewMessage(guildID, allowedChannelID, alice, discord.Mention()+" hello?")); err != nil {
+		t.Fatal(err)
+	}
+	discord.WaitForMessage(t, allowedChannelID, func(m discordtest.Message) bool {
+		return m.Content() == handler.UnavailableMsg
+	})
+
+	status := discord.NewCommand(guildID, allowedChannelID, alice, handler.StatusCommand, nil)
+	if err := discord.InteractionCreate(status); err != nil {
+		t.Fatal(err)
+	}
+	response := discord.WaitForInteractionResponse(t, status.ID, func(r discordtest.InteractionResponse) bool {
+		return true
+	})
+	if !strings.Contains(response.Content(), "unavailable after 5 failed requests") {
+		t.Errorf("Expected /status to report the open circuit, got %q", response.Content())
+	}
+}
+
+func TestQueuePositionAndFullQueue(t *testing.T) {
+	discord := discordtest.New(t)
+	workers := queue.New(1, 1, 1)
+	connectBot(t, context.Background(), discord, queuedResponder{workers: workers}, nil)
+
+	release, err := workers.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := discord.MessageCreate(discord.NewMessage(guildID, allowedChannelID, alice, discord.Mention()+" first?")); err != nil {
+		t.Fatal(err)
+	}
+	discord.WaitForMessage(t, allowedChannelID, func(m discordtest.Message) bool {
+		return m.Content() == fmt.Sprintf(handler.QueuedMsg, 1)
+	})
+
+	release()
+	discord.WaitForMessage(t, allowedChannelID, func(m discordtest.Message) bool {
+		return m.Content() == "Answer to first?"
+	})
+
+	// With the worker busy and the only waiting slot taken, questions are refused
+	release, err = workers.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	waiting, cancelWaiting := context.WithCancel(context.Background())
+	defer cancelWaiting()
+	go workers.Acquire(waiting)
+	for deadline := time.Now().Add(discordtest.WaitTimeout); workers.Stats().Waiting != 1; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the queue to fill up")
+		}
+	}
+
+	if err := discord.MessageCreate(discord.NewMessage(guildID, allowedChannelID, alice, discord.Mention()+" second?")); err != nil {
+		t.Fatal(err)
+	}
+	discord.WaitForMessage(t, allowedChannelID, func(m discordtest.Message) bool {
+		return m.Content() == handler.BusyMsg
+	})
+}
+
+func TestQueuePositionIsShownDuringModeration(t *testing.T) {
+	discord := discordtest.New(t)
+	workers := queue.New(1, 1, 1)
+	connectBot(t, context.Background(), discord, queuedResponder{workers: workers}, queuedModerator{workers: workers})
+
+	release, err := workers.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := discord.MessageCreate(discord.NewMessage(guildID, allowedChannelID, alice, discord.Mention()+" moderated?")); err != nil {
+		t.Fatal(err)
+	}
+	discord.WaitForMessage(t, allowedChannelID, func(m discordtest.Message) bool {
+		return m.Content() == fmt.Sprintf(handler.QueuedMsg, 1)
+	})
+
+	release()
+	discord.WaitForMessage(t, allowedChannelID, func(m discordtest.Message) bool {
+		return m.Content() == "Answer to moderated?"
+	})
+}
+
+func TestExhaustedBudgetIsExplained(t *testing.T) {
+	discord := discordtest.New(t)
+	connectBot(t, context.Background(), discord, brokeResponder{}, nil)
+
+	if err := discord.MessageCreate(discord.NewMessage(guildID, allowedChannelID, alice, discord.Mention()+" one more?")); err != nil {
+		t.Fatal(err)
+	}
+	discord.WaitForMessage(t, allowedChannelID, func(m discordtest.Message) bool {
+		return strings.HasPrefix(m.Content(), "You've used up your daily budget, it resets in 3 hours")
+	})
+}
+
+func TestExhaustedBudgetIsCheckedBeforeTheRateLimit(t *testing.T) {
+	discord := discordtest.New(t)
+	responder := &exhaustedResponder{}
+	dc := connectBot(t, context.Background(), discord, responder, nil)
+
+	if err := discord.MessageCreate(discord.NewMessage(guildID, allowedChannelID, alice, discord.Mention()+" one more?")); err != nil {
+		t.Fatal(err)
+	}
+	discord.WaitForMessage(t, allowedChannelID, func(m discordtest.Message) bool {
+		return strings.HasPrefix(m.Content(), "You've used up your daily budget")
+	})
+
+	if used, _, _ := dc.Handler.Limiter.(*limiter.MessageLimiter).Usage(alice.ID, guildID, nil); used != 0 {
+		t.Errorf("Expected the refused question not to count against the rate limit, got %d", used)
+	}
+	if len(responder.asked) != 0 {
+		t.Errorf("Expected the question not to be answered, got %q", responder.asked)
+	}
+}
@@ -0,0 +1,147 @@
+package handler_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"BrainyBuddyGo/pkg/discordclient/discordtest"
+	"BrainyBuddyGo/pkg/discordclient/handler"
+	aiContext "BrainyBuddyGo/pkg/openaiclient/context"
+)
+
+// scriptedResponder streams partials, pausing before each of them, then
+// answers with final. It records how long the streaming callback took.
+type scriptedResponder struct {
+	partials []string
+	pause    time.Duration
+	final    string
+
+	mu        sync.Mutex
+	slowestCb time.Duration
+}
+
+func (r *scriptedResponder) GenerateResponse(ctx context.Context, input string, author aiContext.Author, opts aiContext.GenerationOptions) (string, error) {
+	return r.final, nil
+}
+
+func (r *scriptedResponder) GenerateResponseStream(ctx context.Context, input string, author aiContext.Author, opts aiContext.GenerationOptions, onUpdate func(partial string)) (string, error) {
+	for _, partial := range r.partials {
+		time.Sleep(r.pause)
+
+		start := time.Now()
+		onUpdate(partial)
+		elapsed := time.Since(start)
+
+		r.mu.Lock()
+		if elapsed > r.slowestCb {
+			r.slowestCb = elapsed
+		}
+		r.mu.Unlock()
+	}
+	return r.final, nil
+}
+
+func (r *scriptedResponder) slowestCallback() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.slowestCb
+}
+
+// streamAnswer asks responder a question with edits throttled to interval and
+// returns the reply once it shows final.
+func streamAnswer(t *testing.T, discord *discordtest.Harness, responder *scriptedResponder, interval time.Duration) discordtest.Message {
+	dc := connectBot(t, context.Background(), discord, responder, nil)
+	dc.Handler.UpdateSettings(handler.Settings{ModerationMaxRetries: 1, StreamEditInterval: interval})
+
+	if err := discord.MessageCreate(discord.NewMessage(guildID, allowedChannelID, alice, discord.Mention()+" tell me")); err != nil {
+		t.Fatal(err)
+	}
+	final := []rune(responder.final)
+	if len(final) > handler.MaxMessageLength {
+		final = append(final[:handler.MaxMessageLength-1], '…')
+	}
+	return discord.WaitForMessage(t, allowedChannelID, func(m discordtest.Message) bool {
+		return m.Content() == string(final)
+	})
+}
+
+func TestStreamedEditsAreThrottled(t *testing.T) {
+	discord := discordtest.New(t)
+	responder := &scriptedResponder{
+		partials: []string{"a", "ab", "abc", "abcd", "abcde"},
+		pause:    time.Millisecond,
+		final:    "abcdef",
+	}
+
+	reply := streamAnswer(t, discord, responder, time.Hour)
+
+	if len(reply.Contents) != 2 || reply.Contents[0] != handler.StreamPlaceholderMsg {
+		t.Errorf("Expected only the placeholder and the final edit, got %q", reply.Contents)
+	}
+}
+
+func TestStreamedEditsShowProgressAndEndWithTheAnswer(t *testing.T) {
+	discord := discordtest.New(t)
+	responder := &scriptedResponder{
+		partials: []string{"Hel", "Hello", "Hello th"},
+		pause:    50 * time.Millisecond,
+		final:    "Hello there",
+	}
+
+	reply := streamAnswer(t, discord, responder, 10*time.Millisecond)
+
+	partial := false
+	for _, content := range reply.Contents[1 : len(reply.Contents)-1] {
+		if !strings.HasSuffix(content, handler.StreamCursor) {
+			t.Errorf("Expected intermediate edits to end with the cursor, got %q", content)
+		}
+		partial = true
+	}
+	if !partial {
+		t.Errorf("Expected intermediate edits, got %q", reply.Contents)
+	}
+}
+
+func TestLongAnswerIsTruncated(t *testing.T) {
+	discord := discordtest.New(t)
+	long := strings.Repeat("é", handler.MaxMessageLength+500)
+	responder := &scriptedResponder{
+		partials: []string{long[:len(long)/2], long},
+		pause:    30 * time.Millisecond,
+		final:    long,
+	}
+
+	reply := streamAnswer(t, discord, responder, 10*time.Millisecond)
+
+	for _, content := range reply.Contents {
+		if length := utf8.RuneCountInString(content); length > handler.MaxMessageLength {
+			t.Errorf("Expected at most %d characters, got %d", handler.MaxMessageLength, length)
+		}
+	}
+	if !strings.HasSuffix(reply.Content(), "…") {
+		t.Error("Expected the truncated answer to end with an ellipsis")
+	}
+}
+
+func TestSlowEditsDoNotHoldUpTheStream(t *testing.T) {
+	discord := discordtest.New(t)
+	discord.SetEditDelay(200 * time.Millisecond)
+	responder := &scriptedResponder{
+		partials: []string{"a", "ab", "abc", "abcd", "abcde", "abcdef"},
+		pause:    20 * time.Millisecond,
+		final:    "abcdefg",
+	}
+
+	reply := streamAnswer(t, discord, responder, 10*time.Millisecond)
+
+	if slowest := responder.slowestCallback(); slowest > 100*time.Millisecond {
+		t.Errorf("Expected updates not to wait for the edits, one took %v", slowest)
+	}
+	if len(reply.Contents) < 3 {
+		t.Errorf("Expected an intermediate edit, got %q", reply.Contents)
+	}
+}
@@ -0,0 +1,181 @@
+// Package breaker stops sending requests to an API that keeps failing. After a
+// number of consecutive failures the circuit opens and requests fail at once,
+// until a probe request sent after a cool-down shows the API has recovered.
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"BrainyBuddyGo/pkg/defaults"
+	"BrainyBuddyGo/pkg/openaiclient/retry"
+)
+
+const (
+	DefaultFailureThreshold = defaults.BreakerFailureThreshold
+	DefaultOpenTimeout      = defaults.BreakerOpenTimeout
+)
+
+var ErrOpen = errors.New("the API is temporarily unavailable")
+
+type State int
+
+const (
+	// Closed lets every request through.
+	Closed State = iota
+	// Open fails every request.
+	Open
+	// HalfOpen lets a single probe request through.
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+}
+
+type Config struct {
+	// FailureThreshold is the number of consecutive failures opening the
+	// circuit, zero disables the breaker.
+	FailureThreshold int
+	// OpenTimeout is the time the circuit stays open before probing the API.
+	OpenTimeout time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		FailureThreshold: DefaultFailureThreshold,
+		OpenTimeout:      DefaultOpenTimeout,
+	}
+}
+
+// Status describes the breaker at one point in time.
+type Status struct {
+	State State
+	// Failures is the number of consecutive failures.
+	Failures int
+	// RetryAt is when an open circuit lets the next probe through.
+	RetryAt time.Time
+}
+
+type Breaker struct {
+	config Config
+	// OnStateChange, when set, is called with the lock held after every
+	// transition, typically to log it.
+	OnStateChange func(from State, to State, status Status)
+
+	state    State
+	failures int
+	openedAt time.Time
+	probing  bool
+	now      func() time.Time
+	mutex    sync.Mutex
+}
+
+func New(config Config) *Breaker {
+	return &Breaker{config: config, now: time.Now}
+}
+
+// SetConfig replaces the thresholds, keeping the current state.
+func (b *Breaker) SetConfig(config Config) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.config = config
+}
+
+// Allow reports whether a request may be sent, failing with ErrOpen when not.
+// Every allowed request must be followed by Record or Release.
+func (b *Breaker) Allow() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.config.FailureThreshold <= 0 {
+		return nil
+	}
+
+	switch b.state {
+	case Open:
+		if b.now().Before(b.openedAt.Add(b.config.OpenTimeout)) {
+			return ErrOpen
+		}
+		b.setState(HalfOpen)
+		b.probing = true
+		return nil
+	case HalfOpen:
+		if b.probing {
+			return ErrOpen
+		}
+		b.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// Record reports the outcome of an allowed request. Only errors showing the
+// API is unavailable count as failures; a rejected request still proves it is
+// up. Cancelled requests are released without an outcome.
+func (b *Breaker) Record(err error) {
+	if errors.Is(err, context.Canceled) {
+		b.Release()
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.probing = false
+	if err == nil || !retry.Retryable(err) {
+		b.failures = 0
+		if b.state != Closed {
+			b.setState(Closed)
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == HalfOpen || (b.state == Closed && b.config.FailureThreshold > 0 && b.failures >= b.config.FailureThreshold) {
+		b.openedAt = b.now()
+		b.setState(Open)
+	}
+}
+
+// Release gives back an allowed request that was not sent.
+func (b *Breaker) Release() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.probing = false
+}
+
+func (b *Breaker) Status() Status {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.status()
+}
+
+func (b *Breaker) status() Status {
+	status := Status{State: b.state, Failures: b.failures}
+	if b.state == Open {
+		status.RetryAt = b.openedAt.Add(b.config.OpenTimeout)
+	}
+	return status
+}
+
+func (b *Breaker) setState(state State) {
+	from := b.state
+	b.state = state
+	if b.OnStateChange != nil {
+		b.OnStateChange(from, state, b.status())
+	}
+}
@@ -0,0 +1,70 @@
+package budget
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var budgetsBucket = []byte("budgets")
+
+// BoltStore is a Store persisted in a BoltDB file.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open budget store: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(budgetsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create budget bucket: %w", err)
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+func (b *BoltStore) Load() (map[string]Period, error) {
+	periods := make(map[string]Period)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(budgetsBucket).ForEach(func(k, data []byte) error {
+			var period Period
+			if err := json.Unmarshal(data, &period); err != nil {
+				return fmt.Errorf("failed to decode budget of %s: %w", k, err)
+			}
+			periods[string(k)] = period
+			return nil
+		})
+	})
+	return periods, err
+}
+
+func (b *BoltStore) Put(name string, period Period) error {
+	data, err := json.Marshal(period)
+	if err != nil {
+		return fmt.Errorf("failed to encode budget of %s: %w", name, err)
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(budgetsBucket).Put([]byte(name), data)
+	})
+}
+
+func (b *BoltStore) Delete(name string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(budgetsBucket).Delete([]byte(name))
+	})
+}
+
+func (b *BoltStore) Close() error {
+	return b.db.Close()
+}
@@ -0,0 +1,465 @@
+// Package budget caps the tokens and dollars spent on the API per user per day,
+// per guild per month and globally per month. The estimated cost of a request
+// is reserved before it is sent and settled with its usage once answered, and
+// requests are refused once a budget is used up until it resets.
+package budget
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"BrainyBuddyGo/pkg/defaults"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const DefaultWarnAt = defaults.BudgetWarnAt
+
+var ErrExhausted = errors.New("budget exhausted")
+
+type Scope int
+
+const (
+	// User budgets reset every day at midnight UTC.
+	User Scope = iota
+	// Guild budgets reset on the first day of every month.
+	Guild
+	// Global budgets cover every request and reset on the first day of every
+	// month, like the bill of the API.
+	Global
+)
+
+func (s Scope) String() string {
+	switch s {
+	case User:
+		return "user"
+	case Guild:
+		return "guild"
+	case Global:
+		return "global"
+	default:
+		return fmt.Sprintf("Scope(%d)", int(s))
+	}
+}
+
+// Spend is what was spent on the API.
+type Spend struct {
+	Tokens  int     `json:"tokens"`
+	Dollars float64 `json:"dollars"`
+}
+
+// Limit caps the tokens, the dollars or both. A zero field is not limited.
+type Limit struct {
+	Tokens  int
+	Dollars float64
+}
+
+func (l Limit) IsZero() bool {
+	return l.Tokens <= 0 && l.Dollars <= 0
+}
+
+// fraction is the part of the limit used by spend, the larger of tokens and
+// dollars.
+func (l Limit) fraction(spend Spend) float64 {
+	fraction := 0.0
+	if l.Tokens > 0 {
+		fraction = float64(spend.Tokens) / float64(l.Tokens)
+	}
+	if l.Dollars > 0 {
+		if dollars := spend.Dollars / l.Dollars; dollars > fraction {
+			fraction = dollars
+		}
+	}
+	return fraction
+}
+
+type Config struct {
+	UserDaily     Limit
+	GuildMonthly  Limit
+	GlobalMonthly Limit
+	// WarnAt lists the fractions of a budget, such as 0.8, at which the
+	// account is warned. Using up a budget always warns.
+	WarnAt []float64
+	// Prices converts tokens to dollars per model.
+	Prices Prices
+}
+
+func DefaultConfig() Config {
+	return Config{
+		WarnAt: []float64{DefaultWarnAt},
+		Prices: DefaultPrices(),
+	}
+}
+
+func (c Config) limit(scope Scope) Limit {
+	switch scope {
+	case User:
+		return c.UserDaily
+	case Guild:
+		return c.GuildMonthly
+	default:
+		return c.GlobalMonthly
+	}
+}
+
+type accountKey struct{}
+
+// Account is who requests are charged to. It travels in the context of the
+// requests, see WithAccount.
+type Account struct {
+	User string
+	// Guild is empty for direct messages, which only count against the user
+	// and global budgets.
+	Guild string
+	// OnWarning, when set, is called when a request crosses a warning
+	// threshold of one of the budgets of the account.
+	OnWarning func(warning Warning)
+}
+
+// WithAccount returns a context whose requests are charged to account.
+func WithAccount(ctx context.Context, account Account) context.Context {
+	return context.WithValue(ctx, accountKey{}, account)
+}
+
+// AccountFrom returns the account of ctx, the zero value when there is none.
+func AccountFrom(ctx context.Context) Account {
+	account, _ := ctx.Value(accountKey{}).(Account)
+	return account
+}
+
+// Status describes a budget of an account.
+type Status struct {
+	Scope Scope
+	// ID is the user or guild the budget belongs to, empty for the global one.
+	ID      string
+	Spent   Spend
+	Limit   Limit
+	ResetAt time.Time
+}
+
+// Used is the part of the budget used, 1 once it is exhausted.
+func (s Status) Used() float64 {
+	return s.Limit.fraction(s.Spent)
+}
+
+// Warning is given when a request crosses Threshold of a budget.
+type Warning struct {
+	Status
+	Threshold float64
+}
+
+// ExhaustedError is returned for requests of an account whose budget is used up.
+type ExhaustedError struct {
+	Status
+}
+
+func (e *ExhaustedError) Error() string {
+	return fmt.Sprintf("%s %s budget used up until %s", ErrExhausted, e.Scope, e.ResetAt.Format(time.RFC3339))
+}
+
+func (e *ExhaustedError) Is(target error) bool {
+	return target == ErrExhausted
+}
+
+type key struct {
+	scope Scope
+	id    string
+}
+
+// String is the key of the budget in a Store.
+func (k key) String() string {
+	if k.scope == Global {
+		return k.scope.String()
+	}
+	return k.scope.String() + ":" + k.id
+}
+
+func parseKey(s string) (key, bool) {
+	name, id, _ := strings.Cut(s, ":")
+	for _, scope := range []Scope{User, Guild, Global} {
+		if scope.String() == name && (id == "") == (scope == Global) {
+			return key{scope: scope, id: id}, true
+		}
+	}
+	return key{}, false
+}
+
+type periodSpend struct {
+	start time.Time
+	spent Spend
+	// reserved is held by the requests in flight, see Reserve.
+	reserved Spend
+}
+
+type Tracker struct {
+	config Config
+	spend  map[key]*periodSpend
+	// store, when set, receives what is spent on every budget that changes.
+	store Store
+	// storeMutex orders the writes to the store, it is taken before mutex is
+	// released so that the store is written outside of mutex in order.
+	storeMutex sync.Mutex
+	now        func() time.Time
+	mutex      sync.Mutex
+}
+
+func New(config Config) *Tracker {
+	return &Tracker{
+		config: config,
+		spend:  make(map[key]*periodSpend),
+		now:    time.Now,
+	}
+}
+
+// NewPersistent restores what was spent in the current periods from store,
+// which then receives every change. The tracker owns the store and closes it
+// in Close. Budgets of periods that are over are deleted from the store.
+func NewPersistent(config Config, store Store) (*Tracker, error) {
+	saved, err := store.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load budgets: %w", err)
+	}
+
+	t := New(config)
+	t.store = store
+	now := t.now()
+	for name, state := range saved {
+		k, ok := parseKey(name)
+		if start, _ := period(k.scope, now); !ok || !state.Start.Equal(start) {
+			if err := store.Delete(name); err != nil {
+				log.Printf("Failed to delete the budget of %s: %v", name, err)
+			}
+			continue
+		}
+		t.spend[k] = &periodSpend{start: state.Start, spent: state.Spent}
+	}
+
+	log.Printf("Restored %d budgets", len(t.spend))
+	return t, nil
+}
+
+// Close closes the store of a persistent tracker.
+func (t *Tracker) Close() error {
+	if t.store == nil {
+		return nil
+	}
+	return t.store.Close()
+}
+
+// SetConfig replaces the limits, keeping what was spent.
+func (t *Tracker) SetConfig(config Config) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.config = config
+}
+
+// Reservation is the estimated cost of a request held against the budgets of
+// an account until it is settled with Settle or given back with Release.
+type Reservation struct {
+	account Account
+	cost    Spend
+	// starts holds the period each budget was reserved in, a reservation is
+	// not taken back from the next period.
+	starts map[key]time.Time
+}
+
+// Check fails with an *ExhaustedError when a budget of the account is used up,
+// counting the reservations of the requests in flight, the most specific one
+// first.
+func (t *Tracker) Check(account Account) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.check(account, t.now())
+}
+
+func (t *Tracker) check(account Account, now time.Time) error {
+	for _, k := range keys(account) {
+		status := t.status(k, now)
+		reserved := t.spend[k].reserved
+		committed := Spend{Tokens: status.Spent.Tokens + reserved.Tokens, Dollars: status.Spent.Dollars + reserved.Dollars}
+		if !status.Limit.IsZero() && status.Limit.fraction(committed) >= 1 {
+			return &ExhaustedError{Status: status}
+		}
+	}
+	return nil
+}
+
+// Reserve checks the budgets of the account like Check and holds the cost of
+// a request to model estimated by usage against them, so that concurrent
+// requests cannot overspend. The reservation must be settled or released.
+func (t *Tracker) Reserve(account Account, model string, usage openai.Usage) (*Reservation, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := t.now()
+	if err := t.check(account, now); err != nil {
+		return nil, err
+	}
+
+	reservation := &Reservation{
+		account: account,
+		cost:    Spend{Tokens: usage.TotalTokens, Dollars: t.config.Prices.Cost(model, usage)},
+		starts:  make(map[key]time.Time),
+	}
+	for _, k := range keys(account) {
+		t.status(k, now)
+		spend := t.spend[k]
+		spend.reserved.Tokens += reservation.cost.Tokens
+		spend.reserved.Dollars += reservation.cost.Dollars
+		reservation.starts[k] = spend.start
+	}
+	return reservation, nil
+}
+
+// Release gives back a reservation whose request spent nothing.
+func (t *Tracker) Release(reservation *Reservation) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.release(reservation)
+}
+
+func (t *Tracker) release(reservation *Reservation) {
+	for k, start := range reservation.starts {
+		spend, ok := t.spend[k]
+		if !ok || !spend.start.Equal(start) {
+			continue
+		}
+		spend.reserved.Tokens -= reservation.cost.Tokens
+		spend.reserved.Dollars -= reservation.cost.Dollars
+		if spend.reserved.Tokens < 0 || spend.reserved.Dollars < 0 {
+			spend.reserved = Spend{}
+		}
+	}
+	reservation.starts = nil
+}
+
+// Settle replaces a reservation with what its request to model actually
+// used, returning the warnings for the thresholds it crossed.
+func (t *Tracker) Settle(reservation *Reservation, model string, usage openai.Usage) []Warning {
+	t.mutex.Lock()
+	t.release(reservation)
+	return t.record(reservation.account, model, usage)
+}
+
+// Record charges a request to model with usage to the account, returning the
+// warnings for the thresholds it crossed.
+func (t *Tracker) Record(account Account, model string, usage openai.Usage) []Warning {
+	t.mutex.Lock()
+	return t.record(account, model, usage)
+}
+
+// record charges usage with mutex held, and releases it before writing the
+// budgets that changed to the store.
+func (t *Tracker) record(account Account, model string, usage openai.Usage) []Warning {
+	now := t.now()
+	cost := Spend{Tokens: usage.TotalTokens, Dollars: t.config.Prices.Cost(model, usage)}
+
+	var warnings []Warning
+	changed := make(map[string]Period)
+	for _, k := range keys(account) {
+		before := t.status(k, now)
+
+		spend := t.spend[k]
+		spend.spent.Tokens += cost.Tokens
+		spend.spent.Dollars += cost.Dollars
+		changed[k.String()] = Period{Start: spend.start, Spent: spend.spent}
+
+		after := t.status(k, now)
+		if threshold, crossed := t.crossed(before.Used(), after.Used()); crossed && !after.Limit.IsZero() {
+			warnings = append(warnings, Warning{Status: after, Threshold: threshold})
+		}
+	}
+
+	if t.store == nil {
+		t.mutex.Unlock()
+		return warnings
+	}
+
+	t.storeMutex.Lock()
+	t.mutex.Unlock()
+	defer t.storeMutex.Unlock()
+	for name, spent := range changed {
+		if err := t.store.Put(name, spent); err != nil {
+			log.Printf("Failed to save the budget of %s: %v", name, err)
+		}
+	}
+	return warnings
+}
+
+// Usage reports the budgets of the account that have a limit.
+func (t *Tracker) Usage(account Account) []Status {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := t.now()
+	var statuses []Status
+	for _, k := range keys(account) {
+		if status := t.status(k, now); !status.Limit.IsZero() {
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses
+}
+
+// status returns the budget of k in the current period, starting a new period
+// when the previous one is over.
+func (t *Tracker) status(k key, now time.Time) Status {
+	start, resetAt := period(k.scope, now)
+
+	spend, ok := t.spend[k]
+	if !ok || !spend.start.Equal(start) {
+		spend = &periodSpend{start: start}
+		t.spend[k] = spend
+	}
+
+	return Status{
+		Scope:   k.scope,
+		ID:      k.id,
+		Spent:   spend.spent,
+		Limit:   t.config.limit(k.scope),
+		ResetAt: resetAt,
+	}
+}
+
+// crossed returns the highest threshold between before, excluded, and after.
+func (t *Tracker) crossed(before float64, after float64) (float64, bool) {
+	thresholds := append([]float64{1}, t.config.WarnAt...)
+	sort.Sort(sort.Reverse(sort.Float64Slice(thresholds)))
+
+	for _, threshold := range thresholds {
+		if before < threshold && after >= threshold {
+			return threshold, true
+		}
+	}
+	return 0, false
+}
+
+func keys(account Account) []key {
+	var keys []key
+	if account.User != "" {
+		keys = append(keys, key{scope: User, id: account.User})
+	}
+	if account.Guild != "" {
+		keys = append(keys, key{scope: Guild, id: account.Guild})
+	}
+	return append(keys, key{scope: Global})
+}
+
+// period returns the start of the current period of scope and when it ends.
+func period(scope Scope, now time.Time) (time.Time, time.Time) {
+	year, month, day := now.UTC().Date()
+	if scope == User {
+		start := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 0, 1)
+	}
+	start := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 1, 0)
+}
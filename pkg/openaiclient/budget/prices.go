@@ -0,0 +1,71 @@
+package budget
+
+import (
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Price is the cost in dollars of 1000 tokens of a model.
+type Price struct {
+	Prompt     float64
+	Completion float64
+}
+
+// Prices are keyed by model name. A model uses the price of the longest name
+// it starts with, so that "gpt-4" also prices "gpt-4-0613".
+type Prices map[string]Price
+
+// DefaultPrices lists the prices of the OpenAI and Anthropic chat models.
+func DefaultPrices() Prices {
+	return Prices{
+		openai.GPT3Dot5Turbo:    {Prompt: 0.0015, Completion: 0.002},
+		openai.GPT3Dot5Turbo16K: {Prompt: 0.003, Completion: 0.004},
+		openai.GPT4:             {Prompt: 0.03, Completion: 0.06},
+		openai.GPT432K:          {Prompt: 0.06, Completion: 0.12},
+		"claude-3-haiku":        {Prompt: 0.00025, Completion: 0.00125},
+		"claude-3-sonnet":       {Prompt: 0.003, Completion: 0.015},
+		"claude-3-5-sonnet":     {Prompt: 0.003, Completion: 0.015},
+		"claude-3-opus":         {Prompt: 0.015, Completion: 0.075},
+	}
+}
+
+// Cost returns the dollars spent by a request to model. Models without a price
+// are charged the highest prompt and completion prices of the list, so that
+// they cannot spend a dollar budget for free; give local models a zero price.
+func (p Prices) Cost(model string, usage openai.Usage) float64 {
+	price, ok := p.find(model)
+	if !ok {
+		price = p.highest()
+	}
+	return (float64(usage.PromptTokens)*price.Prompt + float64(usage.CompletionTokens)*price.Completion) / 1000
+}
+
+// Has reports whether model has a price of its own.
+func (p Prices) Has(model string) bool {
+	_, ok := p.find(model)
+	return ok
+}
+
+func (p Prices) highest() Price {
+	var highest Price
+	for _, price := range p {
+		if price.Prompt > highest.Prompt {
+			highest.Prompt = price.Prompt
+		}
+		if price.Completion > highest.Completion {
+			highest.Completion = price.Completion
+		}
+	}
+	return highest
+}
+
+func (p Prices) find(model string) (Price, bool) {
+	best, found := "", false
+	for name := range p {
+		if strings.HasPrefix(model, name) && len(name) >= len(best) {
+			best, found = name, true
+		}
+	}
+	return p[best], found
+}
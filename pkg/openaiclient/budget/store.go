@@ -0,0 +1,21 @@
+package budget
+
+import "time"
+
+// Period is what was spent on a budget since Start, as kept by a Store.
+type Period struct {
+	Start time.Time `json:"start"`
+	Spent Spend     `json:"spent"`
+}
+
+// Store persists what was spent so that the budgets survive restarts. The
+// tracker keeps every budget in memory: the store is read once at startup and
+// then receives every change. Budgets are keyed by scope and ID, such as
+// "user:1234" or "global".
+type Store interface {
+	// Load returns every budget.
+	Load() (map[string]Period, error)
+	Put(name string, period Period) error
+	Delete(name string) error
+	Close() error
+}
@@ -0,0 +1,58 @@
+package context
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ConversationKey identifies a cached conversation. Users are identified by
+// their ID, since usernames change and can be taken by someone else.
+type ConversationKey struct {
+	GuildID string
+	// ChannelID is the channel or thread the conversation takes place in.
+	ChannelID string
+	UserID    string
+}
+
+// String is the key of the conversation in the ConversationStore.
+func (k ConversationKey) String() string {
+	return strings.Join([]string{k.GuildID, k.ChannelID, k.UserID}, "/")
+}
+
+// Author is who asks a question.
+type Author struct {
+	// ID identifies the author, their questions are charged to it.
+	ID string
+	// DisplayName is how the model knows the author, it may change between
+	// questions. The ID is used when it is empty.
+	DisplayName string
+	// Username names the author in the messages of the conversation when the
+	// display name has no character a message name may contain.
+	Username string
+	// Conversation is the conversation the question continues, a conversation
+	// of the author alone when empty.
+	Conversation ConversationKey
+}
+
+func (a Author) displayName() string {
+	if a.DisplayName == "" {
+		return a.ID
+	}
+	return a.DisplayName
+}
+
+// messageName is the name of the messages of the author in the conversation.
+func (a Author) messageName() string {
+	return messageName(a.DisplayName, a.Username, a.ID)
+}
+
+func (a Author) conversation() ConversationKey {
+	if a.Conversation == (ConversationKey{}) {
+		return ConversationKey{UserID: a.ID}
+	}
+	return a.Conversation
+}
+
+func (a Author) String() string {
+	return fmt.Sprintf("%s (%s)", a.displayName(), a.ID)
+}
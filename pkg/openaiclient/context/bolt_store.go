@@ -0,0 +1,133 @@
+package context
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var conversationsBucket = []byte("conversations")
+
+// BoltStore is a ConversationStore persisted in a BoltDB file, so cached
+// conversations survive restarts.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open conversation store: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(conversationsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create conversation bucket: %w", err)
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+func (b *BoltStore) Get(key string) (UserCacheItem, bool, error) {
+	var value UserCacheItem
+	found := false
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(conversationsBucket).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, &value)
+	})
+	if err != nil {
+		return UserCacheItem{}, false, fmt.Errorf("failed to read conversation %s: %w", key, err)
+	}
+
+	return value, found, nil
+}
+
+func (b *BoltStore) Put(key string, value UserCacheItem) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to encode conversation %s: %w", key, err)
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(conversationsBucket).Put([]byte(key), data)
+	})
+}
+
+func (b *BoltStore) Delete(key string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(conversationsBucket).Delete([]byte(key))
+	})
+}
+
+func (b *BoltStore) List() ([]string, error) {
+	var keys []string
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(conversationsBucket).ForEach(func(k, _ []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	})
+	return keys, err
+}
+
+func (b *BoltStore) Evict(cutoff time.Time) (int, error) {
+	deleted := 0
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(conversationsBucket)
+		updates := make(map[string][]byte)
+
+		err := bucket.ForEach(func(k, data []byte) error {
+			var value UserCacheItem
+			if err := json.Unmarshal(data, &value); err != nil {
+				return fmt.Errorf("failed to decode conversation %s: %w", k, err)
+			}
+
+			value, empty := evictConversations(value, cutoff)
+			if empty {
+				updates[string(k)] = nil
+				return nil
+			}
+
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			updates[string(k)] = encoded
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// Buckets must not be modified while iterating over them.
+		for key, data := range updates {
+			if data == nil {
+				if err := bucket.Delete([]byte(key)); err != nil {
+					return err
+				}
+				deleted++
+				continue
+			}
+			if err := bucket.Put([]byte(key), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return deleted, err
+}
+
+func (b *BoltStore) Close() error {
+	return b.db.Close()
+}
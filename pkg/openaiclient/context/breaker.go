@@ -0,0 +1,41 @@
+package context
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"BrainyBuddyGo/pkg/openaiclient/breaker"
+	"BrainyBuddyGo/pkg/openaiclient/retry"
+)
+
+// APIStatus reports whether the API is considered available.
+func (client *OpenAiContext) APIStatus() breaker.Status {
+	return client.breaker.Status()
+}
+
+// begin prepares a request to the API: it fails at once while the circuit
+// breaker is open and otherwise takes a worker, returning the function giving
+// it back. The outcome must be given to the breaker with Record.
+func (client *OpenAiContext) begin(ctx context.Context) (func(), error) {
+	if err := client.breaker.Allow(); err != nil {
+		return nil, retry.Permanent(err)
+	}
+
+	release, err := client.acquire(ctx)
+	if err != nil {
+		client.breaker.Release()
+		return nil, retry.Permanent(err)
+	}
+
+	return release, nil
+}
+
+func logBreakerChange(from breaker.State, to breaker.State, status breaker.Status) {
+	switch to {
+	case breaker.Open:
+		log.Printf("API circuit breaker opened after %d consecutive failures, probing again at %s", status.Failures, status.RetryAt.Format(time.RFC3339))
+	default:
+		log.Printf("API circuit breaker %s (was %s)", to, from)
+	}
+}
@@ -0,0 +1,81 @@
+package context
+
+import (
+	"context"
+	"log"
+
+	"BrainyBuddyGo/pkg/openaiclient/budget"
+	"BrainyBuddyGo/pkg/openaiclient/tokenizer"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// checkBudget fails with a *budget.ExhaustedError when a budget of the account
+// of ctx is used up. Requests without an account are charged to the author.
+func (client *OpenAiContext) checkBudget(ctx context.Context, author Author) (context.Context, error) {
+	account := budget.AccountFrom(ctx)
+	if account.User == "" {
+		account.User = author.ID
+		ctx = budget.WithAccount(ctx, account)
+	}
+
+	return ctx, client.budget.Check(account)
+}
+
+// CheckBudget fails with a *budget.ExhaustedError when a budget of the account
+// is used up, so that questions can be refused before anything else.
+func (client *OpenAiContext) CheckBudget(account budget.Account) error {
+	return client.budget.Check(account)
+}
+
+// BudgetUsage reports the budgets of the account that have a limit.
+func (client *OpenAiContext) BudgetUsage(account budget.Account) []budget.Status {
+	return client.budget.Usage(account)
+}
+
+// reserveBudget holds the cost of req against the budgets of the account of
+// ctx until it is settled, estimated from the whole prompt and the maximum
+// number of tokens of the completion.
+func (client *OpenAiContext) reserveBudget(ctx context.Context, req openai.ChatCompletionRequest) (*budget.Reservation, error) {
+	estimate := openai.Usage{
+		PromptTokens:     tokenizer.CountMessages(client.tokenizer, req.Messages),
+		CompletionTokens: req.MaxTokens,
+	}
+	estimate.TotalTokens = estimate.PromptTokens + estimate.CompletionTokens
+	return client.budget.Reserve(budget.AccountFrom(ctx), req.Model, estimate)
+}
+
+// settleUsage replaces the reservation of a request with its usage.
+func (client *OpenAiContext) settleUsage(ctx context.Context, reservation *budget.Reservation, model string, usage openai.Usage) {
+	client.warnBudget(ctx, client.budget.Settle(reservation, model, usage))
+}
+
+// recordUsage charges a request to the account of ctx and passes the warnings
+// on to it.
+func (client *OpenAiContext) recordUsage(ctx context.Context, model string, usage openai.Usage) {
+	client.warnBudget(ctx, client.budget.Record(budget.AccountFrom(ctx), model, usage))
+}
+
+// warnBudget logs the warnings and passes them on to the account of ctx.
+func (client *OpenAiContext) warnBudget(ctx context.Context, warnings []budget.Warning) {
+	account := budget.AccountFrom(ctx)
+	for _, warning := range warnings {
+		name := warning.Scope.String()
+		if warning.ID != "" {
+			name += " " + warning.ID
+		}
+		log.Printf("Budget of %s is %.0f%% used: %d tokens, $%.2f", name, warning.Used()*100, warning.Spent.Tokens, warning.Spent.Dollars)
+		if account.OnWarning != nil {
+			account.OnWarning(warning)
+		}
+	}
+}
+
+func (client *OpenAiContext) estimateUsage(messages []openai.ChatCompletionMessage, completion string) openai.Usage {
+	usage := openai.Usage{
+		PromptTokens:     tokenizer.CountMessages(client.tokenizer, messages),
+		CompletionTokens: client.tokenizer.Count(completion),
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return usage
+}
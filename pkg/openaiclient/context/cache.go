@@ -1,6 +1,7 @@
 package context
 
 import (
+	"log"
 	"time"
 
 	"github.com/sashabaranov/go-openai"
@@ -8,52 +9,53 @@ import (
 
 type CacheItem struct {
 	Conversation []openai.ChatCompletionMessage
-	Timestamp    time.Time
-	IsFinished   bool
+	// Summary condenses the turns removed from Conversation by summarization.
+	Summary    string
+	Timestamp  time.Time
+	IsFinished bool
 }
 
 type UserCacheItem struct {
 	Conversations []CacheItem
 }
 
-func (client *OpenAiContext) AddItemToCache(key interface{}, value UserCacheItem) {
-	client.generationCache.Store(key, value)
+func (client *OpenAiContext) AddItemToCache(key string, value UserCacheItem) {
+	if err := client.store.Put(key, value); err != nil {
+		log.Printf("Failed to store conversation of %s: %v", key, err)
+	}
 }
 
-func (client *OpenAiContext) DeleteItemFromCache(key interface{}) {
-	client.generationCache.Delete(key)
+func (client *OpenAiContext) DeleteItemFromCache(key string) {
+	if err := client.store.Delete(key); err != nil {
+		log.Printf("Failed to delete conversation of %s: %v", key, err)
+	}
 }
 
-func (client *OpenAiContext) CacheContains(key interface{}) (value interface{}, ok bool) {
-	value, ok = client.generationCache.Load(key)
+func (client *OpenAiContext) CacheContains(key string) (UserCacheItem, bool) {
+	value, ok, err := client.store.Get(key)
+	if err != nil {
+		log.Printf("Failed to load conversation of %s: %v", key, err)
+		return UserCacheItem{}, false
+	}
 	return value, ok
 }
 
-func (client *OpenAiContext) RunCacheEviction() {
-	ticker := time.NewTicker(client.Config.CacheLifeTime)
+// runCacheEviction periodically removes expired conversations until Close is
+// called. It is started by NewOpenAiContextWithConfig.
+func (client *OpenAiContext) runCacheEviction() {
+	defer close(client.evictionDone)
+
+	ticker := time.NewTicker(client.currentConfig().CacheLifeTime)
+	defer ticker.Stop()
 
 	for {
-		<-ticker.C
-		cachedItemsCopy := make(map[interface{}]UserCacheItem)
-
-		client.generationCache.Range(func(k, v interface{}) bool {
-			userCacheItem, _ := v.(UserCacheItem)
-			cachedItemsCopy[k] = userCacheItem
-			return true
-		})
-
-		for key, userCacheItem := range cachedItemsCopy {
-			for i, v := range userCacheItem.Conversations {
-				if time.Since(v.Timestamp) > client.Config.CacheLifeTime {
-					if len(userCacheItem.Conversations) == 1 {
-						client.DeleteItemFromCache(key)
-					} else {
-						userCacheItem.Conversations = append(userCacheItem.Conversations[:i], userCacheItem.Conversations[i+1:]...)
-						client.AddItemToCache(key, userCacheItem)
-					}
-					break
-				}
+		select {
+		case <-ticker.C:
+			if _, err := client.store.Evict(time.Now().Add(-client.currentConfig().CacheLifeTime)); err != nil {
+				log.Printf("Failed to evict cached conversations: %v", err)
 			}
+		case <-client.done:
+			return
 		}
 	}
 }
@@ -1,20 +1,39 @@
 package context
 
-import "time"
+import (
+	"time"
+
+	"BrainyBuddyGo/pkg/defaults"
+	"BrainyBuddyGo/pkg/openaiclient/breaker"
+	"BrainyBuddyGo/pkg/openaiclient/budget"
+	"BrainyBuddyGo/pkg/openaiclient/queue"
+
+	"github.com/sashabaranov/go-openai"
+)
 
 const (
 	DefaultMaxTokens      = 200
 	DefaultN              = 1
 	DefaultTemperature    = 0.8
-	maxRetries            = 3
-	cacheLifeTime         = 24 * time.Hour
-	ConversationCacheSize = 2
-	DefaultPromptFile     = "pkg/openaiclient/context/config/prompt.json"
-	GenerateResponse      = "Generating AI response for question: '%s', asked by user: '%s'"
+	DefaultWorkers        = defaults.Workers
+	DefaultMaxRetries     = defaults.MaxRetries
+	DefaultCacheLifeTime  = defaults.CacheLifeTime
+	ConversationCacheSize = defaults.ConversationCacheSize
+	DefaultPromptFile     = defaults.PromptFile
+	SummaryThreshold      = defaults.SummaryThreshold
+	SummaryMaxTokens      = defaults.SummaryMaxTokens
+	SummaryKeepMessages   = defaults.SummaryKeepMessages
+	DefaultModel          = openai.GPT3Dot5Turbo
+	MaxMessageNameLength  = 64
+	NormalProfile         = "normal"
+	TeamAdvisorProfile    = "team-advisor"
+	GenerateResponse      = "Generating AI response for question: '%s', asked by %s"
 )
 
 type OpenAiContextConfig struct {
-	APIKey                string
+	APIKey string
+	// BaseURL of the OpenAI API, the official endpoint when empty.
+	BaseURL               string
 	Workers               int
 	CacheLifeTime         time.Duration
 	ConversationCacheSize int
@@ -22,31 +41,48 @@ type OpenAiContextConfig struct {
 	DefaultN              int
 	DefaultTemperature    float64
 	MaxRetries            int
-	DefaultPromptFile     string
-}
-
-type TeamAdvisorConfig struct {
-	Welcome               []string `json:"welcome"`
-	AppInterface          []string `json:"app_interface"`
-	AppSettings           []string `json:"app_settings"`
-	BanningPhase          []string `json:"banning_phase"`
-	PickingPhase          []string `json:"picking_phase"`
-	ExampleQuestion       []string `json:"example_question"`
-	QuickSettingsFeatures []string `json:"quick_settings_features"`
-	AppSettingsDetails    []string `json:"app_settings_details"`
-	BanningPhaseDetails   []string `json:"banning_phase_details"`
-	PickingPhaseDetails   []string `json:"picking_phase_details"`
-	TimeoutHandling       []string `json:"timeout_handling"`
-}
-
-type TeamAdvisorData struct {
-	TeamAdvisorConfig `json:"team-advisor"`
-}
-
-type NormalPromptConfig struct {
-	Welcome []string `json:"welcome"`
+	// PromptDir holds one file per prompt profile. When it is empty the profiles
+	// are read from the legacy PromptFile.
+	PromptDir  string
+	PromptFile string
+	// Profile is the prompt profile of channels that do not pick one. With the
+	// legacy file it defaults to the normal or team-advisor profile.
+	Profile string
+	Model   string
+	Summary SummaryConfig
+	// Breaker stops sending requests for a while when the API keeps failing.
+	Breaker breaker.Config
+	// MaxWaiting is the number of requests waiting for a worker above which new
+	// ones are refused, MaxPerUser the number of workers a single user can hold.
+	// Zero disables the limit.
+	MaxWaiting int
+	MaxPerUser int
+	// Budget caps the tokens and dollars spent per user, guild and globally.
+	Budget budget.Config
 }
 
-type NormalPromptData struct {
-	NormalPromptConfig `json:"normal"`
+// DefaultConfig returns the configuration used when none is provided. The
+// prompt file path is relative and must be resolved by the caller.
+func DefaultConfig(apiKey string, workers int) *OpenAiContextConfig {
+	return &OpenAiContextConfig{
+		APIKey:                apiKey,
+		Workers:               workers,
+		CacheLifeTime:         DefaultCacheLifeTime,
+		ConversationCacheSize: ConversationCacheSize,
+		DefaultMaxTokens:      DefaultMaxTokens,
+		DefaultN:              DefaultN,
+		DefaultTemperature:    DefaultTemperature,
+		MaxRetries:            DefaultMaxRetries,
+		PromptFile:            DefaultPromptFile,
+		Model:                 DefaultModel,
+		Summary: SummaryConfig{
+			Threshold:    SummaryThreshold,
+			MaxTokens:    SummaryMaxTokens,
+			KeepMessages: SummaryKeepMessages,
+		},
+		Breaker:    breaker.DefaultConfig(),
+		MaxWaiting: queue.DefaultMaxWaiting,
+		MaxPerUser: queue.DefaultMaxPerUser,
+		Budget:     budget.DefaultConfig(),
+	}
 }
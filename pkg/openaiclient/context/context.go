@@ -2,54 +2,182 @@ package context
 
 import (
 	"fmt"
+	"log"
+	"path/filepath"
 	"sync"
 
-	"github.com/sashabaranov/go-openai"
+	"BrainyBuddyGo/pkg/openaiclient/breaker"
+	"BrainyBuddyGo/pkg/openaiclient/budget"
+	"BrainyBuddyGo/pkg/openaiclient/provider"
+	"BrainyBuddyGo/pkg/openaiclient/queue"
+	"BrainyBuddyGo/pkg/openaiclient/tokenizer"
+	"BrainyBuddyGo/pkg/prompt"
 )
 
 type OpenAiContext struct {
-	Client          *openai.Client
-	Config          *OpenAiContextConfig
-	sem             chan struct{}
-	generationCache sync.Map
+	Provider provider.ChatProvider
+	// Config is replaced as a whole by Reload and never modified in place.
+	Config      *OpenAiContextConfig
+	queue       *queue.Queue
+	store       ConversationStore
+	tokenizer   tokenizer.Counter
+	summarizer  *Summarizer
+	prompts     *prompt.Library
+	breaker     *breaker.Breaker
+	budget      *budget.Tracker
+	budgetStore budget.Store
+	configMutex sync.RWMutex
+	// conversations serializes the questions continuing a conversation.
+	conversations conversationLocks
+
+	// done is closed by Close to stop the cache eviction and refuse new
+	// requests, evictionDone once the eviction has stopped.
+	done         chan struct{}
+	evictionDone chan struct{}
+	closeOnce    sync.Once
+}
+
+// Option customizes an OpenAiContext created by NewOpenAiContext.
+type Option func(*OpenAiContext)
+
+// WithStore replaces the default in-memory conversation store.
+func WithStore(store ConversationStore) Option {
+	return func(client *OpenAiContext) {
+		client.store = store
+	}
+}
+
+// WithTokenizer replaces the default token estimator, typically with a
+// tokenizer.Encoding loaded from a tiktoken rank file.
+func WithTokenizer(counter tokenizer.Counter) Option {
+	return func(client *OpenAiContext) {
+		client.tokenizer = counter
+	}
+}
+
+// WithSummaryConfig configures when and how old turns are summarized. A zero
+// threshold disables summarization.
+func WithSummaryConfig(summary SummaryConfig) Option {
+	return func(client *OpenAiContext) {
+		client.Config.Summary = summary
+	}
 }
 
-func NewOpenAiContext(apiKey string, workers int, basepath string, production bool) (*OpenAiContext, error) {
-	if apiKey == "" {
-		return nil, ErrEmptyAPIKey
+// WithBudgetStore keeps what is spent on the budgets in store, which is loaded
+// when the context is created and closed with it.
+func WithBudgetStore(store budget.Store) Option {
+	return func(client *OpenAiContext) {
+		client.budgetStore = store
 	}
+}
 
-	client := openai.NewClient(apiKey)
+// WithProvider replaces the default OpenAI provider created from the API key.
+func WithProvider(chatProvider provider.ChatProvider) Option {
+	return func(client *OpenAiContext) {
+		client.Provider = chatProvider
+	}
+}
 
-	prompt, err := getPrompt(basepath, production)
+// WithBaseURL points the default OpenAI provider to another server, such as an
+// openaitest.Server. It has no effect together with WithProvider.
+func WithBaseURL(baseURL string) Option {
+	return func(client *OpenAiContext) {
+		client.Config.BaseURL = baseURL
+	}
+}
+
+// WithGenerationDefaults replaces the global model, temperature and max tokens
+// with the options that are set.
+func WithGenerationDefaults(defaults GenerationOptions) Option {
+	return func(client *OpenAiContext) {
+		if defaults.Model != "" {
+			client.Config.Model = defaults.Model
+		}
+		if defaults.Temperature != nil {
+			client.Config.DefaultTemperature = float64(*defaults.Temperature)
+		}
+		if defaults.MaxTokens != 0 {
+			client.Config.DefaultMaxTokens = defaults.MaxTokens
+		}
+	}
+}
+
+func NewOpenAiContext(apiKey string, workers int, basepath string, production bool, opts ...Option) (*OpenAiContext, error) {
+	config := DefaultConfig(apiKey, workers)
+	config.PromptFile = filepath.Join(basepath, DefaultPromptFile)
+
+	return NewOpenAiContextWithConfig(config, production, opts...)
+}
+
+// NewOpenAiContextWithConfig creates a context from a complete configuration,
+// usually DefaultConfig with the values of the configuration file applied.
+func NewOpenAiContextWithConfig(config *OpenAiContextConfig, production bool, opts ...Option) (*OpenAiContext, error) {
+	prompts, err := loadPrompts(config, production)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get prompt: %w", err)
 	}
 
-	config := &OpenAiContextConfig{
-		APIKey:                apiKey,
-		Workers:               workers,
-		CacheLifeTime:         cacheLifeTime,
-		ConversationCacheSize: ConversationCacheSize,
-		DefaultMaxTokens:      DefaultMaxTokens,
-		DefaultN:              DefaultN,
-		DefaultTemperature:    DefaultTemperature,
-		MaxRetries:            maxRetries,
-		DefaultPromptFile:     prompt,
+	ctx := &OpenAiContext{
+		Config:    config,
+		prompts:   prompts,
+		queue:     queue.New(getWorkerCount(config.Workers), config.MaxWaiting, config.MaxPerUser),
+		store:     NewMemoryStore(),
+		tokenizer: tokenizer.Estimator{},
+		breaker:   breaker.New(config.Breaker),
+
+		done:         make(chan struct{}),
+		evictionDone: make(chan struct{}),
 	}
 
-	ctx := &OpenAiContext{
-		Client:          client,
-		Config:          config,
-		sem:             make(chan struct{}, getWorkerCount(workers)),
-		generationCache: sync.Map{},
+	for _, opt := range opts {
+		opt(ctx)
 	}
 
-	go ctx.RunCacheEviction()
+	ctx.budget = budget.New(config.Budget)
+	if ctx.budgetStore != nil {
+		if ctx.budget, err = budget.NewPersistent(config.Budget, ctx.budgetStore); err != nil {
+			return nil, err
+		}
+	}
+
+	if ctx.Provider == nil {
+		if config.APIKey == "" {
+			return nil, ErrEmptyAPIKey
+		}
+		if config.BaseURL != "" {
+			ctx.Provider = provider.NewOpenAIWithBaseURL(config.APIKey, config.BaseURL)
+		} else {
+			ctx.Provider = provider.NewOpenAI(config.APIKey)
+		}
+	}
+
+	if ctx.Config.Summary.Model == "" {
+		ctx.Config.Summary.Model = ctx.Config.Model
+	}
+
+	ctx.breaker.OnStateChange = logBreakerChange
+
+	ctx.summarizer = ctx.newSummarizer(ctx.Config)
+
+	go ctx.runCacheEviction()
 
 	return ctx, nil
 }
 
+// Close stops the cache eviction and closes the conversation store, which
+// writes it to disk. Requests still waiting for a worker fail with ErrClosed,
+// so Close is best called once the requests in flight are answered.
 func (client *OpenAiContext) Close() {
-	close(client.sem)
+	client.closeOnce.Do(func() {
+		close(client.done)
+		client.queue.Close()
+		<-client.evictionDone
+
+		if err := client.store.Close(); err != nil {
+			log.Printf("Failed to close conversation store: %v", err)
+		}
+		if err := client.budget.Close(); err != nil {
+			log.Printf("Failed to close budget store: %v", err)
+		}
+	})
 }
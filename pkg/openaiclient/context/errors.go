@@ -10,6 +10,7 @@ var (
 	ErrNonEnglishInput    = errors.New("input is not in English")
 	ErrFailedModeration   = errors.New("failed to moderate text")
 	ErrNoModResults       = errors.New("no choices were returned in the moderation response")
-	ErrMaxRetries         = errors.New("failed to moderate text after maximum retries")
 	ErrEmptyInput         = errors.New("input is empty")
+	ErrPromptTooLong      = errors.New("prompt does not fit in the model context window")
+	ErrClosed             = errors.New("OpenAI context is closed")
 )
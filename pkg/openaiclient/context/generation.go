@@ -0,0 +1,51 @@
+package context
+
+import (
+	"math"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// GenerationOptions overrides the configured generation parameters for a single
+// request. Empty fields keep the values of OpenAiContextConfig.
+type GenerationOptions struct {
+	Model       string
+	Temperature *float32
+	MaxTokens   int
+	// Profile is the prompt profile of new conversations and Channel the name
+	// substituted for {{channel}} in it.
+	Profile string
+	Channel string
+	// Backlog holds what was said in a shared conversation since the last
+	// question, it is added to the conversation before the question.
+	Backlog []ChannelMessage
+}
+
+// ChannelMessage is a message posted in a shared conversation without being
+// addressed to the bot.
+type ChannelMessage struct {
+	AuthorName string
+	// AuthorUsername and AuthorID name the message when AuthorName has no
+	// character a message name may contain.
+	AuthorUsername string
+	AuthorID       string
+	Content        string
+}
+
+func (opts GenerationOptions) apply(req *openai.ChatCompletionRequest) {
+	if opts.Model != "" {
+		req.Model = opts.Model
+	}
+	if opts.Temperature != nil {
+		req.Temperature = *opts.Temperature
+	}
+	if opts.MaxTokens != 0 {
+		req.MaxTokens = opts.MaxTokens
+	}
+
+	// go-openai omits a zero temperature, which makes the API fall back to its
+	// default of 1, so send the smallest positive value instead.
+	if req.Temperature == 0 {
+		req.Temperature = math.SmallestNonzeroFloat32
+	}
+}
@@ -0,0 +1,25 @@
+package context
+
+import "github.com/sashabaranov/go-openai"
+
+// ConversationHistory returns the messages exchanged in the latest conversation
+// with the key, without the system prompt.
+func (client *OpenAiContext) ConversationHistory(key ConversationKey) []openai.ChatCompletionMessage {
+	userCacheItem, ok := client.CacheContains(key.String())
+	if !ok || len(userCacheItem.Conversations) == 0 {
+		return nil
+	}
+
+	var history []openai.ChatCompletionMessage
+	for _, message := range userCacheItem.Conversations[len(userCacheItem.Conversations)-1].Conversation {
+		if message.Role == openai.ChatMessageRoleUser || len(history) > 0 {
+			history = append(history, message)
+		}
+	}
+	return history
+}
+
+// ResetConversation forgets every cached conversation with the key.
+func (client *OpenAiContext) ResetConversation(key ConversationKey) {
+	client.DeleteItemFromCache(key.String())
+}
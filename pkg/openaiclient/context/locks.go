@@ -0,0 +1,59 @@
+package context
+
+import (
+	"context"
+	"sync"
+)
+
+// conversationLocks serializes the questions continuing the same conversation,
+// so that each question is sent with the answers to the previous ones and no
+// answer overwrites another in the store. The zero value is ready to use.
+type conversationLocks struct {
+	locks map[string]*conversationLock
+	mutex sync.Mutex
+}
+
+type conversationLock struct {
+	// held has room for one token, taken by the question holding the lock.
+	held chan struct{}
+	// users counts the questions holding or waiting for the lock, which is
+	// forgotten when there are none left.
+	users int
+}
+
+// lock waits until no other question continues the conversation of key, or ctx
+// is done, and returns the function releasing it.
+func (l *conversationLocks) lock(ctx context.Context, key string) (func(), error) {
+	l.mutex.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*conversationLock)
+	}
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &conversationLock{held: make(chan struct{}, 1)}
+		l.locks[key] = lock
+	}
+	lock.users++
+	l.mutex.Unlock()
+
+	select {
+	case lock.held <- struct{}{}:
+		return func() {
+			<-lock.held
+			l.leave(key, lock)
+		}, nil
+	case <-ctx.Done():
+		l.leave(key, lock)
+		return nil, ctx.Err()
+	}
+}
+
+func (l *conversationLocks) leave(key string, lock *conversationLock) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	lock.users--
+	if lock.users == 0 {
+		delete(l.locks, key)
+	}
+}
@@ -5,12 +5,13 @@ import (
 	"fmt"
 	"strings"
 
-	"github.com/cenkalti/backoff/v4"
 	"github.com/sashabaranov/go-openai"
 )
 
-func (client *OpenAiContext) ModerationCheck(input string, maxRetries int) (bool, error) {
-	if client.Client == nil {
+// ModerationCheck reports whether input is flagged. Unlike the retries of the
+// other requests, maxRetries counts every attempt including the first one.
+func (client *OpenAiContext) ModerationCheck(ctx context.Context, input string, maxRetries int) (bool, error) {
+	if client.Provider == nil {
 		return false, fmt.Errorf(ErrUninitOpenAI.Error())
 	}
 
@@ -22,8 +23,6 @@ func (client *OpenAiContext) ModerationCheck(input string, maxRetries int) (bool
 		return false, err
 	}
 
-	ctx := context.Background()
-
 	req := client.createModerationRequest(input)
 
 	result, err := client.performModeration(ctx, req, maxRetries)
@@ -43,31 +42,25 @@ func (client *OpenAiContext) createModerationRequest(input string) openai.Modera
 }
 
 func (client *OpenAiContext) performModeration(ctx context.Context, req openai.ModerationRequest, maxRetries int) (bool, error) {
-	bo := backoff.NewExponentialBackOff()
-	retryCount := 0
-
-	for {
-		if retryCount >= maxRetries {
-			return false, fmt.Errorf(ErrMaxRetries.Error())
-		}
-
-		client.sem <- struct{}{}
-		resp, err := client.Client.Moderations(ctx, req)
-		<-client.sem
-
+	var resp openai.ModerationResponse
+	err := retryPolicy("Moderation", maxRetries-1).Do(ctx, func(ctx context.Context) error {
+		release, err := client.begin(ctx)
 		if err != nil {
-			nextInterval := bo.NextBackOff()
-			if nextInterval != backoff.Stop {
-				retryCount++
-				continue
-			}
-			return false, fmt.Errorf("%s %v", ErrFailedModeration, err)
+			return err
 		}
+		defer release()
 
-		if len(resp.Results) == 0 {
-			return false, fmt.Errorf(ErrNoModResults.Error())
-		}
+		resp, err = client.Provider.Moderate(ctx, req)
+		client.breaker.Record(err)
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("%s %w", ErrFailedModeration, err)
+	}
 
-		return resp.Results[0].Flagged, nil
+	if len(resp.Results) == 0 {
+		return false, fmt.Errorf(ErrNoModResults.Error())
 	}
+
+	return resp.Results[0].Flagged, nil
 }
@@ -4,128 +4,333 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"strings"
 	"time"
 
+	"BrainyBuddyGo/pkg/openaiclient/provider"
+	"BrainyBuddyGo/pkg/openaiclient/tokenizer"
+	"BrainyBuddyGo/pkg/prompt"
+
 	"github.com/sashabaranov/go-openai"
 )
 
-func (client *OpenAiContext) GenerateResponse(input string, authorUsername string) (string, error) {
-	log.Printf(GenerateResponse, input, authorUsername)
-	if client.Client == nil {
-		return "", fmt.Errorf(ErrUninitOpenAI.Error())
+// generation holds everything needed to send a question and store the answer.
+type generation struct {
+	cacheKey          string
+	userCacheItem     UserCacheItem
+	item              CacheItem
+	isNewConversation bool
+	request           openai.ChatCompletionRequest
+}
+
+func (client *OpenAiContext) GenerateResponse(ctx context.Context, input string, author Author, opts GenerationOptions) (string, error) {
+	ctx, err := client.checkBudget(ctx, author)
+	if err != nil {
+		return "", err
+	}
+
+	unlock, err := client.conversations.lock(ctx, author.conversation().String())
+	if err != nil {
+		return "", err
 	}
+	defer unlock()
 
-	if authorUsername == "" {
-		return "", errors.New("author username cannot be empty")
+	gen, err := client.prepareGeneration(ctx, input, author, opts)
+	if err != nil {
+		return "", err
 	}
 
-	if strings.Contains(authorUsername, " ") {
-		return "", errors.New("author username cannot contain spaces")
+	response, isFinished, err := client.performChatCompletion(ctx, gen.request)
+	if err != nil {
+		return "", err
 	}
 
-	if strings.TrimSpace(input) == "" {
-		return "", fmt.Errorf(ErrEmptyInput.Error())
+	client.storeConversation(gen, response, isFinished)
+
+	return response, nil
+}
+
+// GenerateResponseStream behaves like GenerateResponse but streams the completion,
+// calling onUpdate with the accumulated text every time a new chunk arrives.
+func (client *OpenAiContext) GenerateResponseStream(ctx context.Context, input string, author Author, opts GenerationOptions, onUpdate func(partial string)) (string, error) {
+	ctx, err := client.checkBudget(ctx, author)
+	if err != nil {
+		return "", err
 	}
 
-	cacheKey := authorUsername
-	cacheValue, ok := client.CacheContains(cacheKey)
-	userCacheItem, _ := cacheValue.(UserCacheItem)
+	unlock, err := client.conversations.lock(ctx, author.conversation().String())
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
 
-	systemMessage := fmt.Sprintf("[PROMPT]%s[/PROMPT] Conversation with: %s[CONVERSATION]", client.Config.DefaultPromptFile, authorUsername)
-	var conversation []openai.ChatCompletionMessage
-	isNewConversation := false
+	gen, err := client.prepareGeneration(ctx, input, author, opts)
+	if err != nil {
+		return "", err
+	}
 
-	if !ok || len(userCacheItem.Conversations) == 0 || userCacheItem.Conversations[len(userCacheItem.Conversations)-1].IsFinished {
-		conversation = append(conversation, openai.ChatCompletionMessage{
-			Role:    openai.ChatMessageRoleAssistant,
-			Content: systemMessage,
-		})
-		isNewConversation = true
-	} else {
-		conversation = userCacheItem.Conversations[len(userCacheItem.Conversations)-1].Conversation
+	response, isFinished, err := client.performChatCompletionStream(ctx, gen.request, onUpdate)
+	if err != nil {
+		return "", err
+	}
+
+	client.storeConversation(gen, response, isFinished)
+
+	return response, nil
+}
+
+func (client *OpenAiContext) prepareGeneration(ctx context.Context, input string, author Author, opts GenerationOptions) (*generation, error) {
+	log.Printf(GenerateResponse, input, author)
+	if err := client.validateGenerateInput(input, author); err != nil {
+		return nil, err
+	}
+
+	gen := &generation{cacheKey: author.conversation().String()}
+	gen.userCacheItem, gen.item, gen.isNewConversation = client.loadConversation(gen.cacheKey, author, opts)
+
+	if summarizer := client.currentSummarizer(); summarizer != nil {
+		item, err := summarizer.Compact(ctx, gen.item)
+		if err != nil {
+			log.Printf("Failed to summarize conversation %s: %v", gen.cacheKey, err)
+		} else {
+			gen.item = item
+		}
 	}
 
-	conversation = append(conversation, openai.ChatCompletionMessage{
+	messages := withSummary(gen.item.Conversation, gen.item.Summary)
+	for _, message := range opts.Backlog {
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleUser,
+			Content: message.Content,
+			Name:    messageName(message.AuthorName, message.AuthorUsername, message.AuthorID),
+		})
+	}
+	messages = append(messages, openai.ChatCompletionMessage{
 		Role:    openai.ChatMessageRoleUser,
 		Content: input,
+		Name:    author.messageName(),
 	})
 
-	ctx := context.Background()
+	req, err := client.createChatCompletionRequest(messages, opts)
+	if err != nil {
+		return nil, err
+	}
+	gen.request = req
+	gen.item.Conversation = withoutSummary(req.Messages, gen.item.Summary)
 
-	req := client.createChatCompletionRequest(conversation)
+	return gen, nil
+}
 
-	response, isFinished, err := client.performChatCompletion(ctx, req)
-	if err != nil {
-		return "", err
+func (client *OpenAiContext) validateGenerateInput(input string, author Author) error {
+	if client.Provider == nil {
+		return fmt.Errorf(ErrUninitOpenAI.Error())
+	}
+
+	if author.ID == "" {
+		return errors.New("author ID cannot be empty")
+	}
+
+	if strings.TrimSpace(input) == "" {
+		return fmt.Errorf(ErrEmptyInput.Error())
+	}
+
+	return nil
+}
+
+// loadConversation returns the cached conversations of the user together with
+// the conversation to continue, which is a new one when the last is finished.
+// New conversations start with the prompt of the profile in opts as the system
+// message; the user is identified by the name of their messages instead.
+func (client *OpenAiContext) loadConversation(cacheKey string, author Author, opts GenerationOptions) (UserCacheItem, CacheItem, bool) {
+	userCacheItem, ok := client.CacheContains(cacheKey)
+
+	if ok && len(userCacheItem.Conversations) > 0 && !userCacheItem.Conversations[len(userCacheItem.Conversations)-1].IsFinished {
+		item := userCacheItem.Conversations[len(userCacheItem.Conversations)-1]
+		item.Conversation = append([]openai.ChatCompletionMessage(nil), item.Conversation...)
+		return userCacheItem, item, false
+	}
+
+	profile, ok := client.currentPrompts().Profile(opts.Profile)
+	if !ok {
+		log.Printf("Unknown prompt profile %s, using %s", opts.Profile, profile.Name)
+	}
+
+	systemMessage := profile.Render(prompt.Variables{
+		Username: author.displayName(),
+		Channel:  opts.Channel,
+		Date:     time.Now(),
+	})
+
+	item := CacheItem{
+		Conversation: []openai.ChatCompletionMessage{
+			{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: systemMessage,
+			},
+		},
+		Timestamp: time.Now(),
 	}
 
-	conversation = append(conversation, openai.ChatCompletionMessage{
+	return userCacheItem, item, true
+}
+
+func (client *OpenAiContext) storeConversation(gen *generation, response string, isFinished bool) {
+	userCacheItem := gen.userCacheItem
+	item := gen.item
+
+	item.Conversation = append(item.Conversation, openai.ChatCompletionMessage{
 		Role:    openai.ChatMessageRoleAssistant,
 		Content: response,
 	})
+	item.IsFinished = isFinished
 
-	if isNewConversation {
-		userCacheItem.Conversations = append(userCacheItem.Conversations, CacheItem{
-			Conversation: conversation,
-			Timestamp:    time.Now(),
-			IsFinished:   isFinished,
-		})
-		if len(userCacheItem.Conversations) > ConversationCacheSize {
+	if gen.isNewConversation {
+		userCacheItem.Conversations = append(userCacheItem.Conversations, item)
+		if len(userCacheItem.Conversations) > client.currentConfig().ConversationCacheSize {
 			userCacheItem.Conversations = userCacheItem.Conversations[1:]
 		}
 	} else {
-		userCacheItem.Conversations[len(userCacheItem.Conversations)-1].Conversation = conversation
-		userCacheItem.Conversations[len(userCacheItem.Conversations)-1].IsFinished = isFinished
+		userCacheItem.Conversations[len(userCacheItem.Conversations)-1] = item
 	}
 
-	client.AddItemToCache(cacheKey, userCacheItem)
-
-	return response, nil
+	client.AddItemToCache(gen.cacheKey, userCacheItem)
 }
 
-func (client *OpenAiContext) createChatCompletionRequest(conversation []openai.ChatCompletionMessage) openai.ChatCompletionRequest {
-	return openai.ChatCompletionRequest{
-		Model:       openai.GPT3Dot5Turbo,
-		Messages:    conversation,
-		MaxTokens:   DefaultMaxTokens,
-		N:           DefaultN,
-		Temperature: DefaultTemperature,
+func (client *OpenAiContext) createChatCompletionRequest(conversation []openai.ChatCompletionMessage, opts GenerationOptions) (openai.ChatCompletionRequest, error) {
+	config := client.currentConfig()
+	req := openai.ChatCompletionRequest{
+		Model:       config.Model,
+		MaxTokens:   config.DefaultMaxTokens,
+		N:           config.DefaultN,
+		Temperature: float32(config.DefaultTemperature),
+	}
+	opts.apply(&req)
+
+	window := tokenizer.UsableWindow(client.tokenizer, tokenizer.ContextWindow(req.Model))
+	messages, err := FitConversation(client.tokenizer, conversation, req.MaxTokens, window)
+	if err != nil {
+		return openai.ChatCompletionRequest{}, err
 	}
+	req.Messages = messages
+
+	return req, nil
 }
 
 func (client *OpenAiContext) performChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (string, bool, error) {
+	reservation, err := client.reserveBudget(ctx, req)
+	if err != nil {
+		return "", false, err
+	}
+
+	var response openai.ChatCompletionResponse
+	err = retryPolicy("Chat completion", client.currentConfig().MaxRetries).Do(ctx, func(ctx context.Context) error {
+		release, err := client.begin(ctx)
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		response, err = client.Provider.Complete(ctx, req)
+		client.breaker.Record(err)
+		return err
+	})
+	if err != nil {
+		client.budget.Release(reservation)
+		return "", false, fmt.Errorf("%s %w", ErrFailedChatComplete, err)
+	}
+	client.settleUsage(ctx, reservation, req.Model, response.Usage)
+
+	if len(response.Choices) == 0 {
+		return "", false, fmt.Errorf(ErrNoChoicesResponse.Error())
+	}
+
+	responseText := response.Choices[0].Message.Content
+	finishReason := response.Choices[0].FinishReason
+
+	return responseText, finishReason != openai.FinishReasonStop, nil
+}
+
+func (client *OpenAiContext) performChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest, onUpdate func(partial string)) (string, bool, error) {
+	reservation, err := client.reserveBudget(ctx, req)
+	if err != nil {
+		return "", false, err
+	}
+
+	var stream provider.ChatStream
+	var release func()
+	err = retryPolicy("Chat completion stream", client.currentConfig().MaxRetries).Do(ctx, func(ctx context.Context) error {
+		var err error
+		release, err = client.begin(ctx)
+		if err != nil {
+			return err
+		}
+
+		stream, err = client.Provider.Stream(ctx, req)
+		if err != nil {
+			client.breaker.Record(err)
+			release()
+			return err
+		}
+
+		// The worker is held until the whole stream is read, and the call
+		// is recorded in the breaker once the stream ends
+		return nil
+	})
+	if err != nil {
+		client.budget.Release(reservation)
+		return "", false, fmt.Errorf("%s %w", ErrFailedChatComplete, err)
+	}
+	defer release()
+	defer stream.Close()
+
+	var streamErr error
+	defer func() { client.breaker.Record(streamErr) }()
+
 	var allResponses strings.Builder
+	// Streams do not report their usage, so it is estimated from what was
+	// sent and received.
+	defer func() {
+		client.settleUsage(ctx, reservation, req.Model, client.estimateUsage(req.Messages, allResponses.String()))
+	}()
+
+	var finishReason openai.FinishReason
+	received := false
 
 	for {
-		respInterface, err := retryWithBackoff(func() (interface{}, error) {
-			client.sem <- struct{}{}        // Acquire semaphore
-			defer func() { <-client.sem }() // Ensure semaphore release
-			return client.Client.CreateChatCompletion(ctx, req)
-		}, maxRetries)
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
 		if err != nil {
-			return "", false, fmt.Errorf("%s %v", ErrFailedChatComplete, err)
+			streamErr = err
+			return "", false, fmt.Errorf("%s %w", ErrFailedChatComplete, err)
 		}
 
-		response, ok := respInterface.(openai.ChatCompletionResponse)
-		if !ok {
-			return "", false, errors.New("failed to cast to openai.ChatCompletionResponse")
+		if len(chunk.Choices) == 0 {
+			continue
 		}
+		received = true
 
-		if len(response.Choices) == 0 {
-			return "", false, fmt.Errorf(ErrNoChoicesResponse.Error())
+		if chunk.Choices[0].FinishReason != "" {
+			finishReason = chunk.Choices[0].FinishReason
 		}
 
-		responseText := response.Choices[0].Message.Content
-		finishReason := response.Choices[0].FinishReason
-
-		allResponses.WriteString(responseText)
+		delta := chunk.Choices[0].Delta.Content
+		if delta == "" {
+			continue
+		}
 
-		if finishReason == openai.FinishReasonStop {
-			return allResponses.String(), false, nil
-		} else {
-			return allResponses.String(), true, nil
+		allResponses.WriteString(delta)
+		if onUpdate != nil {
+			onUpdate(allResponses.String())
 		}
 	}
+
+	if !received {
+		return "", false, fmt.Errorf(ErrNoChoicesResponse.Error())
+	}
+
+	return allResponses.String(), finishReason != openai.FinishReasonStop, nil
 }
@@ -0,0 +1,64 @@
+package context
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"BrainyBuddyGo/pkg/prompt"
+)
+
+// Reload reads the prompt profiles of config and swaps the new configuration
+// in. Workers, the cache lifetime and the API key are fixed when the context is
+// created and keep their current values. When the prompts cannot be read the
+// current configuration stays live.
+func (client *OpenAiContext) Reload(config *OpenAiContextConfig, production bool) error {
+	prompts, err := loadPrompts(config, production)
+	if err != nil {
+		return fmt.Errorf("failed to get prompt: %w", err)
+	}
+
+	current := client.currentConfig()
+
+	next := *config
+	next.APIKey = current.APIKey
+	next.Workers = current.Workers
+	next.CacheLifeTime = current.CacheLifeTime
+	if next.Summary.Model == "" {
+		next.Summary.Model = next.Model
+	}
+
+	log.Printf("Prompt profiles reloaded: %s (default %s)", strings.Join(prompts.Names(), ", "), prompts.Default())
+
+	summarizer := client.newSummarizer(&next)
+
+	client.breaker.SetConfig(next.Breaker)
+	client.queue.SetLimits(next.MaxWaiting, next.MaxPerUser)
+	client.budget.SetConfig(next.Budget)
+
+	client.configMutex.Lock()
+	client.Config = &next
+	client.summarizer = summarizer
+	client.prompts = prompts
+	client.configMutex.Unlock()
+
+	return nil
+}
+
+func (client *OpenAiContext) currentConfig() *OpenAiContextConfig {
+	client.configMutex.RLock()
+	defer client.configMutex.RUnlock()
+	return client.Config
+}
+
+func (client *OpenAiContext) currentPrompts() *prompt.Library {
+	client.configMutex.RLock()
+	defer client.configMutex.RUnlock()
+	return client.prompts
+}
+
+func (client *OpenAiContext) currentSummarizer() *Summarizer {
+	client.configMutex.RLock()
+	defer client.configMutex.RUnlock()
+	return client.summarizer
+}
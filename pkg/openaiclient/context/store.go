@@ -0,0 +1,99 @@
+package context
+
+import (
+	"sync"
+	"time"
+)
+
+// ConversationStore keeps the cached conversations of every user.
+type ConversationStore interface {
+	Get(key string) (UserCacheItem, bool, error)
+	Put(key string, value UserCacheItem) error
+	Delete(key string) error
+	List() ([]string, error)
+	// Evict removes conversations started before the cutoff and returns how
+	// many keys were deleted because they had no conversation left.
+	Evict(cutoff time.Time) (int, error)
+	Close() error
+}
+
+// MemoryStore is a ConversationStore that lives only as long as the process.
+type MemoryStore struct {
+	items map[string]UserCacheItem
+	mutex sync.RWMutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		items: make(map[string]UserCacheItem),
+	}
+}
+
+func (m *MemoryStore) Get(key string) (UserCacheItem, bool, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	value, ok := m.items[key]
+	return value, ok, nil
+}
+
+func (m *MemoryStore) Put(key string, value UserCacheItem) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.items[key] = value
+	return nil
+}
+
+func (m *MemoryStore) Delete(key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.items, key)
+	return nil
+}
+
+func (m *MemoryStore) List() ([]string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	keys := make([]string, 0, len(m.items))
+	for key := range m.items {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (m *MemoryStore) Evict(cutoff time.Time) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	deleted := 0
+	for key, value := range m.items {
+		value, empty := evictConversations(value, cutoff)
+		if empty {
+			delete(m.items, key)
+			deleted++
+			continue
+		}
+		m.items[key] = value
+	}
+	return deleted, nil
+}
+
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// evictConversations drops the conversations started before the cutoff and
+// reports whether none are left.
+func evictConversations(value UserCacheItem, cutoff time.Time) (UserCacheItem, bool) {
+	kept := make([]CacheItem, 0, len(value.Conversations))
+	for _, conversation := range value.Conversations {
+		if conversation.Timestamp.After(cutoff) {
+			kept = append(kept, conversation)
+		}
+	}
+	value.Conversations = kept
+	return value, len(kept) == 0
+}
@@ -0,0 +1,191 @@
+package context
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"BrainyBuddyGo/pkg/openaiclient/breaker"
+	"BrainyBuddyGo/pkg/openaiclient/queue"
+	"BrainyBuddyGo/pkg/openaiclient/retry"
+	"BrainyBuddyGo/pkg/openaiclient/tokenizer"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	summaryInstructions = "Summarize the conversation below between a user and an assistant in a few sentences. " +
+		"Keep facts, names, decisions and open questions. Reply with the summary only."
+	summaryPrefix = "Summary of the earlier conversation: "
+)
+
+// ChatCompleter is the part of a provider.ChatProvider used to create completions.
+type ChatCompleter interface {
+	Complete(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
+}
+
+type SummaryConfig struct {
+	// Threshold is the number of history tokens above which old turns are summarized.
+	Threshold int
+	// Model defaults to the model used for completions when empty.
+	Model     string
+	MaxTokens int
+	// KeepMessages is the number of recent messages left untouched.
+	KeepMessages int
+}
+
+// Summarizer compresses the oldest turns of a conversation into a rolling summary.
+type Summarizer struct {
+	client  ChatCompleter
+	counter tokenizer.Counter
+	config  SummaryConfig
+	workers *queue.Queue
+	// MaxRetries is the number of retries of a failed summary request.
+	MaxRetries int
+	// Breaker, when set, is the circuit breaker the summary requests go
+	// through like the completions.
+	Breaker *breaker.Breaker
+	// OnUsage, when set, is called with the usage of every summary.
+	OnUsage func(ctx context.Context, model string, usage openai.Usage)
+}
+
+// NewSummarizer creates a summarizer; its OpenAI calls wait for a worker of workers.
+func NewSummarizer(client ChatCompleter, counter tokenizer.Counter, config SummaryConfig, workers *queue.Queue) *Summarizer {
+	return &Summarizer{
+		client:     client,
+		counter:    counter,
+		config:     config,
+		workers:    workers,
+		MaxRetries: DefaultMaxRetries,
+	}
+}
+
+// newSummarizer creates the summarizer of config, whose requests are retried,
+// guarded by the circuit breaker and charged like the completions.
+func (client *OpenAiContext) newSummarizer(config *OpenAiContextConfig) *Summarizer {
+	summarizer := NewSummarizer(client.Provider, client.tokenizer, config.Summary, client.queue)
+	summarizer.MaxRetries = config.MaxRetries
+	summarizer.Breaker = client.breaker
+	summarizer.OnUsage = client.recordUsage
+	return summarizer
+}
+
+// Compact summarizes the conversation when its history exceeds the threshold.
+// The first message (the prompt) and the most recent messages are kept verbatim.
+func (s *Summarizer) Compact(ctx context.Context, item CacheItem) (CacheItem, error) {
+	if s.config.Threshold <= 0 || len(item.Conversation) < 2 {
+		return item, nil
+	}
+
+	history := item.Conversation[1:]
+	if tokenizer.CountMessages(s.counter, history) <= s.config.Threshold {
+		return item, nil
+	}
+
+	split := len(history) - s.config.KeepMessages
+	// Keep the retained history starting with a question.
+	for split > 0 && split < len(history) && history[split].Role != openai.ChatMessageRoleUser {
+		split--
+	}
+	if split <= 0 {
+		return item, nil
+	}
+
+	summary, err := s.summarize(ctx, item.Summary, history[:split])
+	if err != nil {
+		return item, err
+	}
+
+	conversation := make([]openai.ChatCompletionMessage, 0, len(history)-split+1)
+	conversation = append(conversation, item.Conversation[0])
+	conversation = append(conversation, history[split:]...)
+
+	item.Conversation = conversation
+	item.Summary = summary
+	return item, nil
+}
+
+func (s *Summarizer) summarize(ctx context.Context, previous string, messages []openai.ChatCompletionMessage) (string, error) {
+	var transcript strings.Builder
+	if previous != "" {
+		fmt.Fprintf(&transcript, "Earlier summary: %s\n\n", previous)
+	}
+	for _, message := range messages {
+		// Shared conversations have several users, told apart by their names
+		if message.Name != "" {
+			fmt.Fprintf(&transcript, "%s (%s): %s\n", message.Role, message.Name, message.Content)
+			continue
+		}
+		fmt.Fprintf(&transcript, "%s: %s\n", message.Role, message.Content)
+	}
+
+	req := openai.ChatCompletionRequest{
+		Model:     s.config.Model,
+		MaxTokens: s.config.MaxTokens,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: summaryInstructions},
+			{Role: openai.ChatMessageRoleUser, Content: transcript.String()},
+		},
+	}
+
+	var response openai.ChatCompletionResponse
+	err := retryPolicy("Summary", s.MaxRetries).Do(ctx, func(ctx context.Context) error {
+		if s.Breaker != nil {
+			if err := s.Breaker.Allow(); err != nil {
+				return retry.Permanent(err)
+			}
+		}
+
+		release, err := s.workers.Acquire(ctx)
+		if err != nil {
+			if s.Breaker != nil {
+				s.Breaker.Release()
+			}
+			return retry.Permanent(err)
+		}
+		defer release()
+
+		response, err = s.client.Complete(ctx, req)
+		if s.Breaker != nil {
+			s.Breaker.Record(err)
+		}
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("%s %w", ErrFailedChatComplete, err)
+	}
+	if s.OnUsage != nil {
+		s.OnUsage(ctx, req.Model, response.Usage)
+	}
+
+	if len(response.Choices) == 0 {
+		return "", fmt.Errorf(ErrNoChoicesResponse.Error())
+	}
+
+	return strings.TrimSpace(response.Choices[0].Message.Content), nil
+}
+
+// withSummary inserts the summary right after the prompt.
+func withSummary(conversation []openai.ChatCompletionMessage, summary string) []openai.ChatCompletionMessage {
+	messages := make([]openai.ChatCompletionMessage, 0, len(conversation)+1)
+	if summary == "" || len(conversation) == 0 {
+		return append(messages, conversation...)
+	}
+
+	messages = append(messages, conversation[0], openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleSystem,
+		Content: summaryPrefix + summary,
+	})
+	return append(messages, conversation[1:]...)
+}
+
+// withoutSummary reverses withSummary.
+func withoutSummary(messages []openai.ChatCompletionMessage, summary string) []openai.ChatCompletionMessage {
+	conversation := make([]openai.ChatCompletionMessage, 0, len(messages))
+	if summary == "" || len(messages) < 2 {
+		return append(conversation, messages...)
+	}
+
+	conversation = append(conversation, messages[0])
+	return append(conversation, messages[2:]...)
+}
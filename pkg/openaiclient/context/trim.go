@@ -0,0 +1,52 @@
+package context
+
+import (
+	"fmt"
+
+	"BrainyBuddyGo/pkg/openaiclient/tokenizer"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// FitConversation drops the oldest turns of a conversation until its prompt plus
+// maxTokens fits in the context window. The prompt (first message), the system
+// messages following it, such as a summary, and the new question (last message)
+// are always kept.
+func FitConversation(counter tokenizer.Counter, conversation []openai.ChatCompletionMessage, maxTokens int, contextWindow int) ([]openai.ChatCompletionMessage, error) {
+	budget := contextWindow - maxTokens
+	if tokenizer.CountMessages(counter, conversation) <= budget {
+		return conversation, nil
+	}
+
+	if len(conversation) < 2 {
+		return nil, ErrPromptTooLong
+	}
+
+	pinned := 1
+	for pinned < len(conversation)-1 && conversation[pinned].Role == openai.ChatMessageRoleSystem {
+		pinned++
+	}
+
+	system := conversation[:pinned]
+	history := conversation[pinned : len(conversation)-1]
+	question := conversation[len(conversation)-1]
+
+	for len(history) > 0 {
+		history = history[1:]
+		// Never start the history with an orphaned answer.
+		for len(history) > 0 && history[0].Role == openai.ChatMessageRoleAssistant {
+			history = history[1:]
+		}
+
+		trimmed := make([]openai.ChatCompletionMessage, 0, len(history)+pinned+1)
+		trimmed = append(trimmed, system...)
+		trimmed = append(trimmed, history...)
+		trimmed = append(trimmed, question)
+
+		if tokenizer.CountMessages(counter, trimmed) <= budget {
+			return trimmed, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %d tokens available", ErrPromptTooLong, budget)
+}
@@ -1,14 +1,16 @@
 package context
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"path/filepath"
-	"strings"
+	"log"
 	"time"
 
-	"github.com/cenkalti/backoff/v4"
+	"BrainyBuddyGo/pkg/openaiclient/queue"
+	"BrainyBuddyGo/pkg/openaiclient/retry"
+	"BrainyBuddyGo/pkg/prompt"
+
 	"github.com/chrisport/go-lang-detector/langdet"
 	"github.com/chrisport/go-lang-detector/langdet/langdetdef"
 )
@@ -20,63 +22,87 @@ func getWorkerCount(workers int) int {
 	return workers
 }
 
-func getPrompt(basepath string, production bool) (string, error) {
-	filepath := filepath.Join(basepath, DefaultPromptFile)
+// loadPrompts reads the prompt profiles of the configuration.
+func loadPrompts(config *OpenAiContextConfig, production bool) (*prompt.Library, error) {
+	if config.PromptDir != "" {
+		profiles, err := prompt.LoadDir(config.PromptDir)
+		if err != nil {
+			return nil, err
+		}
+		return prompt.NewLibrary(profiles, config.Profile)
+	}
 
-	promptBytes, err := ioutil.ReadFile(filepath)
+	profiles, err := prompt.LoadLegacyFile(config.PromptFile)
 	if err != nil {
-		return "", fmt.Errorf("failed to read prompt file: %w", err)
+		return nil, err
 	}
 
-	var allPrompts []string
-
-	if !production {
-		var config NormalPromptData
-		err = json.Unmarshal(promptBytes, &config)
-		if err != nil {
-			return "", fmt.Errorf("failed to decode config file: %w", err)
-		}
-		allPrompts = append(allPrompts, strings.Join(config.Welcome, " "))
-	} else {
-		var config map[string]map[string][]string
-		err = json.Unmarshal(promptBytes, &config)
-		if err != nil {
-			return "", fmt.Errorf("failed to decode config file: %w", err)
+	defaultProfile := config.Profile
+	if defaultProfile == "" {
+		defaultProfile = NormalProfile
+		if production {
+			defaultProfile = TeamAdvisorProfile
 		}
+	}
+	return prompt.NewLibrary(profiles, defaultProfile)
+}
 
-		teamAdvisorConfig, ok := config["team-advisor"]
-		if !ok {
-			return "", fmt.Errorf("team-advisor config not found")
+// messageName converts the first of names keeping a letter or a digit to the
+// name of a chat message, which may only contain ASCII letters, digits,
+// underscores and dashes and is at most 64 characters. A name written in
+// another script would only be underscores, so the next one is tried.
+func messageName(names ...string) string {
+	for _, candidate := range names {
+		name := []rune(candidate)
+		readable := false
+		for i, r := range name {
+			switch {
+			case r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9':
+				readable = true
+			case r != '_' && r != '-':
+				name[i] = '_'
+			}
 		}
-
-		for _, prompts := range teamAdvisorConfig {
-			allPrompts = append(allPrompts, strings.Join(prompts, " "))
+		if !readable {
+			continue
+		}
+		if len(name) > MaxMessageNameLength {
+			name = name[:MaxMessageNameLength]
 		}
+		return string(name)
 	}
+	return ""
+}
 
-	return strings.Join(allPrompts, " "), nil
+// retryPolicy retries an operation up to maxRetries times, logging every retry.
+func retryPolicy(operation string, maxRetries int) retry.Policy {
+	policy := retry.NewPolicy(maxRetries)
+	policy.OnRetry = func(attempt int, err error, wait time.Duration) {
+		log.Printf("%s failed on attempt %d, retrying in %v: %v", operation, attempt, wait.Round(time.Millisecond), err)
+	}
+	return policy
 }
 
-func retryWithBackoff(performFunc func() (interface{}, error), maxRetries int) (interface{}, error) {
-	bo := backoff.NewExponentialBackOff()
-	retryCount := 0
-	var result interface{}
-	var err error
-	for {
-		if result, err = performFunc(); err != nil {
-			if retryCount >= maxRetries {
-				return nil, fmt.Errorf("%w after maximum retries", err)
-			}
-			nextInterval := bo.NextBackOff()
-			if nextInterval != backoff.Stop {
-				retryCount++
-				time.Sleep(nextInterval)
-				continue
-			}
-			return nil, err
-		}
-		return result, nil
+// acquire takes one of the workers for the requester of ctx and returns the
+// function giving it back. It waits in the queue until a worker is free, ctx is
+// done or the context is closed.
+func (client *OpenAiContext) acquire(ctx context.Context) (func(), error) {
+	select {
+	case <-client.done:
+		return nil, ErrClosed
+	default:
+	}
+
+	release, err := client.queue.Acquire(ctx)
+	if errors.Is(err, queue.ErrClosed) {
+		return nil, ErrClosed
 	}
+	return release, err
+}
+
+// QueueStats reports how many workers are busy and how many requests wait for one.
+func (client *OpenAiContext) QueueStats() queue.Stats {
+	return client.queue.Stats()
 }
 
 func checkLanguage(input string) error {
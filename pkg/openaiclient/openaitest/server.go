@@ -0,0 +1,323 @@
+// Package openaitest provides an in-process server speaking the subset of the
+// OpenAI API used by the bot, so tests run without network access or an API key.
+package openaitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	DefaultReply = "Hello from the test server."
+	APIKey       = "test-key"
+)
+
+// Response scripts the answer to one chat completion request. A zero Status
+// answers successfully with Content, streamed as Chunks when the request asks
+// for a stream.
+type Response struct {
+	Content      string
+	Chunks       []string
+	FinishReason openai.FinishReason
+	Usage        openai.Usage
+	// Delay is added to the latency of the server before answering.
+	Delay time.Duration
+	// CutOff drops the connection after the chunks of a stream, before its
+	// end.
+	CutOff bool
+
+	Status     int
+	Message    string
+	RetryAfter time.Duration
+}
+
+// Error answers with the given HTTP status and an OpenAI error body.
+func Error(status int, message string) Response {
+	return Response{Status: status, Message: message}
+}
+
+// RateLimited answers with a 429 and a Retry-After header.
+func RateLimited(retryAfter time.Duration) Response {
+	return Response{
+		Status:     http.StatusTooManyRequests,
+		Message:    "Rate limit reached",
+		RetryAfter: retryAfter,
+	}
+}
+
+// Server records the requests it receives and answers them with the scripted
+// responses, in order, then with the default response.
+type Server struct {
+	*httptest.Server
+
+	// Latency delays every answer.
+	Latency time.Duration
+	// Default answers chat completions once the script is exhausted.
+	Default Response
+	// FlaggedWords are flagged by the moderation endpoint unless a moderation
+	// result is scripted.
+	FlaggedWords []string
+
+	chat        []Response
+	moderation  []bool
+	requests    []openai.ChatCompletionRequest
+	moderations []openai.ModerationRequest
+	mutex       sync.Mutex
+}
+
+// NewServer starts a server, the caller must Close it.
+func NewServer() *Server {
+	s := &Server{
+		Default: Response{
+			Content:      DefaultReply,
+			FinishReason: openai.FinishReasonStop,
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/chat/completions", s.handleChat)
+	mux.HandleFunc("/v1/moderations", s.handleModeration)
+	s.Server = httptest.NewServer(mux)
+
+	return s
+}
+
+// BaseURL is the value to configure as the OpenAI base URL.
+func (s *Server) BaseURL() string {
+	return s.URL + "/v1"
+}
+
+// ClientConfig returns a go-openai configuration pointing to the server.
+func (s *Server) ClientConfig() openai.ClientConfig {
+	config := openai.DefaultConfig(APIKey)
+	config.BaseURL = s.BaseURL()
+	return config
+}
+
+// EnqueueChat scripts the answers to the next chat completion requests.
+func (s *Server) EnqueueChat(responses ...Response) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.chat = append(s.chat, responses...)
+}
+
+// EnqueueModeration scripts whether the next moderation requests are flagged.
+func (s *Server) EnqueueModeration(flagged ...bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.moderation = append(s.moderation, flagged...)
+}
+
+// Requests returns the chat completion requests received so far.
+func (s *Server) Requests() []openai.ChatCompletionRequest {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]openai.ChatCompletionRequest(nil), s.requests...)
+}
+
+// ModerationRequests returns the moderation requests received so far.
+func (s *Server) ModerationRequests() []openai.ModerationRequest {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]openai.ModerationRequest(nil), s.moderations...)
+}
+
+func (s *Server) handleChat(w http.ResponseWriter, r *http.Request) {
+	var req openai.ChatCompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, Error(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	s.mutex.Lock()
+	s.requests = append(s.requests, req)
+	response := s.Default
+	if len(s.chat) > 0 {
+		response = s.chat[0]
+		s.chat = s.chat[1:]
+	}
+	latency := s.Latency
+	s.mutex.Unlock()
+
+	if !wait(r, latency+response.Delay) {
+		return
+	}
+
+	if response.Status != 0 && response.Status != http.StatusOK {
+		writeError(w, response)
+		return
+	}
+
+	if req.Stream {
+		writeStream(w, req, response)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+		ID:      "chatcmpl-test",
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   req.Model,
+		Choices: []openai.ChatCompletionChoice{{
+			Message: openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleAssistant,
+				Content: response.Content,
+			},
+			FinishReason: response.FinishReason,
+		}},
+		Usage: response.Usage,
+	})
+}
+
+func writeStream(w http.ResponseWriter, req openai.ChatCompletionRequest, response Response) {
+	chunks := response.Chunks
+	if chunks == nil {
+		chunks = splitWords(response.Content)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	flusher, _ := w.(http.Flusher)
+
+	send := func(delta string, finishReason openai.FinishReason) {
+		data, _ := json.Marshal(openai.ChatCompletionStreamResponse{
+			ID:      "chatcmpl-test",
+			Object:  "chat.completion.chunk",
+			Created: time.Now().Unix(),
+			Model:   req.Model,
+			Choices: []openai.ChatCompletionStreamChoice{{
+				Delta:        openai.ChatCompletionStreamChoiceDelta{Content: delta},
+				FinishReason: finishReason,
+			}},
+		})
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	for _, chunk := range chunks {
+		send(chunk, "")
+	}
+	if response.CutOff {
+		if hijacker, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hijacker.Hijack(); err == nil {
+				conn.Close()
+			}
+		}
+		return
+	}
+	send("", response.FinishReason)
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+// splitWords splits content in chunks that keep the spaces, so joining them
+// gives the content back.
+func splitWords(content string) []string {
+	var chunks []string
+	for content != "" {
+		next := strings.IndexByte(content[1:], ' ')
+		if next < 0 {
+			chunks = append(chunks, content)
+			break
+		}
+		chunks = append(chunks, content[:next+1])
+		content = content[next+1:]
+	}
+	return chunks
+}
+
+func (s *Server) handleModeration(w http.ResponseWriter, r *http.Request) {
+	var req openai.ModerationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, Error(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	s.mutex.Lock()
+	s.moderations = append(s.moderations, req)
+	var flagged bool
+	if len(s.moderation) > 0 {
+		flagged = s.moderation[0]
+		s.moderation = s.moderation[1:]
+	} else {
+		flagged = containsAny(strings.ToLower(req.Input), s.FlaggedWords)
+	}
+	latency := s.Latency
+	s.mutex.Unlock()
+
+	if !wait(r, latency) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(openai.ModerationResponse{
+		ID:      "modr-test",
+		Model:   "text-moderation-latest",
+		Results: []openai.Result{{Flagged: flagged}},
+	})
+}
+
+func containsAny(input string, words []string) bool {
+	for _, word := range words {
+		if strings.Contains(input, strings.ToLower(word)) {
+			return true
+		}
+	}
+	return false
+}
+
+// wait sleeps for the delay and reports false when the client went away.
+func wait(r *http.Request, delay time.Duration) bool {
+	if delay <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+func writeError(w http.ResponseWriter, response Response) {
+	if response.RetryAfter > 0 {
+		seconds := int(response.RetryAfter.Round(time.Second) / time.Second)
+		if seconds == 0 {
+			seconds = 1
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(response.Status)
+	json.NewEncoder(w).Encode(openai.ErrorResponse{
+		Error: &openai.APIError{
+			Message: response.Message,
+			Type:    errorType(response.Status),
+		},
+	})
+}
+
+func errorType(status int) string {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return "rate_limit_exceeded"
+	case status >= 500:
+		return "server_error"
+	default:
+		return "invalid_request_error"
+	}
+}
@@ -0,0 +1,315 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"BrainyBuddyGo/pkg/defaults"
+	"BrainyBuddyGo/pkg/openaiclient/retry"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	anthropicBaseURL           = "https://api.anthropic.com"
+	anthropicVersion           = "2023-06-01"
+	anthropicMaxTokens         = 1024
+	anthropicConversationStart = "(start of conversation)"
+
+	// AnthropicMaxTemperature is the highest temperature the Messages API
+	// accepts, OpenAI goes up to 2.
+	AnthropicMaxTemperature = defaults.AnthropicMaxTemperature
+)
+
+// Anthropic is the ChatProvider backed by Anthropic's Messages API. It has no
+// moderation endpoint, combine it with WithModerator to moderate questions.
+type Anthropic struct {
+	apiKey     string
+	baseURL    string
+	httpClient *http.Client
+}
+
+func NewAnthropic(apiKey string, baseURL string) *Anthropic {
+	if baseURL == "" {
+		baseURL = anthropicBaseURL
+	}
+	return &Anthropic{
+		apiKey:     apiKey,
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: retry.WrapClient(&http.Client{}),
+	}
+}
+
+type anthropicMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type anthropicRequest struct {
+	Model       string             `json:"model"`
+	System      string             `json:"system,omitempty"`
+	Messages    []anthropicMessage `json:"messages"`
+	MaxTokens   int                `json:"max_tokens"`
+	Temperature float32            `json:"temperature,omitempty"`
+	Stream      bool               `json:"stream,omitempty"`
+}
+
+type anthropicResponse struct {
+	ID      string `json:"id"`
+	Model   string `json:"model"`
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	StopReason string `json:"stop_reason"`
+	Usage      struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+}
+
+type anthropicError struct {
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// anthropicEvent covers the server-sent events used while streaming.
+type anthropicEvent struct {
+	Type  string `json:"type"`
+	Delta struct {
+		Type       string `json:"type"`
+		Text       string `json:"text"`
+		StopReason string `json:"stop_reason"`
+	} `json:"delta"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (a *Anthropic) Complete(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	resp, err := a.send(ctx, convertToAnthropic(req, false))
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	var result anthropicResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return openai.ChatCompletionResponse{}, fmt.Errorf("failed to decode anthropic response: %w", err)
+	}
+
+	var text strings.Builder
+	for _, block := range result.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+
+	return openai.ChatCompletionResponse{
+		ID:    result.ID,
+		Model: result.Model,
+		Choices: []openai.ChatCompletionChoice{
+			{
+				Message: openai.ChatCompletionMessage{
+					Role:    openai.ChatMessageRoleAssistant,
+					Content: text.String(),
+				},
+				FinishReason: convertStopReason(result.StopReason),
+			},
+		},
+		Usage: openai.Usage{
+			PromptTokens:     result.Usage.InputTokens,
+			CompletionTokens: result.Usage.OutputTokens,
+			TotalTokens:      result.Usage.InputTokens + result.Usage.OutputTokens,
+		},
+	}, nil
+}
+
+func (a *Anthropic) Stream(ctx context.Context, req openai.ChatCompletionRequest) (ChatStream, error) {
+	resp, err := a.send(ctx, convertToAnthropic(req, true))
+	if err != nil {
+		return nil, err
+	}
+
+	return &anthropicStream{
+		body:   resp.Body,
+		reader: bufio.NewReader(resp.Body),
+		model:  req.Model,
+	}, nil
+}
+
+func (a *Anthropic) Moderate(ctx context.Context, req openai.ModerationRequest) (openai.ModerationResponse, error) {
+	return unmoderated(req), nil
+}
+
+// send posts the request and converts error responses to *openai.APIError, so
+// callers handle failures the same way for every provider.
+func (a *Anthropic) send(ctx context.Context, body anthropicRequest) (*http.Response, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, a.baseURL+"/v1/messages", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-api-key", a.apiKey)
+	httpReq.Header.Set("anthropic-version", anthropicVersion)
+
+	resp, err := a.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+
+		var errResp anthropicError
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil || errResp.Error.Message == "" {
+			return nil, &openai.RequestError{HTTPStatusCode: resp.StatusCode, Err: err}
+		}
+		return nil, &openai.APIError{
+			Type:           errResp.Error.Type,
+			Message:        errResp.Error.Message,
+			HTTPStatusCode: resp.StatusCode,
+		}
+	}
+
+	return resp, nil
+}
+
+// convertToAnthropic moves system messages to the system field and merges
+// consecutive messages of the same role, which the Messages API rejects.
+func convertToAnthropic(req openai.ChatCompletionRequest, stream bool) anthropicRequest {
+	result := anthropicRequest{
+		Model:       req.Model,
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+		Stream:      stream,
+	}
+	if result.MaxTokens == 0 {
+		result.MaxTokens = anthropicMaxTokens
+	}
+	// Temperatures configured for OpenAI may be out of range.
+	if result.Temperature > AnthropicMaxTemperature {
+		result.Temperature = AnthropicMaxTemperature
+	}
+
+	var system []string
+	for _, message := range req.Messages {
+		if message.Role == openai.ChatMessageRoleSystem {
+			system = append(system, message.Content)
+			continue
+		}
+
+		role := "user"
+		if message.Role == openai.ChatMessageRoleAssistant {
+			role = "assistant"
+		}
+
+		content := message.Content
+		if message.Name != "" {
+			content = message.Name + ": " + content
+		}
+
+		if len(result.Messages) == 0 && role == "assistant" {
+			result.Messages = append(result.Messages, anthropicMessage{Role: "user", Content: anthropicConversationStart})
+		}
+
+		last := len(result.Messages) - 1
+		if last >= 0 && result.Messages[last].Role == role {
+			result.Messages[last].Content += "\n\n" + content
+			continue
+		}
+		result.Messages = append(result.Messages, anthropicMessage{Role: role, Content: content})
+	}
+	result.System = strings.Join(system, "\n\n")
+
+	return result
+}
+
+func convertStopReason(reason string) openai.FinishReason {
+	switch reason {
+	case "end_turn", "stop_sequence":
+		return openai.FinishReasonStop
+	case "max_tokens":
+		return openai.FinishReasonLength
+	case "":
+		return ""
+	default:
+		return openai.FinishReason(reason)
+	}
+}
+
+type anthropicStream struct {
+	body     io.ReadCloser
+	reader   *bufio.Reader
+	model    string
+	finished bool
+}
+
+func (s *anthropicStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	for {
+		if s.finished {
+			return openai.ChatCompletionStreamResponse{}, io.EOF
+		}
+
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			return openai.ChatCompletionStreamResponse{}, err
+		}
+
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+
+		var event anthropicEvent
+		if err := json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data:"))), &event); err != nil {
+			return openai.ChatCompletionStreamResponse{}, fmt.Errorf("failed to decode anthropic event: %w", err)
+		}
+
+		switch event.Type {
+		case "content_block_delta":
+			return s.chunk(event.Delta.Text, ""), nil
+		case "message_delta":
+			if event.Delta.StopReason != "" {
+				return s.chunk("", convertStopReason(event.Delta.StopReason)), nil
+			}
+		case "message_stop":
+			s.finished = true
+		case "error":
+			return openai.ChatCompletionStreamResponse{}, &openai.APIError{
+				Type:    event.Error.Type,
+				Message: event.Error.Message,
+			}
+		}
+	}
+}
+
+func (s *anthropicStream) chunk(text string, reason openai.FinishReason) openai.ChatCompletionStreamResponse {
+	return openai.ChatCompletionStreamResponse{
+		Model: s.model,
+		Choices: []openai.ChatCompletionStreamChoice{
+			{
+				Delta:        openai.ChatCompletionStreamChoiceDelta{Content: text},
+				FinishReason: reason,
+			},
+		},
+	}
+}
+
+func (s *anthropicStream) Close() {
+	s.body.Close()
+}
@@ -0,0 +1,61 @@
+package provider
+
+import (
+	"context"
+
+	"BrainyBuddyGo/pkg/openaiclient/retry"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// OpenAI is the ChatProvider backed by the OpenAI API.
+type OpenAI struct {
+	client *openai.Client
+}
+
+func NewOpenAI(apiKey string) *OpenAI {
+	return NewOpenAIWithConfig(openai.DefaultConfig(apiKey))
+}
+
+func NewOpenAIWithBaseURL(apiKey string, baseURL string) *OpenAI {
+	config := openai.DefaultConfig(apiKey)
+	config.BaseURL = baseURL
+	return NewOpenAIWithConfig(config)
+}
+
+// NewOpenAIWithConfig creates the provider with a copy of the HTTP client of the
+// configuration, which records Retry-After headers for retry.Policy.
+func NewOpenAIWithConfig(config openai.ClientConfig) *OpenAI {
+	config.HTTPClient = retry.WrapClient(config.HTTPClient)
+	return &OpenAI{client: openai.NewClientWithConfig(config)}
+}
+
+func (o *OpenAI) Complete(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	return o.client.CreateChatCompletion(ctx, req)
+}
+
+func (o *OpenAI) Stream(ctx context.Context, req openai.ChatCompletionRequest) (ChatStream, error) {
+	stream, err := o.client.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+func (o *OpenAI) Moderate(ctx context.Context, req openai.ModerationRequest) (openai.ModerationResponse, error) {
+	return o.client.Moderations(ctx, req)
+}
+
+// Compatible talks to servers implementing the OpenAI chat API, such as Ollama,
+// llama.cpp or vLLM. They have no moderation endpoint, so nothing is flagged.
+type Compatible struct {
+	*OpenAI
+}
+
+func NewCompatible(baseURL string, apiKey string) *Compatible {
+	return &Compatible{OpenAI: NewOpenAIWithBaseURL(apiKey, baseURL)}
+}
+
+func (c *Compatible) Moderate(ctx context.Context, req openai.ModerationRequest) (openai.ModerationResponse, error) {
+	return unmoderated(req), nil
+}
@@ -0,0 +1,86 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	KindOpenAI     = "openai"
+	KindCompatible = "openai-compatible"
+	KindAnthropic  = "anthropic"
+)
+
+// ChatProvider is an LLM backend. Requests and responses use the OpenAI types,
+// adapters for other APIs translate them.
+type ChatProvider interface {
+	Complete(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
+	Stream(ctx context.Context, req openai.ChatCompletionRequest) (ChatStream, error)
+	Moderate(ctx context.Context, req openai.ModerationRequest) (openai.ModerationResponse, error)
+}
+
+// ChatStream yields completion chunks until Recv returns io.EOF.
+type ChatStream interface {
+	Recv() (openai.ChatCompletionStreamResponse, error)
+	Close()
+}
+
+type Config struct {
+	Kind    string
+	BaseURL string
+	APIKey  string
+}
+
+// New creates the provider described by the configuration.
+func New(config Config) (ChatProvider, error) {
+	switch config.Kind {
+	case "", KindOpenAI:
+		if config.APIKey == "" {
+			return nil, fmt.Errorf("%s provider requires an API key", KindOpenAI)
+		}
+		if config.BaseURL != "" {
+			return NewOpenAIWithBaseURL(config.APIKey, config.BaseURL), nil
+		}
+		return NewOpenAI(config.APIKey), nil
+	case KindCompatible:
+		if config.BaseURL == "" {
+			return nil, fmt.Errorf("%s provider requires a base URL", KindCompatible)
+		}
+		return NewCompatible(config.BaseURL, config.APIKey), nil
+	case KindAnthropic:
+		if config.APIKey == "" {
+			return nil, fmt.Errorf("%s provider requires an API key", KindAnthropic)
+		}
+		return NewAnthropic(config.APIKey, config.BaseURL), nil
+	default:
+		return nil, fmt.Errorf("unknown provider %q", config.Kind)
+	}
+}
+
+// unmoderated is returned by backends without a moderation endpoint.
+func unmoderated(req openai.ModerationRequest) openai.ModerationResponse {
+	return openai.ModerationResponse{
+		Model:   req.Model,
+		Results: []openai.Result{{Flagged: false}},
+	}
+}
+
+type moderated struct {
+	ChatProvider
+	moderator ChatProvider
+}
+
+// WithModerator uses the moderation endpoint of another provider, typically
+// OpenAI, for a backend that has none.
+func WithModerator(chat ChatProvider, moderator ChatProvider) ChatProvider {
+	return &moderated{
+		ChatProvider: chat,
+		moderator:    moderator,
+	}
+}
+
+func (m *moderated) Moderate(ctx context.Context, req openai.ModerationRequest) (openai.ModerationResponse, error) {
+	return m.moderator.Moderate(ctx, req)
+}
@@ -0,0 +1,284 @@
+// Package queue hands out a fixed number of workers to API requests. Waiting
+// requests are served by priority, then in order of arrival, and a user can
+// only hold a limited number of workers so that others are not starved.
+package queue
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+
+	"BrainyBuddyGo/pkg/defaults"
+)
+
+const (
+	DefaultMaxWaiting = defaults.QueueMaxWaiting
+	DefaultMaxPerUser = defaults.QueueMaxPerUser
+)
+
+var (
+	ErrFull   = errors.New("too many requests are waiting")
+	ErrClosed = errors.New("queue is closed")
+)
+
+type requesterKey struct{}
+
+// Requester describes who a request is made for. It travels in the context of
+// the request, see WithRequester.
+type Requester struct {
+	// User is the key of the per-user limit, requests without a user are not
+	// limited.
+	User     string
+	Priority int
+	// OnPosition, when set, is called with the position of the request in the
+	// queue, starting at 1, every time it changes while it waits.
+	OnPosition func(position int)
+}
+
+// WithRequester returns a context whose requests are made for requester.
+func WithRequester(ctx context.Context, requester Requester) context.Context {
+	return context.WithValue(ctx, requesterKey{}, requester)
+}
+
+// RequesterFrom returns the requester of ctx, the zero value when there is none.
+func RequesterFrom(ctx context.Context) Requester {
+	requester, _ := ctx.Value(requesterKey{}).(Requester)
+	return requester
+}
+
+// Priorities gives the requests of members of some roles a higher priority,
+// by guild ID and role ID.
+type Priorities map[string]map[string]int
+
+// Of returns the highest priority of the roles in the guild, or zero.
+func (p Priorities) Of(guildID string, roleIDs []string) int {
+	priority := 0
+	for _, roleID := range roleIDs {
+		if rolePriority, ok := p[guildID][roleID]; ok && rolePriority > priority {
+			priority = rolePriority
+		}
+	}
+	return priority
+}
+
+// Stats is a snapshot of the queue.
+type Stats struct {
+	Workers int
+	Busy    int
+	Waiting int
+}
+
+type ticket struct {
+	requester Requester
+	seq       uint64
+	position  int
+	// ready is closed once the ticket is granted a worker or the queue closes,
+	// positions carries the latest position while it waits.
+	ready     chan struct{}
+	positions chan int
+	granted   bool
+	err       error
+}
+
+type Queue struct {
+	workers    int
+	maxWaiting int
+	maxPerUser int
+
+	busy    int
+	active  map[string]int
+	waiting []*ticket
+	seq     uint64
+	closed  bool
+	mutex   sync.Mutex
+}
+
+// New creates a queue of workers requests running at once. Zero maxWaiting or
+// maxPerUser disable the corresponding limit.
+func New(workers int, maxWaiting int, maxPerUser int) *Queue {
+	if workers <= 0 {
+		workers = 1
+	}
+	return &Queue{
+		workers:    workers,
+		maxWaiting: maxWaiting,
+		maxPerUser: maxPerUser,
+		active:     make(map[string]int),
+	}
+}
+
+// SetLimits changes the waiting and per-user limits. Requests already waiting
+// are kept.
+func (q *Queue) SetLimits(maxWaiting int, maxPerUser int) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.maxWaiting = maxWaiting
+	q.maxPerUser = maxPerUser
+	q.dispatch()
+}
+
+// Acquire waits for a worker for the requester of ctx and returns the function
+// giving it back. It fails with ErrFull when too many requests are waiting,
+// with ErrClosed once the queue is closed, or with the error of ctx.
+func (q *Queue) Acquire(ctx context.Context) (func(), error) {
+	requester := RequesterFrom(ctx)
+
+	q.mutex.Lock()
+	if q.closed {
+		q.mutex.Unlock()
+		return nil, ErrClosed
+	}
+
+	q.seq++
+	t := &ticket{
+		requester: requester,
+		seq:       q.seq,
+		ready:     make(chan struct{}),
+		positions: make(chan int, 1),
+	}
+	q.insert(t)
+	q.dispatch()
+
+	if !t.granted && q.maxWaiting > 0 && len(q.waiting) > q.maxWaiting {
+		q.remove(t)
+		q.dispatch()
+		q.mutex.Unlock()
+		return nil, ErrFull
+	}
+	q.mutex.Unlock()
+
+	for {
+		select {
+		case <-t.ready:
+			if t.err != nil {
+				return nil, t.err
+			}
+			return q.releaseFunc(t), nil
+		case position := <-t.positions:
+			if requester.OnPosition != nil {
+				requester.OnPosition(position)
+			}
+		case <-ctx.Done():
+			q.mutex.Lock()
+			if t.granted {
+				q.mutex.Unlock()
+				q.releaseFunc(t)()
+				return nil, ctx.Err()
+			}
+			if t.err == nil {
+				q.remove(t)
+				q.dispatch()
+			}
+			q.mutex.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Close fails the requests waiting and those to come with ErrClosed. Workers
+// already handed out are unaffected.
+func (q *Queue) Close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed {
+		return
+	}
+	q.closed = true
+	for _, t := range q.waiting {
+		t.err = ErrClosed
+		close(t.ready)
+	}
+	q.waiting = nil
+}
+
+func (q *Queue) Stats() Stats {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return Stats{Workers: q.workers, Busy: q.busy, Waiting: len(q.waiting)}
+}
+
+func (q *Queue) releaseFunc(t *ticket) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			q.mutex.Lock()
+			defer q.mutex.Unlock()
+
+			q.busy--
+			if user := t.requester.User; user != "" {
+				q.active[user]--
+				if q.active[user] <= 0 {
+					delete(q.active, user)
+				}
+			}
+			q.dispatch()
+		})
+	}
+}
+
+// insert keeps the waiting tickets sorted by priority, then by arrival.
+func (q *Queue) insert(t *ticket) {
+	i := sort.Search(len(q.waiting), func(i int) bool {
+		return q.waiting[i].requester.Priority < t.requester.Priority
+	})
+	q.waiting = append(q.waiting, nil)
+	copy(q.waiting[i+1:], q.waiting[i:])
+	q.waiting[i] = t
+}
+
+func (q *Queue) remove(t *ticket) {
+	for i, waiting := range q.waiting {
+		if waiting == t {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			return
+		}
+	}
+}
+
+// dispatch hands the free workers to the first waiting tickets whose user is
+// below the per-user limit, then tells the others their new position.
+func (q *Queue) dispatch() {
+	for q.busy < q.workers {
+		i := q.nextEligible()
+		if i < 0 {
+			break
+		}
+
+		t := q.waiting[i]
+		q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+		q.busy++
+		if user := t.requester.User; user != "" {
+			q.active[user]++
+		}
+		t.granted = true
+		close(t.ready)
+	}
+
+	for i, t := range q.waiting {
+		position := i + 1
+		if position == t.position {
+			continue
+		}
+		t.position = position
+		// Only the latest position matters to the waiting request
+		select {
+		case <-t.positions:
+		default:
+		}
+		t.positions <- position
+	}
+}
+
+func (q *Queue) nextEligible() int {
+	for i, t := range q.waiting {
+		user := t.requester.User
+		if user == "" || q.maxPerUser <= 0 || q.active[user] < q.maxPerUser {
+			return i
+		}
+	}
+	return -1
+}
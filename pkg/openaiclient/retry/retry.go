@@ -0,0 +1,129 @@
+// Package retry retries failed API requests. Errors are classified so that only
+// transient failures are retried, waiting as long as the server asks in its
+// Retry-After header, or with an exponential backoff with jitter otherwise.
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+const (
+	DefaultInitialInterval = 500 * time.Millisecond
+	DefaultMaxInterval     = 30 * time.Second
+	DefaultJitter          = 0.5
+	DefaultMaxRetryAfter   = time.Minute
+)
+
+// Policy describes how often and how long to wait before retrying.
+type Policy struct {
+	// MaxRetries is the number of attempts after the first one.
+	MaxRetries      int
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	// Jitter randomizes every backoff interval by up to this fraction.
+	Jitter float64
+	// MaxRetryAfter is the longest wait requested by the server that is
+	// honoured, the request fails when the server asks for more.
+	MaxRetryAfter time.Duration
+	// OnRetry, when set, is called before waiting for the next attempt.
+	OnRetry func(attempt int, err error, wait time.Duration)
+}
+
+func NewPolicy(maxRetries int) Policy {
+	return Policy{
+		MaxRetries:      maxRetries,
+		InitialInterval: DefaultInitialInterval,
+		MaxInterval:     DefaultMaxInterval,
+		Jitter:          DefaultJitter,
+		MaxRetryAfter:   DefaultMaxRetryAfter,
+	}
+}
+
+// Error is returned by Do when the last attempt failed.
+type Error struct {
+	Attempts int
+	Err      error
+}
+
+func (e *Error) Error() string {
+	if e.Attempts == 1 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%v (after %d attempts)", e.Err, e.Attempts)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Do calls attempt until it succeeds, fails with an error that is not worth
+// retrying, the retries are exhausted or ctx is done. The context given to
+// attempt must be used for the requests so that a Retry-After header can be
+// picked up by the Transport.
+func (p Policy) Do(ctx context.Context, attempt func(ctx context.Context) error) error {
+	bo := p.backoff()
+
+	for attempts := 1; ; attempts++ {
+		hint := &retryHint{}
+		err := attempt(withHint(ctx, hint))
+		if err == nil {
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return &Error{Attempts: attempts, Err: ctx.Err()}
+		}
+		if attempts > p.MaxRetries || !Retryable(err) {
+			return &Error{Attempts: attempts, Err: err}
+		}
+
+		wait := bo.NextBackOff()
+		if retryAfter, ok := hint.get(); ok {
+			if p.MaxRetryAfter > 0 && retryAfter > p.MaxRetryAfter {
+				return &Error{Attempts: attempts, Err: err}
+			}
+			wait = retryAfter
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return &Error{Attempts: attempts, Err: err}
+		}
+
+		if p.OnRetry != nil {
+			p.OnRetry(attempts, err, wait)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return &Error{Attempts: attempts, Err: ctx.Err()}
+		}
+	}
+}
+
+func (p Policy) backoff() *backoff.ExponentialBackOff {
+	bo := backoff.NewExponentialBackOff()
+	bo.InitialInterval = p.InitialInterval
+	bo.MaxInterval = p.MaxInterval
+	bo.RandomizationFactor = p.Jitter
+	// The number of retries bounds the attempts, not the elapsed time.
+	bo.MaxElapsedTime = 0
+	bo.Reset()
+	return bo
+}
+
+// Attempts returns the number of attempts made before err was returned by Do,
+// or zero when err does not come from Do.
+func Attempts(err error) int {
+	var retryErr *Error
+	if errors.As(err, &retryErr) {
+		return retryErr.Attempts
+	}
+	return 0
+}
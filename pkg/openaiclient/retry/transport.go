@@ -0,0 +1,96 @@
+package retry
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type hintKey struct{}
+
+// retryHint carries the wait requested by the server from the Transport back
+// to Do.
+type retryHint struct {
+	retryAfter time.Duration
+	set        bool
+	mutex      sync.Mutex
+}
+
+func (h *retryHint) put(d time.Duration) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.retryAfter = d
+	h.set = true
+}
+
+func (h *retryHint) get() (time.Duration, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.retryAfter, h.set
+}
+
+func withHint(ctx context.Context, hint *retryHint) context.Context {
+	return context.WithValue(ctx, hintKey{}, hint)
+}
+
+// Transport records the Retry-After header of failed responses for Do, since
+// the API clients do not expose the headers in their errors.
+type Transport struct {
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	resp, err := base.RoundTrip(req)
+	if err != nil || resp.StatusCode < http.StatusBadRequest {
+		return resp, err
+	}
+
+	if hint, ok := req.Context().Value(hintKey{}).(*retryHint); ok {
+		if retryAfter, ok := parseRetryAfter(resp.Header, time.Now()); ok {
+			hint.put(retryAfter)
+		}
+	}
+	return resp, nil
+}
+
+// WrapClient returns a copy of client whose requests go through a Transport.
+func WrapClient(client *http.Client) *http.Client {
+	if client == nil {
+		client = &http.Client{}
+	}
+	wrapped := *client
+	wrapped.Transport = &Transport{Base: client.Transport}
+	return &wrapped
+}
+
+// parseRetryAfter reads the retry-after-ms header sent by OpenAI or the
+// standard Retry-After header, in seconds or as a date.
+func parseRetryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	if value := header.Get("Retry-After-Ms"); value != "" {
+		if ms, err := strconv.ParseFloat(value, 64); err == nil && ms >= 0 {
+			return time.Duration(ms * float64(time.Millisecond)), true
+		}
+	}
+
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := date.Sub(now); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
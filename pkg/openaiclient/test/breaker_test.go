@@ -0,0 +1,139 @@
+package context_test
+
+import (
+	"BrainyBuddyGo/pkg/openaiclient/breaker"
+	contextpkg "BrainyBuddyGo/pkg/openaiclient/context"
+	"BrainyBuddyGo/pkg/openaiclient/openaitest"
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+var unavailable = &openai.APIError{HTTPStatusCode: http.StatusServiceUnavailable, Message: "overloaded"}
+
+func TestBreakerOpensAndRecovers(t *testing.T) {
+	b := breaker.New(breaker.Config{FailureThreshold: 2, OpenTimeout: 50 * time.Millisecond})
+
+	for i := 0; i < 2; i++ {
+		if err := b.Allow(); err != nil {
+			t.Fatalf("Expected request %d to be allowed, got %v", i, err)
+		}
+		b.Record(unavailable)
+	}
+	if state := b.Status().State; state != breaker.Open {
+		t.Fatalf("Expected the circuit to open, got %s", state)
+	}
+	if err := b.Allow(); !errors.Is(err, breaker.ErrOpen) {
+		t.Fatalf("Expected ErrOpen, got %v", err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Expected a probe after the timeout, got %v", err)
+	}
+	if err := b.Allow(); !errors.Is(err, breaker.ErrOpen) {
+		t.Fatalf("Expected a single probe at a time, got %v", err)
+	}
+
+	b.Record(unavailable)
+	if state := b.Status().State; state != breaker.Open {
+		t.Fatalf("Expected a failed probe to open the circuit again, got %s", state)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if err := b.Allow(); err != nil {
+		t.Fatal(err)
+	}
+	b.Record(nil)
+	if status := b.Status(); status.State != breaker.Closed || status.Failures != 0 {
+		t.Fatalf("Expected a successful probe to close the circuit, got %+v", status)
+	}
+}
+
+func TestBreakerIgnoresClientErrorsAndCancellation(t *testing.T) {
+	b := breaker.New(breaker.Config{FailureThreshold: 1, OpenTimeout: time.Minute})
+
+	for _, err := range []error{
+		&openai.APIError{HTTPStatusCode: http.StatusBadRequest, Message: "invalid"},
+		context.Canceled,
+	} {
+		if err := b.Allow(); err != nil {
+			t.Fatal(err)
+		}
+		b.Record(err)
+	}
+
+	if status := b.Status(); status.State != breaker.Closed {
+		t.Fatalf("Expected the circuit to stay closed, got %+v", status)
+	}
+}
+
+func TestGenerateResponseShortCircuitsWhenOpen(t *testing.T) {
+	server := newTestServer(t)
+	server.Default = openaitest.Error(http.StatusInternalServerError, "The server had an error")
+
+	config := contextpkg.DefaultConfig(openaitest.APIKey, 1)
+	config.BaseURL = server.BaseURL()
+	config.PromptFile = filepath.Join(newBasepath(t), contextpkg.DefaultPromptFile)
+	config.MaxRetries = 0
+	config.Breaker = breaker.Config{FailureThreshold: 2, OpenTimeout: time.Minute}
+
+	ctx, err := contextpkg.NewOpenAiContextWithConfig(config, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(ctx.Close)
+
+	for i := 0; i < 2; i++ {
+		if _, err := ctx.GenerateResponse(context.Background(), "Hi", contextpkg.Author{ID: "testUser"}, contextpkg.GenerationOptions{}); err == nil {
+			t.Fatal("Expected an error when the server fails")
+		}
+	}
+
+	_, err = ctx.GenerateResponse(context.Background(), "Hi", contextpkg.Author{ID: "testUser"}, contextpkg.GenerationOptions{})
+	if !errors.Is(err, breaker.ErrOpen) {
+		t.Fatalf("Expected ErrOpen once the circuit is open, got %v", err)
+	}
+	if got := len(server.Requests()); got != 2 {
+		t.Fatalf("Expected no request while the circuit is open, got %d requests", got)
+	}
+	if status := ctx.APIStatus(); status.State != breaker.Open || status.Failures != 2 {
+		t.Fatalf("Expected an open circuit after 2 failures, got %+v", status)
+	}
+}
+
+func TestStreamsCutOffMidwayOpenTheCircuit(t *testing.T) {
+	server := newTestServer(t)
+	server.Default = openaitest.Response{Chunks: []string{"Hello", " there"}, CutOff: true}
+
+	config := contextpkg.DefaultConfig(openaitest.APIKey, 1)
+	config.BaseURL = server.BaseURL()
+	config.PromptFile = filepath.Join(newBasepath(t), contextpkg.DefaultPromptFile)
+	config.MaxRetries = 0
+	config.Breaker = breaker.Config{FailureThreshold: 2, OpenTimeout: time.Minute}
+
+	ctx, err := contextpkg.NewOpenAiContextWithConfig(config, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(ctx.Close)
+
+	if _, err := ctx.GenerateResponseStream(context.Background(), "Hi", contextpkg.Author{ID: "testUser"}, contextpkg.GenerationOptions{}, nil); err == nil {
+		t.Fatal("Expected an error when the stream is cut off")
+	}
+	if status := ctx.APIStatus(); status.State != breaker.Closed || status.Failures != 1 {
+		t.Fatalf("Expected the cut off stream to count as a failure, got %+v", status)
+	}
+
+	if _, err := ctx.GenerateResponseStream(context.Background(), "Hi", contextpkg.Author{ID: "testUser"}, contextpkg.GenerationOptions{}, nil); err == nil {
+		t.Fatal("Expected an error when the stream is cut off")
+	}
+	if status := ctx.APIStatus(); status.State != breaker.Open {
+		t.Fatalf("Expected an open circuit after 2 cut off streams, got %+v", status)
+	}
+}
@@ -0,0 +1,211 @@
+package context_test
+
+import (
+	"BrainyBuddyGo/pkg/openaiclient/budget"
+	contextpkg "BrainyBuddyGo/pkg/openaiclient/context"
+	"BrainyBuddyGo/pkg/openaiclient/openaitest"
+	"context"
+	"errors"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func usage(prompt int, completion int) openai.Usage {
+	return openai.Usage{PromptTokens: prompt, CompletionTokens: completion, TotalTokens: prompt + completion}
+}
+
+func TestBudgetWarnsAndRefuses(t *testing.T) {
+	config := budget.DefaultConfig()
+	config.UserDaily = budget.Limit{Tokens: 100}
+	tracker := budget.New(config)
+	alice := budget.Account{User: "alice", Guild: "guild"}
+
+	if warnings := tracker.Record(alice, openai.GPT3Dot5Turbo, usage(50, 10)); len(warnings) != 0 {
+		t.Fatalf("Expected no warning at 60%%, got %+v", warnings)
+	}
+
+	warnings := tracker.Record(alice, openai.GPT3Dot5Turbo, usage(20, 5))
+	if len(warnings) != 1 || warnings[0].Scope != budget.User || warnings[0].Threshold != budget.DefaultWarnAt {
+		t.Fatalf("Expected a warning for the daily budget, got %+v", warnings)
+	}
+	if err := tracker.Check(alice); err != nil {
+		t.Fatalf("Expected questions to be allowed below the limit, got %v", err)
+	}
+
+	warnings = tracker.Record(alice, openai.GPT3Dot5Turbo, usage(20, 5))
+	if len(warnings) != 1 || warnings[0].Threshold != 1 {
+		t.Fatalf("Expected a warning for the exhausted budget, got %+v", warnings)
+	}
+
+	err := tracker.Check(alice)
+	var exhausted *budget.ExhaustedError
+	if !errors.As(err, &exhausted) || exhausted.Scope != budget.User || !errors.Is(err, budget.ErrExhausted) {
+		t.Fatalf("Expected the daily budget to be exhausted, got %v", err)
+	}
+	if resetIn := time.Until(exhausted.ResetAt); resetIn <= 0 || resetIn > 24*time.Hour || exhausted.ResetAt.Hour() != 0 {
+		t.Errorf("Expected the budget to reset at midnight, got %v", exhausted.ResetAt)
+	}
+
+	if err := tracker.Check(budget.Account{User: "bob", Guild: "guild"}); err != nil {
+		t.Errorf("Expected other users to keep their budget, got %v", err)
+	}
+}
+
+func TestBudgetChargesDollarsToGuildAndGlobal(t *testing.T) {
+	config := budget.DefaultConfig()
+	config.GuildMonthly = budget.Limit{Dollars: 0.10}
+	config.GlobalMonthly = budget.Limit{Dollars: 1}
+	tracker := budget.New(config)
+
+	// 1000 prompt and 1000 completion tokens of gpt-4 cost $0.09
+	tracker.Record(budget.Account{User: "alice", Guild: "guild"}, "gpt-4-0613", usage(1000, 1000))
+
+	statuses := tracker.Usage(budget.Account{User: "bob", Guild: "guild"})
+	if len(statuses) != 2 || statuses[0].Scope != budget.Guild || statuses[1].Scope != budget.Global {
+		t.Fatalf("Expected the guild and global budgets, got %+v", statuses)
+	}
+	if spent := statuses[0].Spent.Dollars; math.Abs(spent-0.09) > 1e-9 {
+		t.Fatalf("Expected $0.09 to be spent, got $%v", spent)
+	}
+
+	tracker.Record(budget.Account{User: "bob", Guild: "guild"}, openai.GPT3Dot5Turbo, usage(10000, 0))
+	var exhausted *budget.ExhaustedError
+	if err := tracker.Check(budget.Account{User: "carol", Guild: "guild"}); !errors.As(err, &exhausted) || exhausted.Scope != budget.Guild {
+		t.Fatalf("Expected the guild budget to be exhausted, got %v", err)
+	}
+	if err := tracker.Check(budget.Account{User: "carol", Guild: "another guild"}); err != nil {
+		t.Fatalf("Expected other guilds to keep their budget, got %v", err)
+	}
+}
+
+func TestGenerateResponseChargesBudget(t *testing.T) {
+	server := newTestServer(t)
+	server.Default.Usage = usage(60, 40)
+
+	config := contextpkg.DefaultConfig(openaitest.APIKey, 1)
+	config.PromptFile = filepath.Join(newBasepath(t), contextpkg.DefaultPromptFile)
+	config.Budget.UserDaily = budget.Limit{Tokens: 150}
+	oa, err := contextpkg.NewOpenAiContextWithConfig(config, false, contextpkg.WithBaseURL(server.BaseURL()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(oa.Close)
+
+	var warnings []budget.Warning
+	ctx := budget.WithAccount(context.Background(), budget.Account{
+		User:      "alice",
+		OnWarning: func(warning budget.Warning) { warnings = append(warnings, warning) },
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, err := oa.GenerateResponse(ctx, "Hello, how are you today?", contextpkg.Author{ID: "alice"}, contextpkg.GenerationOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(warnings) != 1 || warnings[0].Threshold != 1 {
+		t.Fatalf("Expected the second answer to use up the budget, got %+v", warnings)
+	}
+
+	_, err = oa.GenerateResponse(ctx, "And now?", contextpkg.Author{ID: "alice"}, contextpkg.GenerationOptions{})
+	if !errors.Is(err, budget.ErrExhausted) {
+		t.Fatalf("Expected the question to be refused, got %v", err)
+	}
+	if requests := len(server.Requests()); requests != 2 {
+		t.Fatalf("Expected the refused question not to reach the API, got %d requests", requests)
+	}
+}
+
+func TestBudgetReservationsHoldConcurrentRequests(t *testing.T) {
+	config := budget.DefaultConfig()
+	config.UserDaily = budget.Limit{Tokens: 100}
+	tracker := budget.New(config)
+	alice := budget.Account{User: "alice"}
+
+	first, err := tracker.Reserve(alice, openai.GPT3Dot5Turbo, usage(20, 100))
+	if err != nil {
+		t.Fatalf("Expected the first request to be allowed, got %v", err)
+	}
+	if _, err := tracker.Reserve(alice, openai.GPT3Dot5Turbo, usage(20, 100)); !errors.Is(err, budget.ErrExhausted) {
+		t.Fatalf("Expected the reservation to hold the budget, got %v", err)
+	}
+
+	tracker.Settle(first, openai.GPT3Dot5Turbo, usage(20, 10))
+	statuses := tracker.Usage(alice)
+	if len(statuses) != 1 || statuses[0].Spent.Tokens != 30 {
+		t.Fatalf("Expected the actual usage to be charged, got %+v", statuses)
+	}
+
+	second, err := tracker.Reserve(alice, openai.GPT3Dot5Turbo, usage(20, 100))
+	if err != nil {
+		t.Fatalf("Expected the settled reservation to be given back, got %v", err)
+	}
+	tracker.Release(second)
+	if err := tracker.Check(alice); err != nil {
+		t.Fatalf("Expected the released reservation to be given back, got %v", err)
+	}
+}
+
+func TestUnknownModelsAreChargedTheHighestPrice(t *testing.T) {
+	prices := budget.DefaultPrices()
+	if prices.Has("llama3") {
+		t.Fatal("Expected llama3 to have no price")
+	}
+	if cost, highest := prices.Cost("llama3", usage(1000, 1000)), prices.Cost(openai.GPT432K, usage(1000, 1000)); cost != highest {
+		t.Errorf("Expected an unknown model to cost $%v, got $%v", highest, cost)
+	}
+
+	prices["llama3"] = budget.Price{}
+	if cost := prices.Cost("llama3", usage(1000, 1000)); cost != 0 {
+		t.Errorf("Expected a model priced at zero to be free, got $%v", cost)
+	}
+}
+
+func TestBudgetSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "budget.db")
+	config := budget.DefaultConfig()
+	config.UserDaily = budget.Limit{Tokens: 100}
+	alice := budget.Account{User: "alice", Guild: "guild"}
+
+	store, err := budget.NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale := budget.Period{Start: time.Now().AddDate(0, -2, 0), Spent: budget.Spend{Tokens: 1000}}
+	if err := store.Put("guild:another guild", stale); err != nil {
+		t.Fatal(err)
+	}
+	tracker, err := budget.NewPersistent(config, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker.Record(alice, openai.GPT3Dot5Turbo, usage(50, 10))
+	if err := tracker.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = budget.NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted, err := budget.NewPersistent(config, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { restarted.Close() })
+
+	statuses := restarted.Usage(alice)
+	if len(statuses) != 1 || statuses[0].Spent.Tokens != 60 {
+		t.Fatalf("Expected the 60 tokens spent before the restart, got %+v", statuses)
+	}
+	periods, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := periods["guild:another guild"]; ok || len(periods) != 3 {
+		t.Errorf("Expected the budgets of past periods to be deleted, got %v", periods)
+	}
+}
@@ -0,0 +1,56 @@
+package context_test
+
+import (
+	contextpkg "BrainyBuddyGo/pkg/openaiclient/context"
+	"BrainyBuddyGo/pkg/openaiclient/openaitest"
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenerationOptionsOverrideGlobalSettings(t *testing.T) {
+	server := newTestServer(t)
+
+	config := contextpkg.DefaultConfig(openaitest.APIKey, 1)
+	config.BaseURL = server.BaseURL()
+	config.PromptFile = filepath.Join(newBasepath(t), contextpkg.DefaultPromptFile)
+	config.Model = "gpt-4"
+	config.DefaultTemperature = 0.7
+	config.DefaultMaxTokens = 300
+
+	ctx, err := contextpkg.NewOpenAiContextWithConfig(config, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(ctx.Close)
+
+	hot := float32(1.2)
+	tests := []struct {
+		name        string
+		opts        contextpkg.GenerationOptions
+		model       string
+		temperature float32
+		maxTokens   int
+	}{
+		{"no options use the global settings", contextpkg.GenerationOptions{}, "gpt-4", 0.7, 300},
+		{"set options win", contextpkg.GenerationOptions{Model: "gpt-3.5-turbo", Temperature: &hot, MaxTokens: 50}, "gpt-3.5-turbo", 1.2, 50},
+		{"unset options inherit", contextpkg.GenerationOptions{MaxTokens: 50}, "gpt-4", 0.7, 50},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := ctx.GenerateResponse(context.Background(), "Hi", contextpkg.Author{ID: "testUser"}, test.opts); err != nil {
+				t.Fatal(err)
+			}
+
+			requests := server.Requests()
+			if len(requests) != i+1 {
+				t.Fatalf("Expected %d requests, got %d", i+1, len(requests))
+			}
+			req := requests[i]
+			if req.Model != test.model || req.Temperature != test.temperature || req.MaxTokens != test.maxTokens {
+				t.Errorf("Expected %s at %v with %d tokens, got %s at %v with %d tokens", test.model, test.temperature, test.maxTokens, req.Model, req.Temperature, req.MaxTokens)
+			}
+		})
+	}
+}
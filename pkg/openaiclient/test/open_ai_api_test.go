@@ -2,88 +2,62 @@ package context_test
 
 import (
 	contextpkg "BrainyBuddyGo/pkg/openaiclient/context"
+	"BrainyBuddyGo/pkg/openaiclient/openaitest"
+	"context"
 	"errors"
-	"log"
 	"os"
 	"path/filepath"
-	"runtime"
-	"strings"
 	"testing"
 	"time"
 
-	"github.com/joho/godotenv"
 	"github.com/sashabaranov/go-openai"
 )
 
-func setBasepath() string {
-	_, filename, _, _ := runtime.Caller(0)
-	basepath := filepath.Dir(filepath.Dir(filepath.Dir(filename)))
-	basepath = strings.Join(strings.Split(basepath, "/")[:len(strings.Split(basepath, "/"))-1], "/")
-	return basepath
-}
-func loadEnvFile(basepath string) {
-	envFilePath := filepath.Join(basepath, ".env")
-	err := godotenv.Load(envFilePath)
-	if err != nil {
-		log.Printf("Failed to load .env file: %s\n", err)
-	}
-}
-
-func getOpenAiToken() (string, error) {
-	openAiToken := os.Getenv("OPENAI_API_KEY")
-	if openAiToken == "" {
-		return "", errors.New("OPENAI_API_KEY not set")
+// newBasepath creates a project directory holding a prompt file at the default
+// location.
+func newBasepath(t *testing.T) string {
+	basepath := t.TempDir()
+	promptFile := filepath.Join(basepath, contextpkg.DefaultPromptFile)
+	if err := os.MkdirAll(filepath.Dir(promptFile), 0o755); err != nil {
+		t.Fatal(err)
 	}
-	return openAiToken, nil
+	writePrompt(t, promptFile, "You are a helpful assistant.")
+	return basepath
 }
 
-func isProduciton() (bool, error) {
-	production := false
-	productionEnv := os.Getenv("PRODUCTION")
-	if productionEnv == "true" {
-		production = true
-	}
-	return production, nil
+func newTestServer(t *testing.T) *openaitest.Server {
+	server := openaitest.NewServer()
+	t.Cleanup(server.Close)
+	return server
 }
 
-func getOpenAiContext() (*contextpkg.OpenAiContext, error) {
-	basepath := setBasepath()
-	loadEnvFile(basepath)
-	openAiToken, err := getOpenAiToken()
-	if err != nil {
-		return nil, err
-	}
-
-	isProduciton, err := isProduciton()
-	if err != nil {
-		return nil, err
-	}
-
-	apiKey := openAiToken
+func getOpenAiContext(t *testing.T, server *openaitest.Server) *contextpkg.OpenAiContext {
 	workers := 1
-	ctx, err := contextpkg.NewOpenAiContext(apiKey, workers, basepath, isProduciton)
+	ctx, err := contextpkg.NewOpenAiContext(openaitest.APIKey, workers, newBasepath(t), false, contextpkg.WithBaseURL(server.BaseURL()))
 	if err != nil {
-		return nil, err
+		t.Fatal(err)
 	}
-	return ctx, nil
+	return ctx
 }
 
 func TestNewOpenAiContext(t *testing.T) {
-	_, err := getOpenAiContext()
-	if err != nil {
-		t.Fatal(err)
+	getOpenAiContext(t, newTestServer(t))
+}
+
+func TestNewOpenAiContextWithoutAPIKey(t *testing.T) {
+	_, err := contextpkg.NewOpenAiContext("", 1, newBasepath(t), false)
+	if err != contextpkg.ErrEmptyAPIKey {
+		t.Fatalf("Expected ErrEmptyAPIKey but got %v", err)
 	}
 }
 
 func TestProcessMessageSucces(t *testing.T) {
-	ctx, err := getOpenAiContext()
-	if err != nil {
-		t.Fatal(err)
-	}
+	server := newTestServer(t)
+	server.FlaggedWords = []string{"kill"}
+	ctx := getOpenAiContext(t, server)
 
 	message := "Hi how are you?"
-	result := true
-	result, err = ctx.ModerationCheck(message, 1)
+	result, err := ctx.ModerationCheck(context.Background(), message, 1)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -93,44 +67,105 @@ func TestProcessMessageSucces(t *testing.T) {
 }
 
 func TestProcessMessageFail(t *testing.T) {
-	ctx, err := getOpenAiContext()
-	if err != nil {
-		t.Fatal(err)
-	}
+	server := newTestServer(t)
+	server.FlaggedWords = []string{"kill"}
+	ctx := getOpenAiContext(t, server)
 
 	message := "I want to kill this noobs"
-	result := false
-	result, err = ctx.ModerationCheck(message, 1)
+	result, err := ctx.ModerationCheck(context.Background(), message, 1)
 	if err != nil {
 		t.Fatal(err)
 	}
 	if result != true {
 		t.Fatalf("Expected result fail but was true")
 	}
+	if requests := server.ModerationRequests(); len(requests) != 1 || requests[0].Input != message {
+		t.Fatalf("Expected the message to be moderated, got %+v", requests)
+	}
 }
 
 func TestGenerateResponse(t *testing.T) {
-	ctx, err := getOpenAiContext()
-	if err != nil {
-		t.Fatal(err)
-	}
+	server := newTestServer(t)
+	ctx := getOpenAiContext(t, server)
 
 	message := "Hi how are you?"
-	result := ""
-	result, err = ctx.GenerateResponse(message, "testUser")
+	result, err := ctx.GenerateResponse(context.Background(), message, contextpkg.Author{ID: "testUser"}, contextpkg.GenerationOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if result == "" {
-		t.Fatalf("Expected result not empty but was empty")
+	if result != openaitest.DefaultReply {
+		t.Fatalf("Expected the reply of the server but got %q", result)
+	}
+
+	requests := server.Requests()
+	if len(requests) != 1 || requests[0].Model != contextpkg.DefaultModel {
+		t.Fatalf("Unexpected requests %+v", requests)
 	}
 }
 
-func TestCacheContains(t *testing.T) {
-	ctx, err := getOpenAiContext()
+func TestGenerateResponseStream(t *testing.T) {
+	server := newTestServer(t)
+	server.EnqueueChat(openaitest.Response{
+		Chunks:       []string{"Hello", ", ", "world"},
+		FinishReason: openai.FinishReasonStop,
+	})
+	ctx := getOpenAiContext(t, server)
+
+	var updates []string
+	result, err := ctx.GenerateResponseStream(context.Background(), "Hi", contextpkg.Author{ID: "testUser"}, contextpkg.GenerationOptions{}, func(partial string) {
+		updates = append(updates, partial)
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
+	if result != "Hello, world" {
+		t.Fatalf("Expected the streamed reply but got %q", result)
+	}
+	if len(updates) == 0 || updates[len(updates)-1] != "Hello, world" {
+		t.Fatalf("Expected progressive updates, got %q", updates)
+	}
+}
+
+func TestGenerateResponseServerError(t *testing.T) {
+	server := newTestServer(t)
+	server.Default = openaitest.Error(500, "The server had an error")
+	ctx := getOpenAiContext(t, server)
+
+	if _, err := ctx.GenerateResponse(context.Background(), "Hi", contextpkg.Author{ID: "testUser"}, contextpkg.GenerationOptions{}); err == nil {
+		t.Fatal("Expected an error when the server keeps failing")
+	}
+}
+
+func TestGenerateResponseDeadline(t *testing.T) {
+	server := newTestServer(t)
+	server.Default = openaitest.Response{Content: "Too late", Delay: 5 * time.Second}
+	ctx := getOpenAiContext(t, server)
+
+	deadline, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := ctx.GenerateResponse(deadline, "Hi", contextpkg.Author{ID: "testUser"}, contextpkg.GenerationOptions{}); err == nil {
+		t.Fatal("Expected an error when the deadline is exceeded")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Expected the request to be aborted at the deadline, took %v", elapsed)
+	}
+}
+
+func TestCloseRefusesRequests(t *testing.T) {
+	ctx := getOpenAiContext(t, newTestServer(t))
+
+	ctx.Close()
+	ctx.Close()
+
+	if _, err := ctx.GenerateResponse(context.Background(), "Hi", contextpkg.Author{ID: "testUser"}, contextpkg.GenerationOptions{}); !errors.Is(err, contextpkg.ErrClosed) {
+		t.Fatalf("Expected ErrClosed after Close but got %v", err)
+	}
+}
+
+func TestCacheContains(t *testing.T) {
+	ctx := getOpenAiContext(t, newTestServer(t))
 
 	cacheItem := contextpkg.CacheItem{
 		Conversation: []openai.ChatCompletionMessage{},
@@ -151,13 +186,18 @@ func TestCacheContains(t *testing.T) {
 }
 
 func TestRunCacheEviction(t *testing.T) {
-	ctx, err := getOpenAiContext()
+	server := newTestServer(t)
+
+	config := contextpkg.DefaultConfig(openaitest.APIKey, 1)
+	config.BaseURL = server.BaseURL()
+	config.PromptFile = filepath.Join(newBasepath(t), contextpkg.DefaultPromptFile)
+	config.CacheLifeTime = time.Second
+
+	ctx, err := contextpkg.NewOpenAiContextWithConfig(config, false)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	ctx.Config.CacheLifeTime = 2 * time.Second
-
 	cacheItem := contextpkg.CacheItem{
 		Conversation: []openai.ChatCompletionMessage{},
 		Timestamp:    time.Now(),
@@ -170,7 +210,7 @@ func TestRunCacheEviction(t *testing.T) {
 
 	ctx.AddItemToCache("testKey", userCacheItem)
 
-	time.Sleep(3 * time.Second)
+	time.Sleep(2500 * time.Millisecond)
 
 	_, ok := ctx.CacheContains("testKey")
 	if ok {
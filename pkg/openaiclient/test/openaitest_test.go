@@ -0,0 +1,46 @@
+package context_test
+
+import (
+	"BrainyBuddyGo/pkg/openaiclient/openaitest"
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestServerScriptsErrorsAndLatency(t *testing.T) {
+	server := newTestServer(t)
+	server.EnqueueChat(openaitest.RateLimited(2*time.Second), openaitest.Response{Content: "late", Delay: time.Second})
+
+	client := openai.NewClientWithConfig(server.ClientConfig())
+	req := openai.ChatCompletionRequest{
+		Model:    openai.GPT3Dot5Turbo,
+		Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "hi"}},
+	}
+
+	_, err := client.CreateChatCompletion(context.Background(), req)
+	var apiErr *openai.APIError
+	if !errors.As(err, &apiErr) || apiErr.HTTPStatusCode != http.StatusTooManyRequests {
+		t.Fatalf("Expected a rate limit error, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := client.CreateChatCompletion(ctx, req); err == nil {
+		t.Fatal("Expected the delayed response to time out")
+	}
+
+	response, err := client.CreateChatCompletion(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Choices[0].Message.Content != openaitest.DefaultReply {
+		t.Fatalf("Expected the default reply once the script is exhausted, got %q", response.Choices[0].Message.Content)
+	}
+	if got := len(server.Requests()); got != 3 {
+		t.Fatalf("Expected 3 recorded requests, got %d", got)
+	}
+}
@@ -0,0 +1,47 @@
+package context_test
+
+import (
+	contextpkg "BrainyBuddyGo/pkg/openaiclient/context"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerateResponseUsesChannelProfile(t *testing.T) {
+	dir := t.TempDir()
+	for name, line := range map[string]string{
+		"default": "You are helpful.",
+		"pirate":  "Talk like a pirate to {{username}} in {{channel}}.",
+	} {
+		content := "sections:\n  - lines: [\"" + line + "\"]\n"
+		if err := ioutil.WriteFile(filepath.Join(dir, name+".yaml"), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fake := &fakeProvider{fakeCompleter{reply: "answer"}}
+	config := contextpkg.DefaultConfig("", 1)
+	config.PromptDir = dir
+	config.Profile = "default"
+
+	ctx, err := contextpkg.NewOpenAiContextWithConfig(config, false, contextpkg.WithProvider(fake))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ctx.GenerateResponse(context.Background(), "hello", contextpkg.Author{ID: "alice"}, contextpkg.GenerationOptions{Profile: "pirate", Channel: "harbor"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.requests[0].Messages[0].Content; !strings.Contains(got, "Talk like a pirate to alice in harbor.") {
+		t.Errorf("expected the pirate profile, got %q", got)
+	}
+
+	if _, err := ctx.GenerateResponse(context.Background(), "hello", contextpkg.Author{ID: "bob"}, contextpkg.GenerationOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.requests[1].Messages[0].Content; !strings.Contains(got, "You are helpful.") {
+		t.Errorf("expected the default profile, got %q", got)
+	}
+}
@@ -0,0 +1,166 @@
+package context_test
+
+import (
+	"BrainyBuddyGo/pkg/openaiclient/provider"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+type anthropicTestRequest struct {
+	Model    string `json:"model"`
+	System   string `json:"system"`
+	Messages []struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	} `json:"messages"`
+	MaxTokens   int     `json:"max_tokens"`
+	Temperature float32 `json:"temperature"`
+	Stream      bool    `json:"stream"`
+}
+
+func TestAnthropicComplete(t *testing.T) {
+	var received anthropicTestRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" || r.Header.Get("x-api-key") != "key" {
+			t.Errorf("Unexpected request %s with key %q", r.URL.Path, r.Header.Get("x-api-key"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Error(err)
+		}
+		fmt.Fprint(w, `{"id":"msg","model":"claude","content":[{"type":"text","text":"Hello!"}],"stop_reason":"end_turn","usage":{"input_tokens":10,"output_tokens":2}}`)
+	}))
+	defer server.Close()
+
+	anthropic := provider.NewAnthropic("key", server.URL)
+	resp, err := anthropic.Complete(context.Background(), openai.ChatCompletionRequest{
+		Model: "claude",
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: "Be nice"},
+			{Role: openai.ChatMessageRoleUser, Content: "Hi", Name: "bob"},
+			{Role: openai.ChatMessageRoleUser, Content: "Anyone?"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if received.System != "Be nice" {
+		t.Fatalf("Expected system prompt to be moved to the system field, got %q", received.System)
+	}
+	if len(received.Messages) != 1 || received.Messages[0].Content != "bob: Hi\n\nAnyone?" {
+		t.Fatalf("Expected consecutive user messages to be merged: %+v", received.Messages)
+	}
+	if received.MaxTokens == 0 {
+		t.Fatalf("Expected max_tokens to be set")
+	}
+
+	if resp.Choices[0].Message.Content != "Hello!" || resp.Choices[0].FinishReason != openai.FinishReasonStop {
+		t.Fatalf("Unexpected response: %+v", resp)
+	}
+	if resp.Usage.TotalTokens != 12 {
+		t.Fatalf("Expected usage to be converted, got %+v", resp.Usage)
+	}
+}
+
+func TestAnthropicClampsTemperature(t *testing.T) {
+	var received anthropicTestRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Error(err)
+		}
+		fmt.Fprint(w, `{"id":"msg","model":"claude","content":[{"type":"text","text":"Hello!"}],"stop_reason":"end_turn","usage":{"input_tokens":10,"output_tokens":2}}`)
+	}))
+	defer server.Close()
+
+	_, err := provider.NewAnthropic("key", server.URL).Complete(context.Background(), openai.ChatCompletionRequest{
+		Model:       "claude",
+		Temperature: 1.6,
+		Messages:    []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "Hi"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if received.Temperature != provider.AnthropicMaxTemperature {
+		t.Fatalf("Expected the temperature to be clamped to %d, got %v", provider.AnthropicMaxTemperature, received.Temperature)
+	}
+}
+
+func TestAnthropicStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		events := []string{
+			`{"type":"message_start","message":{"id":"msg"}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hel"}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"lo"}}`,
+			`{"type":"message_delta","delta":{"stop_reason":"max_tokens"}}`,
+			`{"type":"message_stop"}`,
+		}
+		for _, event := range events {
+			fmt.Fprintf(w, "event: x\ndata: %s\n\n", event)
+		}
+	}))
+	defer server.Close()
+
+	stream, err := provider.NewAnthropic("key", server.URL).Stream(context.Background(), openai.ChatCompletionRequest{
+		Model:    "claude",
+		Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "Hi"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	var text strings.Builder
+	var reason openai.FinishReason
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		text.WriteString(chunk.Choices[0].Delta.Content)
+		if chunk.Choices[0].FinishReason != "" {
+			reason = chunk.Choices[0].FinishReason
+		}
+	}
+
+	if text.String() != "Hello" || reason != openai.FinishReasonLength {
+		t.Fatalf("Unexpected stream result %q (%s)", text.String(), reason)
+	}
+}
+
+func TestAnthropicErrorsAreAPIErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"type":"error","error":{"type":"rate_limit_error","message":"slow down"}}`)
+	}))
+	defer server.Close()
+
+	_, err := provider.NewAnthropic("key", server.URL).Complete(context.Background(), openai.ChatCompletionRequest{Model: "claude"})
+
+	var apiErr *openai.APIError
+	if !errors.As(err, &apiErr) || apiErr.HTTPStatusCode != http.StatusTooManyRequests {
+		t.Fatalf("Expected an APIError with status 429, got %v", err)
+	}
+}
+
+func TestCompatibleDoesNotFlag(t *testing.T) {
+	resp, err := provider.NewCompatible("http://localhost:11434/v1", "").Moderate(context.Background(), openai.ModerationRequest{Input: "anything"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Results) != 1 || resp.Results[0].Flagged {
+		t.Fatalf("Expected a single unflagged result: %+v", resp)
+	}
+}
@@ -0,0 +1,166 @@
+package context_test
+
+import (
+	"BrainyBuddyGo/pkg/openaiclient/queue"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitForWaiting waits until n requests wait in q.
+func waitForWaiting(t *testing.T, q *queue.Queue, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for q.Stats().Waiting != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d waiting requests, got %+v", n, q.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func acquire(t *testing.T, q *queue.Queue, requester queue.Requester) func() {
+	t.Helper()
+	release, err := q.Acquire(queue.WithRequester(context.Background(), requester))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return release
+}
+
+// acquireAsync records the user of every request granted a worker in order.
+func acquireAsync(q *queue.Queue, requester queue.Requester, order chan<- string) {
+	go func() {
+		release, err := q.Acquire(queue.WithRequester(context.Background(), requester))
+		if err != nil {
+			order <- err.Error()
+			return
+		}
+		order <- requester.User
+		release()
+	}()
+}
+
+func TestQueueServesHigherPriorityFirst(t *testing.T) {
+	q := queue.New(1, 0, 0)
+	release := acquire(t, q, queue.Requester{User: "first"})
+
+	order := make(chan string, 3)
+	acquireAsync(q, queue.Requester{User: "member"}, order)
+	waitForWaiting(t, q, 1)
+	acquireAsync(q, queue.Requester{User: "another member"}, order)
+	waitForWaiting(t, q, 2)
+	acquireAsync(q, queue.Requester{User: "moderator", Priority: 10}, order)
+	waitForWaiting(t, q, 3)
+
+	release()
+	for _, want := range []string{"moderator", "member", "another member"} {
+		if got := <-order; got != want {
+			t.Fatalf("Expected %s to be served next, got %s", want, got)
+		}
+	}
+}
+
+func TestQueueLimitsWorkersPerUser(t *testing.T) {
+	q := queue.New(2, 0, 1)
+	release := acquire(t, q, queue.Requester{User: "alice"})
+
+	order := make(chan string, 2)
+	acquireAsync(q, queue.Requester{User: "alice"}, order)
+	waitForWaiting(t, q, 1)
+
+	// bob gets the free worker even though alice asked first
+	releaseBob := acquire(t, q, queue.Requester{User: "bob"})
+	if stats := q.Stats(); stats.Busy != 2 || stats.Waiting != 1 {
+		t.Fatalf("Expected alice to keep waiting, got %+v", stats)
+	}
+
+	release()
+	if got := <-order; got != "alice" {
+		t.Fatalf("Expected alice to be served, got %s", got)
+	}
+	releaseBob()
+}
+
+func TestQueueRejectsWhenFull(t *testing.T) {
+	q := queue.New(1, 1, 0)
+	release := acquire(t, q, queue.Requester{User: "alice"})
+	defer release()
+
+	order := make(chan string, 1)
+	acquireAsync(q, queue.Requester{User: "bob"}, order)
+	waitForWaiting(t, q, 1)
+
+	_, err := q.Acquire(queue.WithRequester(context.Background(), queue.Requester{User: "carol"}))
+	if !errors.Is(err, queue.ErrFull) {
+		t.Fatalf("Expected ErrFull, got %v", err)
+	}
+}
+
+func TestQueueReportsPositions(t *testing.T) {
+	q := queue.New(1, 0, 0)
+	release := acquire(t, q, queue.Requester{User: "alice"})
+
+	order := make(chan string, 2)
+	acquireAsync(q, queue.Requester{User: "bob"}, order)
+	waitForWaiting(t, q, 1)
+
+	var mutex sync.Mutex
+	var positions []int
+	acquireAsync(q, queue.Requester{User: "carol", OnPosition: func(position int) {
+		mutex.Lock()
+		positions = append(positions, position)
+		mutex.Unlock()
+	}}, order)
+	waitForWaiting(t, q, 2)
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		mutex.Lock()
+		reported := len(positions)
+		mutex.Unlock()
+		if reported > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected carol to be told her position")
+		}
+	}
+
+	release()
+	<-order
+	<-order
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(positions) != 2 || positions[0] != 2 || positions[1] != 1 {
+		t.Fatalf("Expected carol to move from #2 to #1, got %v", positions)
+	}
+}
+
+func TestQueueCancelAndClose(t *testing.T) {
+	q := queue.New(1, 0, 0)
+	release := acquire(t, q, queue.Requester{User: "alice"})
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := q.Acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the deadline to be exceeded, got %v", err)
+	}
+	if waiting := q.Stats().Waiting; waiting != 0 {
+		t.Fatalf("Expected the cancelled request to leave the queue, %d still waiting", waiting)
+	}
+
+	order := make(chan string, 1)
+	acquireAsync(q, queue.Requester{User: "bob"}, order)
+	waitForWaiting(t, q, 1)
+
+	q.Close()
+	if got := <-order; got != queue.ErrClosed.Error() {
+		t.Fatalf("Expected the waiting request to fail with ErrClosed, got %s", got)
+	}
+	if _, err := q.Acquire(context.Background()); !errors.Is(err, queue.ErrClosed) {
+		t.Fatalf("Expected ErrClosed, got %v", err)
+	}
+}
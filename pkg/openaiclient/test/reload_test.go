@@ -0,0 +1,114 @@
+package context_test
+
+import (
+	contextpkg "BrainyBuddyGo/pkg/openaiclient/context"
+	"BrainyBuddyGo/pkg/openaiclient/provider"
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// fakeProvider answers every completion with a fixed reply and records the requests.
+type fakeProvider struct {
+	fakeCompleter
+}
+
+func (f *fakeProvider) Stream(ctx context.Context, req openai.ChatCompletionRequest) (provider.ChatStream, error) {
+	return nil, errors.New("streaming is not supported by the fake provider")
+}
+
+func (f *fakeProvider) Moderate(ctx context.Context, req openai.ModerationRequest) (openai.ModerationResponse, error) {
+	return openai.ModerationResponse{}, nil
+}
+
+func writePrompt(t *testing.T, path string, welcome string) {
+	content := `{"normal": {"welcome": ["` + welcome + `"]}}`
+	if err := ioutil.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadSwapsPromptAndSettings(t *testing.T) {
+	dir := t.TempDir()
+	promptFile := filepath.Join(dir, "prompt.json")
+	writePrompt(t, promptFile, "first prompt")
+
+	fake := &fakeProvider{fakeCompleter{reply: "answer"}}
+	config := contextpkg.DefaultConfig("", 1)
+	config.PromptFile = promptFile
+
+	ctx, err := contextpkg.NewOpenAiContextWithConfig(config, false, contextpkg.WithProvider(fake))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ctx.GenerateResponse(context.Background(), "hello", contextpkg.Author{ID: "user"}, contextpkg.GenerationOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	writePrompt(t, filepath.Join(dir, "second.json"), "second prompt")
+	next := contextpkg.DefaultConfig("", 8)
+	next.PromptFile = filepath.Join(dir, "second.json")
+	next.Model = "other-model"
+
+	if err := ctx.Reload(next, false); err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Config.Workers != 1 {
+		t.Errorf("workers cannot change at runtime, got %d", ctx.Config.Workers)
+	}
+
+	if _, err := ctx.GenerateResponse(context.Background(), "hello again", contextpkg.Author{ID: "user"}, contextpkg.GenerationOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	last := fake.requests[len(fake.requests)-1]
+	if last.Model != "other-model" {
+		t.Errorf("expected the reloaded model, got %q", last.Model)
+	}
+	if !strings.Contains(last.Messages[0].Content, "second prompt") {
+		t.Errorf("expected the reloaded prompt, got %q", last.Messages[0].Content)
+	}
+}
+
+func TestReloadKeepsConfigOnInvalidPrompt(t *testing.T) {
+	dir := t.TempDir()
+	promptFile := filepath.Join(dir, "prompt.json")
+	writePrompt(t, promptFile, "first prompt")
+
+	config := contextpkg.DefaultConfig("", 1)
+	config.PromptFile = promptFile
+
+	fake := &fakeProvider{fakeCompleter{reply: "answer"}}
+	ctx, err := contextpkg.NewOpenAiContextWithConfig(config, false, contextpkg.WithProvider(fake))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broken := filepath.Join(dir, "broken.json")
+	if err := ioutil.WriteFile(broken, []byte("{not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	next := contextpkg.DefaultConfig("", 1)
+	next.PromptFile = broken
+	next.Model = "other-model"
+
+	if err := ctx.Reload(next, false); err == nil {
+		t.Fatal("expected an invalid prompt to be rejected")
+	}
+
+	if _, err := ctx.GenerateResponse(context.Background(), "hello", contextpkg.Author{ID: "user"}, contextpkg.GenerationOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	last := fake.requests[len(fake.requests)-1]
+	if last.Model != contextpkg.DefaultModel || !strings.Contains(last.Messages[0].Content, "first prompt") {
+		t.Errorf("the previous configuration should stay live, got %+v", last)
+	}
+}
@@ -0,0 +1,189 @@
+package context_test
+
+import (
+	contextpkg "BrainyBuddyGo/pkg/openaiclient/context"
+	"BrainyBuddyGo/pkg/openaiclient/openaitest"
+	"BrainyBuddyGo/pkg/openaiclient/provider"
+	"BrainyBuddyGo/pkg/openaiclient/retry"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+var chatRequest = openai.ChatCompletionRequest{
+	Model:    openai.GPT3Dot5Turbo,
+	Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "hi"}},
+}
+
+func fastPolicy(maxRetries int) retry.Policy {
+	policy := retry.NewPolicy(maxRetries)
+	policy.InitialInterval = time.Millisecond
+	policy.MaxInterval = time.Millisecond
+	return policy
+}
+
+func TestRetryable(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{&openai.APIError{HTTPStatusCode: http.StatusTooManyRequests}, true},
+		{&openai.APIError{HTTPStatusCode: http.StatusTooManyRequests, Type: "insufficient_quota"}, false},
+		{&openai.APIError{HTTPStatusCode: http.StatusServiceUnavailable}, true},
+		{&openai.APIError{HTTPStatusCode: http.StatusBadRequest}, false},
+		{&openai.APIError{HTTPStatusCode: http.StatusUnauthorized}, false},
+		{&openai.RequestError{HTTPStatusCode: http.StatusBadGateway}, true},
+		{fmt.Errorf("wrapped: %w", &openai.RequestError{HTTPStatusCode: http.StatusNotFound}), false},
+		{context.DeadlineExceeded, true},
+		{context.Canceled, false},
+		{io.ErrUnexpectedEOF, true},
+		{retry.Permanent(&openai.APIError{HTTPStatusCode: http.StatusInternalServerError}), false},
+		{errors.New("invalid character in response"), false},
+	}
+
+	for _, c := range cases {
+		if got := retry.Retryable(c.err); got != c.want {
+			t.Errorf("Retryable(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
+
+func TestRetryHonoursRetryAfter(t *testing.T) {
+	server := newTestServer(t)
+	server.EnqueueChat(openaitest.RateLimited(time.Second))
+	client := provider.NewOpenAIWithBaseURL(openaitest.APIKey, server.BaseURL())
+
+	var waits []time.Duration
+	policy := fastPolicy(2)
+	policy.OnRetry = func(attempt int, err error, wait time.Duration) {
+		waits = append(waits, wait)
+	}
+
+	start := time.Now()
+	err := policy.Do(context.Background(), func(ctx context.Context) error {
+		_, err := client.Complete(ctx, chatRequest)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(waits) != 1 || waits[0] != time.Second {
+		t.Fatalf("Expected a single wait of the Retry-After duration, got %v", waits)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("Expected to wait for the Retry-After duration, took %v", elapsed)
+	}
+	if got := len(server.Requests()); got != 2 {
+		t.Fatalf("Expected 2 requests, got %d", got)
+	}
+}
+
+func TestRetryFailsFastOnClientErrors(t *testing.T) {
+	server := newTestServer(t)
+	server.Default = openaitest.Error(http.StatusUnauthorized, "Incorrect API key provided")
+	client := provider.NewOpenAIWithBaseURL(openaitest.APIKey, server.BaseURL())
+
+	err := fastPolicy(3).Do(context.Background(), func(ctx context.Context) error {
+		_, err := client.Complete(ctx, chatRequest)
+		return err
+	})
+
+	var apiErr *openai.APIError
+	if !errors.As(err, &apiErr) || apiErr.HTTPStatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected the authentication error, got %v", err)
+	}
+	if attempts := retry.Attempts(err); attempts != 1 {
+		t.Fatalf("Expected a single attempt, got %d", attempts)
+	}
+	if got := len(server.Requests()); got != 1 {
+		t.Fatalf("Expected 1 request, got %d", got)
+	}
+}
+
+func TestRetryReportsAttempts(t *testing.T) {
+	server := newTestServer(t)
+	server.Default = openaitest.Error(http.StatusInternalServerError, "The server had an error")
+	client := provider.NewOpenAIWithBaseURL(openaitest.APIKey, server.BaseURL())
+
+	err := fastPolicy(2).Do(context.Background(), func(ctx context.Context) error {
+		_, err := client.Complete(ctx, chatRequest)
+		return err
+	})
+	if attempts := retry.Attempts(err); attempts != 3 {
+		t.Fatalf("Expected 3 attempts, got %d (%v)", attempts, err)
+	}
+}
+
+// flakyModerator fails the first moderation requests with a server error.
+type flakyModerator struct {
+	fakeProvider
+	failures int
+	calls    []time.Time
+}
+
+func (f *flakyModerator) Moderate(ctx context.Context, req openai.ModerationRequest) (openai.ModerationResponse, error) {
+	f.calls = append(f.calls, time.Now())
+	if len(f.calls) <= f.failures {
+		return openai.ModerationResponse{}, &openai.APIError{HTTPStatusCode: http.StatusServiceUnavailable, Message: "overloaded"}
+	}
+	return openai.ModerationResponse{Results: []openai.Result{{Flagged: false}}}, nil
+}
+
+func TestModerationWaitsBetweenRetries(t *testing.T) {
+	fake := &flakyModerator{failures: 2}
+	promptFile := filepath.Join(t.TempDir(), "prompt.json")
+	writePrompt(t, promptFile, "You are helpful.")
+
+	config := contextpkg.DefaultConfig("", 1)
+	config.PromptFile = promptFile
+	ctx, err := contextpkg.NewOpenAiContextWithConfig(config, false, contextpkg.WithProvider(fake))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(ctx.Close)
+
+	flagged, err := ctx.ModerationCheck(context.Background(), "Hello, how are you today?", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flagged {
+		t.Fatal("Expected the question not to be flagged")
+	}
+
+	if len(fake.calls) != 3 {
+		t.Fatalf("Expected 3 moderation requests, got %d", len(fake.calls))
+	}
+	minimum := time.Duration(float64(retry.DefaultInitialInterval) * (1 - retry.DefaultJitter))
+	if gap := fake.calls[1].Sub(fake.calls[0]); gap < minimum {
+		t.Fatalf("Expected to back off at least %v between retries, waited %v", minimum, gap)
+	}
+}
+
+func TestModerationMaxRetriesCountsEveryAttempt(t *testing.T) {
+	fake := &flakyModerator{failures: 2}
+	promptFile := filepath.Join(t.TempDir(), "prompt.json")
+	writePrompt(t, promptFile, "You are helpful.")
+
+	config := contextpkg.DefaultConfig("", 1)
+	config.PromptFile = promptFile
+	ctx, err := contextpkg.NewOpenAiContextWithConfig(config, false, contextpkg.WithProvider(fake))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(ctx.Close)
+
+	if _, err := ctx.ModerationCheck(context.Background(), "Hello, how are you today?", 2); err == nil {
+		t.Fatal("Expected moderation to fail once both attempts failed")
+	}
+	if len(fake.calls) != 2 {
+		t.Fatalf("Expected 2 moderation requests, got %d", len(fake.calls))
+	}
+}
@@ -0,0 +1,169 @@
+package context_test
+
+import (
+	contextpkg "BrainyBuddyGo/pkg/openaiclient/context"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func newConversation(content string, timestamp time.Time) contextpkg.CacheItem {
+	return contextpkg.CacheItem{
+		Conversation: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleUser, Content: content},
+		},
+		Timestamp: timestamp,
+	}
+}
+
+func runStoreSuite(t *testing.T, newStore func(t *testing.T) contextpkg.ConversationStore) {
+	t.Run("GetMissing", func(t *testing.T) {
+		store := newStore(t)
+		_, ok, err := store.Get("missing")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			t.Fatalf("Expected missing key to be absent")
+		}
+	})
+
+	t.Run("PutGet", func(t *testing.T) {
+		store := newStore(t)
+		value := contextpkg.UserCacheItem{
+			Conversations: []contextpkg.CacheItem{newConversation("hello", time.Now())},
+		}
+		if err := store.Put("user", value); err != nil {
+			t.Fatal(err)
+		}
+
+		got, ok, err := store.Get("user")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Fatalf("Expected stored key to be present")
+		}
+		if len(got.Conversations) != 1 || got.Conversations[0].Conversation[0].Content != "hello" {
+			t.Fatalf("Unexpected stored value: %+v", got)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		store := newStore(t)
+		if err := store.Put("user", contextpkg.UserCacheItem{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Delete("user"); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok, _ := store.Get("user"); ok {
+			t.Fatalf("Expected deleted key to be absent")
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		store := newStore(t)
+		for _, key := range []string{"b", "a", "c"} {
+			if err := store.Put(key, contextpkg.UserCacheItem{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		keys, err := store.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(keys)
+		if len(keys) != 3 || keys[0] != "a" || keys[1] != "b" || keys[2] != "c" {
+			t.Fatalf("Unexpected keys: %v", keys)
+		}
+	})
+
+	t.Run("Evict", func(t *testing.T) {
+		store := newStore(t)
+		now := time.Now()
+		old := now.Add(-2 * time.Hour)
+
+		mixed := contextpkg.UserCacheItem{
+			Conversations: []contextpkg.CacheItem{newConversation("old", old), newConversation("new", now)},
+		}
+		stale := contextpkg.UserCacheItem{
+			Conversations: []contextpkg.CacheItem{newConversation("old", old)},
+		}
+		if err := store.Put("mixed", mixed); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Put("stale", stale); err != nil {
+			t.Fatal(err)
+		}
+
+		deleted, err := store.Evict(now.Add(-time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if deleted != 1 {
+			t.Fatalf("Expected 1 deleted key but was %d", deleted)
+		}
+
+		if _, ok, _ := store.Get("stale"); ok {
+			t.Fatalf("Expected stale key to be evicted")
+		}
+
+		got, ok, err := store.Get("mixed")
+		if err != nil || !ok {
+			t.Fatalf("Expected mixed key to be kept: %v", err)
+		}
+		if len(got.Conversations) != 1 || got.Conversations[0].Conversation[0].Content != "new" {
+			t.Fatalf("Expected only the recent conversation to be kept: %+v", got)
+		}
+	})
+}
+
+func TestMemoryStore(t *testing.T) {
+	runStoreSuite(t, func(t *testing.T) contextpkg.ConversationStore {
+		return contextpkg.NewMemoryStore()
+	})
+}
+
+func TestBoltStore(t *testing.T) {
+	runStoreSuite(t, func(t *testing.T) contextpkg.ConversationStore {
+		store, err := contextpkg.NewBoltStore(filepath.Join(t.TempDir(), "conversations.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return store
+	})
+}
+
+func TestBoltStoreSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conversations.db")
+
+	store, err := contextpkg.NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := contextpkg.UserCacheItem{
+		Conversations: []contextpkg.CacheItem{newConversation("hello", time.Now())},
+	}
+	if err := store.Put("user", value); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = contextpkg.NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, ok, _ := store.Get("user"); !ok {
+		t.Fatalf("Expected conversation to survive reopening the store")
+	}
+}
@@ -0,0 +1,163 @@
+package context_test
+
+import (
+	"BrainyBuddyGo/pkg/openaiclient/breaker"
+	contextpkg "BrainyBuddyGo/pkg/openaiclient/context"
+	"BrainyBuddyGo/pkg/openaiclient/queue"
+	"BrainyBuddyGo/pkg/openaiclient/tokenizer"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+type fakeCompleter struct {
+	requests []openai.ChatCompletionRequest
+	reply    string
+}
+
+func (f *fakeCompleter) Complete(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	f.requests = append(f.requests, req)
+	return openai.ChatCompletionResponse{
+		Choices: []openai.ChatCompletionChoice{
+			{Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: f.reply}},
+		},
+	}, nil
+}
+
+func longConversation(turns int) contextpkg.CacheItem {
+	item := contextpkg.CacheItem{
+		Conversation: []openai.ChatCompletionMessage{turn(openai.ChatMessageRoleSystem, "prompt")},
+	}
+	for i := 0; i < turns; i++ {
+		item.Conversation = append(item.Conversation,
+			turn(openai.ChatMessageRoleUser, strings.Repeat("question ", 10)),
+			turn(openai.ChatMessageRoleAssistant, strings.Repeat("answer ", 10)),
+		)
+	}
+	return item
+}
+
+func newTestSummarizer(client contextpkg.ChatCompleter, threshold int) *contextpkg.Summarizer {
+	config := contextpkg.SummaryConfig{
+		Threshold:    threshold,
+		Model:        "summary-model",
+		MaxTokens:    50,
+		KeepMessages: 2,
+	}
+	return contextpkg.NewSummarizer(client, tokenizer.Estimator{}, config, queue.New(1, 0, 0))
+}
+
+func TestSummarizerBelowThreshold(t *testing.T) {
+	client := &fakeCompleter{reply: "summary"}
+	item := longConversation(2)
+
+	compacted, err := newTestSummarizer(client, 10000).Compact(context.Background(), item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(client.requests) != 0 {
+		t.Fatalf("Expected no summary request but got %d", len(client.requests))
+	}
+	if len(compacted.Conversation) != len(item.Conversation) || compacted.Summary != "" {
+		t.Fatalf("Expected conversation to be untouched")
+	}
+}
+
+func TestSummarizerCompactsOldTurns(t *testing.T) {
+	client := &fakeCompleter{reply: " they talked about questions "}
+	item := longConversation(4)
+
+	compacted, err := newTestSummarizer(client, 20).Compact(context.Background(), item)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(client.requests) != 1 {
+		t.Fatalf("Expected one summary request but got %d", len(client.requests))
+	}
+	req := client.requests[0]
+	if req.Model != "summary-model" || req.MaxTokens != 50 {
+		t.Fatalf("Summary request does not use the summary settings: %+v", req)
+	}
+
+	if compacted.Summary != "they talked about questions" {
+		t.Fatalf("Unexpected summary: %q", compacted.Summary)
+	}
+
+	// The prompt and the last question/answer pair are kept verbatim.
+	if len(compacted.Conversation) != 3 {
+		t.Fatalf("Expected 3 messages but got %d", len(compacted.Conversation))
+	}
+	if compacted.Conversation[0].Content != "prompt" || compacted.Conversation[1].Role != openai.ChatMessageRoleUser {
+		t.Fatalf("Unexpected compacted conversation: %+v", compacted.Conversation)
+	}
+}
+
+func TestSummarizerIncludesPreviousSummary(t *testing.T) {
+	client := &fakeCompleter{reply: "new summary"}
+	item := longConversation(4)
+	item.Summary = "old summary"
+
+	if _, err := newTestSummarizer(client, 20).Compact(context.Background(), item); err != nil {
+		t.Fatal(err)
+	}
+
+	transcript := client.requests[0].Messages[1].Content
+	if !strings.Contains(transcript, "old summary") {
+		t.Fatalf("Expected the previous summary in the transcript: %q", transcript)
+	}
+}
+
+func TestSummarizerNamesTheAuthors(t *testing.T) {
+	client := &fakeCompleter{reply: "summary"}
+	item := longConversation(4)
+	item.Conversation[1].Name = "bob"
+
+	if _, err := newTestSummarizer(client, 20).Compact(context.Background(), item); err != nil {
+		t.Fatal(err)
+	}
+
+	transcript := client.requests[0].Messages[1].Content
+	if !strings.Contains(transcript, "user (bob): question") || !strings.Contains(transcript, "assistant: answer") {
+		t.Fatalf("Expected the names of the authors in the transcript: %q", transcript)
+	}
+}
+
+// brokenCompleter fails every request as if the connection was cut.
+type brokenCompleter struct {
+	calls int
+}
+
+func (b *brokenCompleter) Complete(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	b.calls++
+	return openai.ChatCompletionResponse{}, io.ErrUnexpectedEOF
+}
+
+func TestSummarizerGoesThroughTheBreaker(t *testing.T) {
+	client := &brokenCompleter{}
+	summarizer := newTestSummarizer(client, 20)
+	summarizer.MaxRetries = 1
+	summarizer.Breaker = breaker.New(breaker.Config{FailureThreshold: 2, OpenTimeout: time.Hour})
+
+	if _, err := summarizer.Compact(context.Background(), longConversation(4)); err == nil {
+		t.Fatal("Expected the summary to fail")
+	}
+	if client.calls != 2 {
+		t.Errorf("Expected the configured retry, got %d calls", client.calls)
+	}
+	if state := summarizer.Breaker.Status().State; state != breaker.Open {
+		t.Fatalf("Expected the failures to open the breaker, got %v", state)
+	}
+
+	if _, err := summarizer.Compact(context.Background(), longConversation(4)); !errors.Is(err, breaker.ErrOpen) {
+		t.Errorf("Expected the open breaker to refuse the summary, got %v", err)
+	}
+	if client.calls != 2 {
+		t.Errorf("Expected no request while the breaker is open, got %d calls", client.calls)
+	}
+}
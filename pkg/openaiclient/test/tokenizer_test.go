@@ -0,0 +1,187 @@
+package context_test
+
+import (
+	contextpkg "BrainyBuddyGo/pkg/openaiclient/context"
+	"BrainyBuddyGo/pkg/openaiclient/tokenizer"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestPretokenize(t *testing.T) {
+	cases := map[string][]string{
+		"Hello world":    {"Hello", " world"},
+		"I'm here":       {"I", "'m", " here"},
+		"they'LL go":     {"they", "'LL", " go"},
+		"1234567":        {"123", "456", "7"},
+		"a\n\nb":         {"a", "\n\n", "b"},
+		"hi   there":     {"hi", "  ", " there"},
+		"end  ":          {"end", "  "},
+		"wait... what?!": {"wait", "...", " what", "?!"},
+		"x = (y + 1);\n": {"x", " =", " (", "y", " +", " ", "1", ");\n"},
+		"\tindented":     {"\tindented"},
+		"Привет, мир":    {"Привет", ",", " мир"},
+		"line\n  next":   {"line", "\n", " ", " next"},
+		"":               nil,
+		"  ":             {"  "},
+		"don't stop":     {"don", "'t", " stop"},
+	}
+
+	for input, expected := range cases {
+		got := tokenizer.Pretokenize(input)
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("Pretokenize(%q) = %q, expected %q", input, got, expected)
+		}
+	}
+}
+
+func writeRankFile(t *testing.T, tokens []string) string {
+	var sb strings.Builder
+	for rank, token := range tokens {
+		fmt.Fprintf(&sb, "%s %d\n", base64.StdEncoding.EncodeToString([]byte(token)), rank)
+	}
+
+	path := filepath.Join(t.TempDir(), "test.tiktoken")
+	if err := os.WriteFile(path, []byte(sb.String()), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestEncodingMergesLowestRankFirst(t *testing.T) {
+	// Ranks 0-4 are single bytes, "ab" merges before "bc" and "abc" completes the word.
+	path := writeRankFile(t, []string{"a", "b", "c", "d", " ", "ab", "bc", "abc", " d"})
+
+	encoding, err := tokenizer.LoadEncoding(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := encoding.Encode("abc"); !reflect.DeepEqual(got, []int{7}) {
+		t.Fatalf("Encode(abc) = %v, expected [7]", got)
+	}
+	if got := encoding.Encode("abcd d"); !reflect.DeepEqual(got, []int{7, 3, 8}) {
+		t.Fatalf("Encode(abcd d) = %v, expected [7 3 8]", got)
+	}
+	if got := encoding.Encode("cab"); !reflect.DeepEqual(got, []int{2, 5}) {
+		t.Fatalf("Encode(cab) = %v, expected [2 5]", got)
+	}
+}
+
+func TestCountMessages(t *testing.T) {
+	messages := []openai.ChatCompletionMessage{
+		{Role: "user", Content: "abcd"},
+		{Role: "user", Content: "abcd", Name: "abcd"},
+	}
+
+	// 3 for the reply, then per message 3 + role (1) + content (1), plus name (1) + 1.
+	if got := tokenizer.CountMessages(tokenizer.Estimator{}, messages); got != 15 {
+		t.Fatalf("Expected 15 tokens but was %d", got)
+	}
+}
+
+func TestEstimatorCountsOtherCharactersAsTokens(t *testing.T) {
+	cases := map[string]int{
+		"":      0,
+		"abcd":  1,
+		"abcde": 2,
+		"こんにちは": 5,
+		"café":  2,
+		"hi 👋👋": 3,
+	}
+	for text, expected := range cases {
+		if got := (tokenizer.Estimator{}).Count(text); got != expected {
+			t.Errorf("Count(%q) = %d, expected %d", text, got, expected)
+		}
+	}
+}
+
+func TestUsableWindowLeavesAMarginForEstimates(t *testing.T) {
+	if got := tokenizer.UsableWindow(tokenizer.Estimator{}, 1000); got != 800 {
+		t.Errorf("Expected 800 tokens usable with estimates, got %d", got)
+	}
+	if got := tokenizer.UsableWindow(tokenizer.NewEncoding(nil), 1000); got != 1000 {
+		t.Errorf("Expected the whole window usable with an encoding, got %d", got)
+	}
+}
+
+func TestContextWindow(t *testing.T) {
+	cases := map[string]int{
+		"gpt-3.5-turbo":          4096,
+		"gpt-3.5-turbo-16k-0613": 16384,
+		"gpt-4-0613":             8192,
+		"gpt-4-32k":              32768,
+		"unknown-model":          tokenizer.DefaultContextWindow,
+	}
+	for model, expected := range cases {
+		if got := tokenizer.ContextWindow(model); got != expected {
+			t.Errorf("ContextWindow(%s) = %d, expected %d", model, got, expected)
+		}
+	}
+}
+
+func turn(role string, content string) openai.ChatCompletionMessage {
+	return openai.ChatCompletionMessage{Role: role, Content: content}
+}
+
+func TestFitConversationKeepsShortConversation(t *testing.T) {
+	conversation := []openai.ChatCompletionMessage{
+		turn(openai.ChatMessageRoleSystem, "prompt"),
+		turn(openai.ChatMessageRoleUser, "question"),
+	}
+
+	fitted, err := contextpkg.FitConversation(tokenizer.Estimator{}, conversation, 10, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fitted, conversation) {
+		t.Fatalf("Expected conversation to be untouched: %v", fitted)
+	}
+}
+
+func TestFitConversationDropsOldestTurns(t *testing.T) {
+	long := strings.Repeat("word ", 20)
+	conversation := []openai.ChatCompletionMessage{
+		turn(openai.ChatMessageRoleSystem, "prompt"),
+		turn(openai.ChatMessageRoleUser, long),
+		turn(openai.ChatMessageRoleAssistant, long),
+		turn(openai.ChatMessageRoleUser, "recent"),
+		turn(openai.ChatMessageRoleAssistant, "answer"),
+		turn(openai.ChatMessageRoleUser, "question"),
+	}
+
+	maxTokens := 20
+	window := maxTokens + tokenizer.CountMessages(tokenizer.Estimator{}, conversation) - 1
+
+	fitted, err := contextpkg.FitConversation(tokenizer.Estimator{}, conversation, maxTokens, window)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []openai.ChatCompletionMessage{conversation[0], conversation[3], conversation[4], conversation[5]}
+	if !reflect.DeepEqual(fitted, expected) {
+		t.Fatalf("Expected oldest turn to be dropped, got %v", fitted)
+	}
+	if tokenizer.CountMessages(tokenizer.Estimator{}, fitted)+maxTokens > window {
+		t.Fatalf("Fitted conversation does not fit in the window")
+	}
+}
+
+func TestFitConversationFailsWhenQuestionTooLong(t *testing.T) {
+	conversation := []openai.ChatCompletionMessage{
+		turn(openai.ChatMessageRoleSystem, "prompt"),
+		turn(openai.ChatMessageRoleUser, strings.Repeat("word ", 100)),
+	}
+
+	_, err := contextpkg.FitConversation(tokenizer.Estimator{}, conversation, 10, 50)
+	if !errors.Is(err, contextpkg.ErrPromptTooLong) {
+		t.Fatalf("Expected ErrPromptTooLong but was %v", err)
+	}
+}
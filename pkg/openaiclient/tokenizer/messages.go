@@ -0,0 +1,53 @@
+package tokenizer
+
+import (
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	tokensPerMessage = 3
+	tokensPerName    = 1
+	// Every reply is primed with <|start|>assistant<|message|>.
+	tokensPerReply = 3
+
+	DefaultContextWindow = 4096
+)
+
+var contextWindows = map[string]int{
+	"gpt-3.5-turbo-16k": 16384,
+	"gpt-3.5-turbo":     4096,
+	"gpt-4-32k":         32768,
+	"gpt-4":             8192,
+}
+
+// CountMessages counts the prompt tokens of a chat completion request, following
+// the accounting described in OpenAI's cookbook for gpt-3.5-turbo and gpt-4.
+func CountMessages(counter Counter, messages []openai.ChatCompletionMessage) int {
+	total := tokensPerReply
+	for _, message := range messages {
+		total += tokensPerMessage
+		total += counter.Count(message.Role)
+		total += counter.Count(message.Content)
+		if message.Name != "" {
+			total += counter.Count(message.Name) + tokensPerName
+		}
+	}
+	return total
+}
+
+// ContextWindow returns the number of tokens the model accepts for the prompt
+// and the completion together.
+func ContextWindow(model string) int {
+	best := ""
+	for prefix := range contextWindows {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	if best == "" {
+		return DefaultContextWindow
+	}
+	return contextWindows[best]
+}
@@ -0,0 +1,137 @@
+package tokenizer
+
+import "unicode"
+
+// Pretokenize splits text the same way as the cl100k_base pattern:
+//
+//	(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+
+//
+// Go's regexp package has no lookahead, so the alternatives are matched by hand.
+func Pretokenize(text string) []string {
+	runes := []rune(text)
+	var pieces []string
+
+	for i := 0; i < len(runes); {
+		end := matchContraction(runes, i)
+		if end == i {
+			end = matchWord(runes, i)
+		}
+		if end == i {
+			end = matchNumber(runes, i)
+		}
+		if end == i {
+			end = matchPunctuation(runes, i)
+		}
+		if end == i {
+			end = matchWhitespace(runes, i)
+		}
+		if end == i {
+			end = i + 1
+		}
+
+		pieces = append(pieces, string(runes[i:end]))
+		i = end
+	}
+
+	return pieces
+}
+
+func isLetter(r rune) bool {
+	return unicode.IsLetter(r)
+}
+
+func isNumber(r rune) bool {
+	return unicode.IsNumber(r)
+}
+
+func isNewline(r rune) bool {
+	return r == '\r' || r == '\n'
+}
+
+func matchContraction(runes []rune, i int) int {
+	if runes[i] != '\'' || i+1 >= len(runes) {
+		return i
+	}
+
+	next := unicode.ToLower(runes[i+1])
+	if i+2 < len(runes) {
+		pair := string([]rune{next, unicode.ToLower(runes[i+2])})
+		if pair == "re" || pair == "ve" || pair == "ll" {
+			return i + 3
+		}
+	}
+
+	switch next {
+	case 's', 't', 'm', 'd':
+		return i + 2
+	}
+	return i
+}
+
+// matchWord matches [^\r\n\p{L}\p{N}]?\p{L}+
+func matchWord(runes []rune, i int) int {
+	j := i
+	if !isLetter(runes[j]) && !isNumber(runes[j]) && !isNewline(runes[j]) {
+		j++
+	}
+	if j >= len(runes) || !isLetter(runes[j]) {
+		return i
+	}
+	for j < len(runes) && isLetter(runes[j]) {
+		j++
+	}
+	return j
+}
+
+// matchNumber matches \p{N}{1,3}
+func matchNumber(runes []rune, i int) int {
+	j := i
+	for j < len(runes) && j-i < 3 && isNumber(runes[j]) {
+		j++
+	}
+	return j
+}
+
+// matchPunctuation matches ' ?[^\s\p{L}\p{N}]+[\r\n]*'
+func matchPunctuation(runes []rune, i int) int {
+	j := i
+	if runes[j] == ' ' {
+		j++
+	}
+	start := j
+	for j < len(runes) && !unicode.IsSpace(runes[j]) && !isLetter(runes[j]) && !isNumber(runes[j]) {
+		j++
+	}
+	if j == start {
+		return i
+	}
+	for j < len(runes) && isNewline(runes[j]) {
+		j++
+	}
+	return j
+}
+
+// matchWhitespace matches \s*[\r\n]+, then \s+(?!\S), then \s+
+func matchWhitespace(runes []rune, i int) int {
+	end := i
+	lastNewline := -1
+	for end < len(runes) && unicode.IsSpace(runes[end]) {
+		if isNewline(runes[end]) {
+			lastNewline = end
+		}
+		end++
+	}
+	if end == i {
+		return i
+	}
+
+	if lastNewline >= 0 {
+		return lastNewline + 1
+	}
+
+	// Leave the last whitespace character to prefix the following word.
+	if end < len(runes) && end-1 > i {
+		return end - 1
+	}
+	return end
+}
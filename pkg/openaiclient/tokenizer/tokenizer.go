@@ -0,0 +1,156 @@
+package tokenizer
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Counter counts the tokens a model would see for a piece of text.
+type Counter interface {
+	Count(text string) int
+}
+
+// Encoding is a byte pair encoder compatible with tiktoken's rank files, such
+// as cl100k_base.tiktoken used by gpt-3.5-turbo and gpt-4.
+type Encoding struct {
+	ranks map[string]int
+}
+
+// NewEncoding builds an encoding from a map of byte sequences to ranks.
+func NewEncoding(ranks map[string]int) *Encoding {
+	return &Encoding{ranks: ranks}
+}
+
+// LoadEncoding reads a tiktoken rank file, where every line holds a base64
+// encoded token and its rank separated by a space.
+func LoadEncoding(path string) (*Encoding, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open tokenizer file: %w", err)
+	}
+	defer file.Close()
+
+	ranks := make(map[string]int)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		token, rank, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("malformed tokenizer line: %q", line)
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(token)
+		if err != nil {
+			return nil, fmt.Errorf("malformed tokenizer token %q: %w", token, err)
+		}
+
+		value, err := strconv.Atoi(rank)
+		if err != nil {
+			return nil, fmt.Errorf("malformed tokenizer rank %q: %w", rank, err)
+		}
+
+		ranks[string(decoded)] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read tokenizer file: %w", err)
+	}
+
+	return NewEncoding(ranks), nil
+}
+
+// Encode returns the token ranks of the text.
+func (e *Encoding) Encode(text string) []int {
+	var tokens []int
+	for _, piece := range Pretokenize(text) {
+		if rank, ok := e.ranks[piece]; ok {
+			tokens = append(tokens, rank)
+			continue
+		}
+		tokens = append(tokens, e.bytePairEncode([]byte(piece))...)
+	}
+	return tokens
+}
+
+func (e *Encoding) Count(text string) int {
+	return len(e.Encode(text))
+}
+
+// bytePairEncode repeatedly merges the adjacent pair with the lowest rank,
+// exactly like tiktoken's byte_pair_merge.
+func (e *Encoding) bytePairEncode(piece []byte) []int {
+	// boundaries[i] is the start of the i-th part, the last entry is len(piece).
+	boundaries := make([]int, len(piece)+1)
+	for i := range boundaries {
+		boundaries[i] = i
+	}
+
+	for len(boundaries) > 2 {
+		minRank := math.MaxInt
+		minIndex := -1
+		for i := 0; i < len(boundaries)-2; i++ {
+			rank, ok := e.ranks[string(piece[boundaries[i]:boundaries[i+2]])]
+			if ok && rank < minRank {
+				minRank = rank
+				minIndex = i
+			}
+		}
+		if minIndex < 0 {
+			break
+		}
+		boundaries = append(boundaries[:minIndex+1], boundaries[minIndex+2:]...)
+	}
+
+	tokens := make([]int, 0, len(boundaries)-1)
+	for i := 0; i < len(boundaries)-1; i++ {
+		part := string(piece[boundaries[i]:boundaries[i+1]])
+		if rank, ok := e.ranks[part]; ok {
+			tokens = append(tokens, rank)
+			continue
+		}
+		// Every single byte has a rank in real encodings, count unknown ones
+		// as one token each so incomplete rank tables still give estimates.
+		tokens = append(tokens, -1)
+	}
+	return tokens
+}
+
+// Estimator approximates token counts without a rank file, using OpenAI's rule
+// of thumb of four characters per token for ASCII text. Other characters, such
+// as accented letters, CJK or emoji, usually take a token or more each and are
+// counted as one.
+type Estimator struct{}
+
+func (Estimator) Count(text string) int {
+	ascii, other := 0, 0
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return (ascii+3)/4 + other
+}
+
+// EstimateMargin is the share of the context window left unused when tokens
+// are only estimated, as the Estimator can still fall short of the real count.
+const EstimateMargin = 0.2
+
+// UsableWindow is how much of a context window a prompt counted with counter
+// can fill: all of it with an encoding, EstimateMargin less with an Estimator.
+func UsableWindow(counter Counter, contextWindow int) int {
+	if _, estimated := counter.(Estimator); estimated {
+		return int(float64(contextWindow) * (1 - EstimateMargin))
+	}
+	return contextWindow
+}
@@ -0,0 +1,153 @@
+package prompt
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var ErrNoProfiles = errors.New("no prompt profiles found")
+
+// Library holds the prompt profiles available to the bot and the profile used
+// when a channel does not pick one.
+type Library struct {
+	profiles       map[string]*Profile
+	defaultProfile string
+}
+
+// NewLibrary validates the profiles. defaultProfile may be empty when there is
+// a single profile.
+func NewLibrary(profiles []*Profile, defaultProfile string) (*Library, error) {
+	if len(profiles) == 0 {
+		return nil, ErrNoProfiles
+	}
+
+	library := &Library{
+		profiles:       make(map[string]*Profile, len(profiles)),
+		defaultProfile: defaultProfile,
+	}
+
+	for _, profile := range profiles {
+		if profile.Name == "" {
+			return nil, errors.New("prompt profile without a name")
+		}
+		if _, ok := library.profiles[profile.Name]; ok {
+			return nil, fmt.Errorf("duplicate prompt profile %s", profile.Name)
+		}
+		if err := profile.validate(); err != nil {
+			return nil, err
+		}
+		library.profiles[profile.Name] = profile
+	}
+
+	if library.defaultProfile == "" {
+		if len(profiles) > 1 {
+			return nil, fmt.Errorf("a default profile is required, available profiles: %s", strings.Join(library.Names(), ", "))
+		}
+		library.defaultProfile = profiles[0].Name
+	}
+
+	if _, ok := library.profiles[library.defaultProfile]; !ok {
+		return nil, fmt.Errorf("default prompt profile %s not found", library.defaultProfile)
+	}
+
+	return library, nil
+}
+
+// Profile returns the named profile, or the default one for an empty name. An
+// unknown name also falls back to the default profile but reports false.
+func (l *Library) Profile(name string) (*Profile, bool) {
+	if profile, ok := l.profiles[name]; ok {
+		return profile, true
+	}
+	return l.profiles[l.defaultProfile], name == ""
+}
+
+func (l *Library) Default() string {
+	return l.defaultProfile
+}
+
+func (l *Library) Names() []string {
+	names := make([]string, 0, len(l.profiles))
+	for name := range l.profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadDir reads one profile per .yaml, .yml or .json file of dir. Profiles
+// without a name are named after their file.
+func LoadDir(dir string) ([]*Profile, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read prompt directory: %w", err)
+	}
+
+	var profiles []*Profile
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".yaml" && ext != ".yml" && ext != ".json") {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read prompt profile: %w", err)
+		}
+
+		profile := &Profile{}
+		if err := yaml.Unmarshal(data, profile); err != nil {
+			return nil, fmt.Errorf("failed to decode prompt profile %s: %w", entry.Name(), err)
+		}
+		if profile.Name == "" {
+			profile.Name = strings.TrimSuffix(entry.Name(), ext)
+		}
+		profiles = append(profiles, profile)
+	}
+
+	return profiles, nil
+}
+
+// LoadLegacyFile reads the original prompt.json format, an object of profiles
+// mapping section names to lines. Sections keep the order of the file.
+func LoadLegacyFile(path string) ([]*Profile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read prompt file: %w", err)
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("failed to decode prompt file: %w", err)
+	}
+	if len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New("prompt file must contain an object of profiles")
+	}
+
+	var profiles []*Profile
+	document := root.Content[0]
+	for i := 0; i+1 < len(document.Content); i += 2 {
+		name, body := document.Content[i].Value, document.Content[i+1]
+		if body.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("prompt profile %s must be an object of sections", name)
+		}
+
+		profile := &Profile{Name: name}
+		for j := 0; j+1 < len(body.Content); j += 2 {
+			section := Section{Name: body.Content[j].Value}
+			if err := body.Content[j+1].Decode(&section.Lines); err != nil {
+				return nil, fmt.Errorf("prompt profile %s, section %s: %w", name, section.Name, err)
+			}
+			profile.Sections = append(profile.Sections, section)
+		}
+		profiles = append(profiles, profile)
+	}
+
+	return profiles, nil
+}
@@ -0,0 +1,120 @@
+package prompt
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	VariableUsername = "username"
+	VariableChannel  = "channel"
+	VariableDate     = "date"
+	DateFormat       = "2006-01-02"
+)
+
+// Section is a named part of a prompt. Its lines are joined with spaces.
+type Section struct {
+	Name  string   `yaml:"name"`
+	Lines []string `yaml:"lines"`
+}
+
+// Profile is a persona: sections assembled in order, with {{name}} placeholders
+// replaced by the built-in variables or the variables of the profile.
+type Profile struct {
+	Name      string            `yaml:"name"`
+	Sections  []Section         `yaml:"sections"`
+	Variables map[string]string `yaml:"variables"`
+}
+
+// Variables are the values of the built-in variables for a conversation.
+type Variables struct {
+	Username string
+	Channel  string
+	Date     time.Time
+}
+
+// Render assembles the sections of the profile and expands the placeholders.
+func (p *Profile) Render(vars Variables) string {
+	text, _ := expand(p.text(), func(name string) (string, bool) {
+		return p.lookup(name, vars)
+	})
+	return text
+}
+
+func (p *Profile) text() string {
+	sections := make([]string, 0, len(p.Sections))
+	for _, section := range p.Sections {
+		sections = append(sections, strings.Join(section.Lines, " "))
+	}
+	return strings.Join(sections, " ")
+}
+
+func (p *Profile) lookup(name string, vars Variables) (string, bool) {
+	switch name {
+	case VariableUsername:
+		return vars.Username, true
+	case VariableChannel:
+		return vars.Channel, true
+	case VariableDate:
+		return vars.Date.Format(DateFormat), true
+	}
+	value, ok := p.Variables[name]
+	return value, ok
+}
+
+// validate checks that the profile has content and that every placeholder
+// refers to a known variable, so rendering cannot fail later.
+func (p *Profile) validate() error {
+	if len(p.Sections) == 0 {
+		return fmt.Errorf("profile %s has no sections", p.Name)
+	}
+
+	for name := range p.Variables {
+		if isBuiltin(name) {
+			return fmt.Errorf("profile %s redefines the built-in variable %s", p.Name, name)
+		}
+	}
+
+	_, err := expand(p.text(), func(name string) (string, bool) {
+		return p.lookup(name, Variables{})
+	})
+	if err != nil {
+		return fmt.Errorf("profile %s: %w", p.Name, err)
+	}
+	return nil
+}
+
+func isBuiltin(name string) bool {
+	return name == VariableUsername || name == VariableChannel || name == VariableDate
+}
+
+// expand replaces the {{name}} placeholders of text with the values returned by
+// lookup. Spaces around the name are ignored.
+func expand(text string, lookup func(name string) (string, bool)) (string, error) {
+	var out strings.Builder
+
+	for {
+		start := strings.Index(text, "{{")
+		if start < 0 {
+			out.WriteString(text)
+			return out.String(), nil
+		}
+
+		end := strings.Index(text[start:], "}}")
+		if end < 0 {
+			return "", fmt.Errorf("unclosed placeholder %q", text[start:])
+		}
+		end += start
+
+		name := strings.TrimSpace(text[start+2 : end])
+		value, ok := lookup(name)
+		if !ok {
+			return "", fmt.Errorf("unknown variable %q", name)
+		}
+
+		out.WriteString(text[:start])
+		out.WriteString(value)
+		text = text[end+2:]
+	}
+}
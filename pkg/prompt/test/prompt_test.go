@@ -0,0 +1,138 @@
+package prompt_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"BrainyBuddyGo/pkg/prompt"
+)
+
+func writeFile(t *testing.T, path string, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRenderExpandsVariables(t *testing.T) {
+	profile := &prompt.Profile{
+		Name: "advisor",
+		Sections: []prompt.Section{
+			{Name: "intro", Lines: []string{"You are {{ persona }}.", "You talk to {{username}}"}},
+			{Name: "context", Lines: []string{"in #{{channel}} on {{date}}."}},
+		},
+		Variables: map[string]string{"persona": "a team advisor"},
+	}
+
+	library, err := prompt.NewLibrary([]*prompt.Profile{profile}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	selected, ok := library.Profile("")
+	if !ok || selected.Name != "advisor" {
+		t.Fatalf("expected the only profile to be the default, got %v", selected)
+	}
+
+	got := selected.Render(prompt.Variables{
+		Username: "alice",
+		Channel:  "general",
+		Date:     time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC),
+	})
+	want := "You are a team advisor. You talk to alice in #general on 2023-07-01."
+	if got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestNewLibraryRejectsInvalidProfiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		profiles []*prompt.Profile
+		want     string
+	}{
+		{"no profiles", nil, "no prompt profiles"},
+		{"unknown variable", []*prompt.Profile{{Name: "a", Sections: []prompt.Section{{Lines: []string{"{{missing}}"}}}}}, "unknown variable"},
+		{"unclosed placeholder", []*prompt.Profile{{Name: "a", Sections: []prompt.Section{{Lines: []string{"{{username"}}}}}, "unclosed"},
+		{"builtin redefined", []*prompt.Profile{{Name: "a", Sections: []prompt.Section{{Lines: []string{"hi"}}}, Variables: map[string]string{"date": "today"}}}, "built-in"},
+		{"no sections", []*prompt.Profile{{Name: "a"}}, "no sections"},
+		{"missing default", []*prompt.Profile{
+			{Name: "a", Sections: []prompt.Section{{Lines: []string{"a"}}}},
+			{Name: "b", Sections: []prompt.Section{{Lines: []string{"b"}}}},
+		}, "default profile is required"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := prompt.NewLibrary(test.profiles, "")
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("expected an error mentioning %q, got %v", test.want, err)
+			}
+		})
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "pirate.yaml"), `
+sections:
+  - name: persona
+    lines: ["Talk like a pirate to {{username}}."]
+`)
+	writeFile(t, filepath.Join(dir, "formal.json"), `{"name": "butler", "sections": [{"name": "persona", "lines": ["Be formal."]}]}`)
+	writeFile(t, filepath.Join(dir, "notes.txt"), "ignored")
+
+	profiles, err := prompt.LoadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	library, err := prompt.NewLibrary(profiles, "pirate")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if names := strings.Join(library.Names(), ","); names != "butler,pirate" {
+		t.Errorf("unexpected profiles %s", names)
+	}
+
+	profile, ok := library.Profile("unknown")
+	if ok || profile.Name != "pirate" {
+		t.Errorf("unknown profiles should fall back to the default, got %s (%v)", profile.Name, ok)
+	}
+}
+
+func TestLoadLegacyFileKeepsSectionOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prompt.json")
+	writeFile(t, path, `{
+  "normal": {"welcome": ["Hello."]},
+  "team-advisor": {
+    "welcome": ["Welcome", "to the app."],
+    "picking_phase": ["Pick."],
+    "app_settings": ["Settings."],
+    "banning_phase": ["Ban."]
+  }
+}`)
+
+	profiles, err := prompt.LoadLegacyFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	library, err := prompt.NewLibrary(profiles, "team-advisor")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The order of the file is kept on every load instead of the random order
+	// of a Go map.
+	for i := 0; i < 10; i++ {
+		profile, _ := library.Profile("")
+		got := profile.Render(prompt.Variables{})
+		if got != "Welcome to the app. Pick. Settings. Ban." {
+			t.Fatalf("unexpected prompt %q", got)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+// Package redis is a minimal client of the Redis protocol (RESP), enough to
+// keep small values in Redis or a compatible server such as Valkey or KeyDB.
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultTimeout = 5 * time.Second
+
+// ErrNil is returned for a missing value.
+var ErrNil = errors.New("redis: nil")
+
+// Error is an error reply of the server.
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Client sends commands over a single connection, one at a time. A broken
+// connection is dialled again by the next command.
+type Client struct {
+	addr     string
+	password string
+	db       int
+	timeout  time.Duration
+
+	conn   net.Conn
+	reader *bufio.Reader
+	mutex  sync.Mutex
+}
+
+// Options are the connection settings of a redis URL.
+type Options struct {
+	// Addr is the host and port of the server.
+	Addr     string
+	Password string
+	DB       int
+}
+
+// ParseURL reads a server given as redis://[:password@]host[:port][/db]. The
+// port defaults to 6379.
+func ParseURL(rawURL string) (Options, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return Options{}, fmt.Errorf("invalid redis URL: %w", err)
+	}
+	if parsed.Scheme != "redis" {
+		return Options{}, fmt.Errorf("invalid redis URL: unsupported scheme %q", parsed.Scheme)
+	}
+	if parsed.Hostname() == "" {
+		return Options{}, errors.New("invalid redis URL: missing host")
+	}
+
+	options := Options{Addr: parsed.Host}
+	if parsed.Port() == "" {
+		options.Addr = net.JoinHostPort(parsed.Hostname(), "6379")
+	}
+	if password, ok := parsed.User.Password(); ok {
+		options.Password = password
+	}
+	if db := strings.TrimPrefix(parsed.Path, "/"); db != "" {
+		if options.DB, err = strconv.Atoi(db); err != nil {
+			return Options{}, fmt.Errorf("invalid redis database %q", db)
+		}
+	}
+	return options, nil
+}
+
+// Dial connects to a server given as redis://[:password@]host[:port][/db].
+func Dial(rawURL string) (*Client, error) {
+	options, err := ParseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{addr: options.Addr, password: options.Password, db: options.DB, timeout: DefaultTimeout}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Do sends a command and returns its reply: a string for simple and bulk
+// strings, an int64 for integers and a []interface{} for arrays. Missing
+// values fail with ErrNil and error replies with an Error.
+func (c *Client) Do(args ...string) (interface{}, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return nil, err
+		}
+	}
+
+	reply, err := c.do(args...)
+	var replyErr Error
+	if err != nil && err != ErrNil && !errors.As(err, &replyErr) {
+		// The connection is in an unknown state after an I/O error.
+		c.conn.Close()
+		c.conn = nil
+	}
+	return reply, err
+}
+
+// String sends a command whose reply is a string.
+func (c *Client) String(args ...string) (string, error) {
+	reply, err := c.Do(args...)
+	if err != nil {
+		return "", err
+	}
+	s, ok := reply.(string)
+	if !ok {
+		return "", fmt.Errorf("redis: unexpected reply %T to %s", reply, args[0])
+	}
+	return s, nil
+}
+
+func (c *Client) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+func (c *Client) connect() error {
+	conn, err := net.DialTimeout("tcp", c.addr, c.timeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to redis: %w", err)
+	}
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+
+	if c.password != "" {
+		if _, err := c.do("AUTH", c.password); err != nil {
+			c.conn.Close()
+			c.conn = nil
+			return fmt.Errorf("failed to authenticate to redis: %w", err)
+		}
+	}
+	if c.db != 0 {
+		if _, err := c.do("SELECT", strconv.Itoa(c.db)); err != nil {
+			c.conn.Close()
+			c.conn = nil
+			return fmt.Errorf("failed to select redis database %d: %w", c.db, err)
+		}
+	}
+	return nil
+}
+
+func (c *Client) do(args ...string) (interface{}, error) {
+	if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, err
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&sb, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(c.conn, sb.String()); err != nil {
+		return nil, err
+	}
+
+	return ReadReply(c.reader)
+}
+
+// ReadReply reads a single RESP value.
+func ReadReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, Error(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid bulk length %q", line)
+		}
+		if size < 0 {
+			return nil, ErrNil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid array length %q", line)
+		}
+		if count < 0 {
+			return nil, ErrNil
+		}
+		values := make([]interface{}, count)
+		for i := range values {
+			values[i], err = ReadReply(r)
+			if err != nil && err != ErrNil {
+				return nil, err
+			}
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
@@ -0,0 +1,205 @@
+// Package redistest provides an in-process server speaking the subset of the
+// Redis protocol used by the bot, so tests run without a Redis server.
+package redistest
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"BrainyBuddyGo/pkg/redis"
+)
+
+// Server keeps strings and hashes in memory. Its data outlives the
+// connections, so a client can reconnect and find what it wrote.
+type Server struct {
+	// password, when set, has to be given with AUTH before any other command.
+	password string
+
+	listener net.Listener
+	strings  map[string]string
+	hashes   map[string]map[string]string
+	mutex    sync.Mutex
+	wg       sync.WaitGroup
+}
+
+// NewServer starts a server on a local port, the caller must Close it.
+func NewServer() *Server {
+	return NewServerWithPassword("")
+}
+
+// NewServerWithPassword starts a server refusing clients without password.
+func NewServerWithPassword(password string) *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("redistest: failed to listen: %v", err))
+	}
+
+	s := &Server{
+		password: password,
+		listener: listener,
+		strings:  make(map[string]string),
+		hashes:   make(map[string]map[string]string),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// Addr is the host and port the server listens on.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// URL is the address to give to redis.Dial, with the password when set.
+func (s *Server) URL() string {
+	if s.password != "" {
+		return fmt.Sprintf("redis://:%s@%s", s.password, s.Addr())
+	}
+	return "redis://" + s.Addr()
+}
+
+// Close stops accepting connections. Open connections end with their client.
+func (s *Server) Close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	authenticated := s.password == ""
+	for {
+		reply, err := redis.ReadReply(reader)
+		if err != nil {
+			return
+		}
+		values, ok := reply.([]interface{})
+		if !ok || len(values) == 0 {
+			return
+		}
+		args := make([]string, len(values))
+		for i, value := range values {
+			args[i], _ = value.(string)
+		}
+
+		var out string
+		switch name := strings.ToUpper(args[0]); {
+		case name == "AUTH":
+			authenticated = len(args) == 2 && args[1] == s.password
+			out = s.okOr(authenticated, "WRONGPASS invalid password")
+		case !authenticated:
+			out = "-NOAUTH Authentication required.\r\n"
+		default:
+			out = s.execute(name, args[1:])
+		}
+
+		if _, err := conn.Write([]byte(out)); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) okOr(ok bool, message string) string {
+	if ok {
+		return "+OK\r\n"
+	}
+	return "-" + message + "\r\n"
+}
+
+func (s *Server) execute(name string, args []string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch {
+	case name == "PING":
+		return "+PONG\r\n"
+	case name == "SELECT" && len(args) == 1:
+		return "+OK\r\n"
+	case name == "GET" && len(args) == 1:
+		value, ok := s.strings[args[0]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return bulk(value)
+	case name == "SET" && len(args) == 2:
+		s.strings[args[0]] = args[1]
+		return "+OK\r\n"
+	case name == "DEL" && len(args) >= 1:
+		deleted := 0
+		for _, key := range args {
+			if _, ok := s.strings[key]; ok {
+				delete(s.strings, key)
+				deleted++
+			}
+			if _, ok := s.hashes[key]; ok {
+				delete(s.hashes, key)
+				deleted++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", deleted)
+	case name == "HSET" && len(args) >= 3 && len(args)%2 == 1:
+		hash, ok := s.hashes[args[0]]
+		if !ok {
+			hash = make(map[string]string)
+			s.hashes[args[0]] = hash
+		}
+		added := 0
+		for i := 1; i < len(args); i += 2 {
+			if _, ok := hash[args[i]]; !ok {
+				added++
+			}
+			hash[args[i]] = args[i+1]
+		}
+		return fmt.Sprintf(":%d\r\n", added)
+	case name == "HDEL" && len(args) >= 2:
+		hash := s.hashes[args[0]]
+		deleted := 0
+		for _, field := range args[1:] {
+			if _, ok := hash[field]; ok {
+				delete(hash, field)
+				deleted++
+			}
+		}
+		if len(hash) == 0 {
+			delete(s.hashes, args[0])
+		}
+		return fmt.Sprintf(":%d\r\n", deleted)
+	case name == "HGETALL" && len(args) == 1:
+		hash := s.hashes[args[0]]
+		fields := make([]string, 0, len(hash))
+		for field := range hash {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "*%d\r\n", 2*len(fields))
+		for _, field := range fields {
+			sb.WriteString(bulk(field))
+			sb.WriteString(bulk(hash[field]))
+		}
+		return sb.String()
+	default:
+		return fmt.Sprintf("-ERR unknown command or wrong number of arguments for '%s'\r\n", strings.ToLower(name))
+	}
+}
+
+func bulk(value string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+}
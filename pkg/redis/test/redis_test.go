@@ -0,0 +1,217 @@
+package redis_test
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"BrainyBuddyGo/pkg/redis"
+	"BrainyBuddyGo/pkg/redis/redistest"
+)
+
+func TestReadReply(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  interface{}
+		err   error
+	}{
+		{"simple string", "+OK\r\n", "OK", nil},
+		{"error reply", "-ERR unknown command\r\n", nil, redis.Error("ERR unknown command")},
+		{"integer", ":42\r\n", int64(42), nil},
+		{"bulk string", "$5\r\nhello\r\n", "hello", nil},
+		{"empty bulk string", "$0\r\n\r\n", "", nil},
+		{"bulk string with a line break", "$7\r\nhel\r\nlo\r\n", "hel\r\nlo", nil},
+		{"nil bulk string", "$-1\r\n", nil, redis.ErrNil},
+		{"nil array", "*-1\r\n", nil, redis.ErrNil},
+		{"array with a nil element", "*3\r\n$1\r\na\r\n$-1\r\n:1\r\n", []interface{}{"a", nil, int64(1)}, nil},
+		{"nested array", "*2\r\n*1\r\n+x\r\n*0\r\n", []interface{}{[]interface{}{"x"}, []interface{}{}}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := redis.ReadReply(bufio.NewReader(strings.NewReader(test.input)))
+			if err != test.err {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("expected %#v, got %#v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestReadReplyInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"empty line", "\r\n"},
+		{"unknown type", "?what\r\n"},
+		{"invalid integer", ":many\r\n"},
+		{"invalid bulk length", "$five\r\nhello\r\n"},
+		{"invalid array length", "*two\r\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := redis.ReadReply(bufio.NewReader(strings.NewReader(test.input)))
+			var replyErr redis.Error
+			if err == nil || err == redis.ErrNil || errors.As(err, &replyErr) {
+				t.Errorf("expected a protocol error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestReadReplyPartial(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"nothing", ""},
+		{"line without its end", "+OK"},
+		{"bulk string cut short", "$5\r\nhel"},
+		{"bulk string without its end", "$5\r\nhello"},
+		{"array cut short", "*2\r\n+a\r\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := redis.ReadReply(bufio.NewReader(strings.NewReader(test.input)))
+			if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("expected an end of file error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestReadReplyFromFragmentedReads(t *testing.T) {
+	input := "*2\r\n$5\r\nhello\r\n$5\r\nworld\r\n"
+	got, err := redis.ReadReply(bufio.NewReader(iotest.OneByteReader(strings.NewReader(input))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{"hello", "world"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestParseURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want redis.Options
+		ok   bool
+	}{
+		{"redis://localhost", redis.Options{Addr: "localhost:6379"}, true},
+		{"redis://:secret@cache:6380/2", redis.Options{Addr: "cache:6380", Password: "secret", DB: 2}, true},
+		{"redis://cache/", redis.Options{Addr: "cache:6379"}, true},
+		{"http://localhost", redis.Options{}, false},
+		{"localhost:6379", redis.Options{}, false},
+		{"redis://", redis.Options{}, false},
+		{"redis://cache/limits", redis.Options{}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			got, err := redis.ParseURL(test.url)
+			if (err == nil) != test.ok {
+				t.Fatalf("expected ok to be %v, got %v", test.ok, err)
+			}
+			if got != test.want {
+				t.Errorf("expected %+v, got %+v", test.want, got)
+			}
+		})
+	}
+}
+
+func dial(t *testing.T, url string) *redis.Client {
+	client, err := redis.Dial(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestClientKeepsTheConnectionAfterAnErrorReply(t *testing.T) {
+	server := redistest.NewServer()
+	t.Cleanup(server.Close)
+	client := dial(t, server.URL())
+
+	if _, err := client.String("GET", "missing"); err != redis.ErrNil {
+		t.Errorf("expected ErrNil for a missing key, got %v", err)
+	}
+	var replyErr redis.Error
+	if _, err := client.Do("FLUSHALL"); !errors.As(err, &replyErr) {
+		t.Errorf("expected an error reply for an unknown command, got %v", err)
+	}
+
+	if _, err := client.Do("SET", "key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := client.String("GET", "key"); err != nil || value != "value" {
+		t.Errorf("expected value, got %q, %v", value, err)
+	}
+}
+
+func TestClientAuthentication(t *testing.T) {
+	server := redistest.NewServerWithPassword("secret")
+	t.Cleanup(server.Close)
+
+	if _, err := redis.Dial("redis://:wrong@" + server.Addr()); err == nil || !strings.Contains(err.Error(), "authenticate") {
+		t.Errorf("expected an authentication error, got %v", err)
+	}
+
+	client := dial(t, server.URL())
+	if value, err := client.String("PING"); err != nil || value != "PONG" {
+		t.Errorf("expected PONG, got %q, %v", value, err)
+	}
+}
+
+// truncatingServer answers the first connection with reply cut short and then
+// hangs up, later connections get a whole reply.
+func truncatingServer(t *testing.T, reply string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for first := true; ; first = false {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn, first bool) {
+				defer conn.Close()
+				if _, err := redis.ReadReply(bufio.NewReader(conn)); err != nil {
+					return
+				}
+				if first {
+					conn.Write([]byte(reply[:len(reply)/2]))
+					return
+				}
+				conn.Write([]byte(reply))
+			}(conn, first)
+		}
+	}()
+	return "redis://" + listener.Addr().String()
+}
+
+func TestClientReconnectsAfterAPartialReply(t *testing.T) {
+	client := dial(t, truncatingServer(t, "$5\r\nhello\r\n"))
+
+	if _, err := client.Do("GET", "key"); err == nil {
+		t.Fatal("expected the partial reply to fail")
+	}
+	if value, err := client.String("GET", "key"); err != nil || value != "hello" {
+		t.Errorf("expected the client to reconnect and read hello, got %q, %v", value, err)
+	}
+}